@@ -0,0 +1,73 @@
+package main
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+)
+
+var ErrInvalidLink = errors.New("enter a full http or https link, like https://example.com/page")
+
+// trackingParams are query parameters that only tell the linked site where
+// a visitor came from; utm_ parameters are dropped as well.
+var trackingParams = map[string]bool{"fbclid": true, "gclid": true}
+
+// canonicalURL normalizes a submitted link so that the same page is always
+// stored the same way: the scheme and host are lowercased, default ports,
+// tracking parameters, the fragment and trailing slashes are dropped, and
+// the remaining query parameters are sorted.
+func canonicalURL(raw string) (string, error) {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil {
+		return "", ErrInvalidLink
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return "", ErrInvalidLink
+	}
+
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]" // IPv6
+	}
+	if port := u.Port(); port != "" && !(u.Scheme == "http" && port == "80") && !(u.Scheme == "https" && port == "443") {
+		host += ":" + port
+	}
+	u.Host = host
+	u.Fragment, u.RawFragment = "", ""
+
+	u.Path = strings.TrimRight(u.Path, "/")
+	u.RawPath = strings.TrimRight(u.RawPath, "/")
+	if u.Path == "" {
+		u.Path, u.RawPath = "/", ""
+	}
+
+	query := u.Query()
+	for name := range query {
+		if trackingParams[strings.ToLower(name)] || strings.HasPrefix(strings.ToLower(name), "utm_") {
+			query.Del(name)
+		}
+	}
+	u.RawQuery = query.Encode()
+	u.ForceQuery = false
+	return u.String(), nil
+}
+
+// urlVariants returns the canonical link as it could have been submitted
+// over http or https, with or without "www.", for finding duplicates.
+func urlVariants(canonical string) []string {
+	u, err := url.Parse(canonical)
+	if err != nil {
+		return []string{canonical}
+	}
+	bare := strings.TrimPrefix(u.Host, "www.")
+	var variants []string
+	for _, scheme := range []string{"https", "http"} {
+		for _, host := range []string{bare, "www." + bare} {
+			v := *u
+			v.Scheme, v.Host = scheme, host
+			variants = append(variants, v.String())
+		}
+	}
+	return variants
+}
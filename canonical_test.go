@@ -0,0 +1,89 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCanonicalURL(t *testing.T) {
+	for raw, want := range map[string]string{
+		"HTTPS://Example.COM":                                  "https://example.com/",
+		"https://example.com/":                                 "https://example.com/",
+		"http://example.com:80/a/":                             "http://example.com/a",
+		"https://example.com:443/a//":                          "https://example.com/a",
+		"https://example.com:8443/a":                           "https://example.com:8443/a",
+		"https://example.com/a?utm_source=x&UTM_Medium=y&id=2": "https://example.com/a?id=2",
+		"https://example.com/a?fbclid=abc&b=2&a=1#section":     "https://example.com/a?a=1&b=2",
+		"https://example.com/a?":                               "https://example.com/a",
+		"  https://www.example.com./Path  ":                    "https://www.example.com/Path",
+		"http://[::1]:80/x":                                    "http://[::1]/x",
+		"https://example.com/a%2Fb/":                           "https://example.com/a%2Fb",
+	} {
+		got, err := canonicalURL(raw)
+		require.NoError(t, err, raw)
+		assert.Equal(t, want, got, raw)
+	}
+
+	for _, raw := range []string{"", "example.com", "ftp://example.com/file", "javascript:alert(1)", "https://", "http://%zz"} {
+		_, err := canonicalURL(raw)
+		assert.ErrorIs(t, err, ErrInvalidLink, raw)
+	}
+}
+
+func TestURLVariants(t *testing.T) {
+	assert.ElementsMatch(t, []string{
+		"https://example.com/a?b=1", "https://www.example.com/a?b=1",
+		"http://example.com/a?b=1", "http://www.example.com/a?b=1",
+	}, urlVariants("http://www.example.com/a?b=1"))
+}
+
+func TestSubmit_Duplicates(t *testing.T) {
+	defer cleanupTestData(t)
+	testApp.config.duplicateWindow = 24 * time.Hour
+	defer func() { testApp.config.duplicateWindow = 0 }()
+	author := createTestUser(t, "Author", "author@test.com")
+	other := createTestUser(t, "Other", "other@test.com")
+
+	w := serveAs(testApp.submit, author, http.MethodPost, "/submit",
+		url.Values{"title": {"Same title"}, "url": {"https://Example.com/story/?utm_source=hn#top"}})
+	require.Equal(t, http.StatusSeeOther, w.Code)
+	posts, _, err := testApp.postRepo.GetAll(Filter{Page: 1, PageSize: 10})
+	require.NoError(t, err)
+	require.Len(t, posts, 1)
+	assert.Equal(t, "https://example.com/story", posts[0].URL)
+	id := posts[0].ID
+
+	w = serveAs(testApp.submit, other, http.MethodPost, "/submit",
+		url.Values{"title": {"Different title"}, "url": {"http://www.example.com/story"}})
+	assert.Equal(t, http.StatusSeeOther, w.Code)
+	assert.Equal(t, "/comments?post_id="+strconv.Itoa(id), w.Header().Get("Location"))
+	post, err := testApp.postRepo.GetByID(id)
+	require.NoError(t, err)
+	assert.Equal(t, 1, post.VoteCount, "the duplicate submitter upvotes the original")
+
+	// submitting again neither fails nor votes twice
+	w = serveAs(testApp.submit, other, http.MethodPost, "/submit",
+		url.Values{"title": {"Different title"}, "url": {"https://example.com/story"}})
+	assert.Equal(t, "/comments?post_id="+strconv.Itoa(id), w.Header().Get("Location"))
+
+	// titles no longer need to be unique
+	w = serveAs(testApp.submit, other, http.MethodPost, "/submit",
+		url.Values{"title": {"Same title"}, "url": {"https://example.com/other"}})
+	assert.Equal(t, "/", w.Header().Get("Location"))
+
+	_, err = testDB.Exec("UPDATE posts SET created_at = '2000-01-01 00:00:00' WHERE id = ?", id)
+	require.NoError(t, err)
+	w = serveAs(testApp.submit, other, http.MethodPost, "/submit",
+		url.Values{"title": {"Resubmitted"}, "url": {"https://example.com/story"}})
+	assert.Equal(t, "/", w.Header().Get("Location"), "links can be submitted again after the window")
+
+	w = serveAs(testApp.submit, other, http.MethodPost, "/submit",
+		url.Values{"title": {"Bad link"}, "url": {"mailto:me@example.com"}})
+	assert.Contains(t, w.Body.String(), "enter a full http or https link")
+}
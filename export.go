@@ -0,0 +1,245 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// exportTTL is how long a finished data export can be downloaded.
+const exportTTL = 7 * 24 * time.Hour
+
+// exportPendingTimeout is how long an export may stay pending before it is
+// treated as abandoned, for instance because the server restarted while
+// building it, and marked failed so the user can request a new one.
+const exportPendingTimeout = time.Hour
+
+// exportTable is one dataset of an export, written to the archive both as
+// JSON and as CSV.
+type exportTable struct {
+	name   string
+	data   any
+	header []string
+	rows   [][]string
+}
+
+func exportTables(d *UserData) []exportTable {
+	ts := func(t time.Time) string { return t.UTC().Format(time.RFC3339) }
+
+	u := d.User
+	tables := []exportTable{{
+		name:   "user",
+		data:   u,
+		header: []string{"id", "name", "email", "is_admin", "status", "karma", "avatar", "created_at"},
+		rows: [][]string{{strconv.Itoa(u.ID), u.Name, u.Email, strconv.FormatBool(u.IsAdmin), u.Status,
+			strconv.Itoa(u.Karma), u.Profile.Avatar, ts(u.CreatedAt)}},
+	}}
+
+	posts := exportTable{name: "posts", data: d.Posts, header: []string{"id", "title", "url", "comments", "votes", "created_at"}}
+	for _, p := range d.Posts {
+		posts.rows = append(posts.rows, []string{strconv.Itoa(p.ID), p.Title, p.URL,
+			strconv.Itoa(p.CommentCount), strconv.Itoa(p.VoteCount), ts(p.CreatedAt)})
+	}
+	comments := exportTable{name: "comments", data: d.Comments, header: []string{"id", "post_id", "body", "created_at"}}
+	for _, c := range d.Comments {
+		comments.rows = append(comments.rows, []string{strconv.Itoa(c.ID), strconv.Itoa(c.PostID), c.Body, ts(c.CreatedAt)})
+	}
+	votes := exportTable{name: "votes", data: d.Votes, header: []string{"post_id", "post_title", "created_at"}}
+	for _, v := range d.Votes {
+		votes.rows = append(votes.rows, []string{strconv.Itoa(v.PostID), v.PostTitle, ts(v.CreatedAt)})
+	}
+	favorites := exportTable{name: "favorites", data: d.Favorites, header: []string{"post_id", "comment_id", "post_title", "created_at"}}
+	for _, f := range d.Favorites {
+		favorites.rows = append(favorites.rows, []string{strconv.Itoa(f.PostID), strconv.Itoa(f.CommentID), f.PostTitle, ts(f.CreatedAt)})
+	}
+	hidden := exportTable{name: "hidden", data: d.Hidden, header: []string{"post_id", "post_title", "created_at"}}
+	for _, h := range d.Hidden {
+		hidden.rows = append(hidden.rows, []string{strconv.Itoa(h.PostID), h.PostTitle, ts(h.CreatedAt)})
+	}
+	searches := exportTable{name: "searches", data: d.Searches, header: []string{"query", "email", "created_at"}}
+	for _, s := range d.Searches {
+		searches.rows = append(searches.rows, []string{s.Query, strconv.FormatBool(s.Email), ts(s.CreatedAt)})
+	}
+	sessions := exportTable{name: "sessions", data: d.Sessions, header: []string{"client_id", "kind", "scope", "expires_at", "created_at"}}
+	for _, s := range d.Sessions {
+		sessions.rows = append(sessions.rows, []string{s.ClientID, s.Kind, s.Scope, ts(s.ExpiresAt), ts(s.CreatedAt)})
+	}
+	invitations := exportTable{name: "invitations", data: d.Invitations, header: []string{"email", "note", "invitee_id", "created_at"}}
+	for _, inv := range d.Invitations {
+		invitations.rows = append(invitations.rows, []string{inv.Email, inv.Note, strconv.Itoa(inv.InviteeID), ts(inv.CreatedAt)})
+	}
+	logins := exportTable{name: "logins", data: d.Logins, header: []string{"email", "success", "ip", "user_agent", "created_at"}}
+	for _, e := range d.Logins {
+		logins.rows = append(logins.rows, []string{e.Email, strconv.FormatBool(e.Success), e.IP, e.UserAgent, ts(e.CreatedAt)})
+	}
+	return append(tables, posts, comments, votes, favorites, hidden, searches, sessions, invitations, logins)
+}
+
+// writeExportArchive writes d to w as a ZIP archive holding a JSON and a CSV
+// file per dataset.
+func writeExportArchive(w io.Writer, d *UserData) error {
+	zw := zip.NewWriter(w)
+	for _, t := range exportTables(d) {
+		f, err := zw.Create(t.name + ".json")
+		if err != nil {
+			return err
+		}
+		enc := json.NewEncoder(f)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(t.data); err != nil {
+			return err
+		}
+
+		f, err = zw.Create(t.name + ".csv")
+		if err != nil {
+			return err
+		}
+		cw := csv.NewWriter(f)
+		if err := cw.Write(t.header); err != nil {
+			return err
+		}
+		if err := cw.WriteAll(t.rows); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// generateExport builds the archive for a pending export and emails the
+// download link to the user.
+func (app *application) generateExport(e *DataExport, token string) error {
+	data, err := app.exportRepo.CollectUserData(e.UserID)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(app.config.exportDir, 0o700); err != nil {
+		return err
+	}
+	path := filepath.Join(app.config.exportDir, fmt.Sprintf("export-%d-%s.zip", e.ID, randomToken(8)))
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	if err := writeExportArchive(f, data); err != nil {
+		f.Close()
+		os.Remove(path)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(path)
+		return err
+	}
+	if err := app.exportRepo.FinishExport(e.ID, path); err != nil {
+		os.Remove(path)
+		return err
+	}
+
+	link := app.absoluteURL("/settings/export/download?token=" + url.QueryEscape(token))
+	body := fmt.Sprintf("Hi %s,\n\nthe copy of your hnews data is ready. Download it while logged in at:\n\n%s\n\n"+
+		"The link expires on %s.\n", data.User.Name, link, e.ExpiresAt.Format("January 2, 2006"))
+	// The archive is ready and listed under settings, so a mail failure
+	// must not fail the export.
+	if err := app.mailer.Send(data.User.Email, "Your hnews data export", body); err != nil {
+		app.errorLog.Printf("data export %d: emailing link: %v", e.ID, err)
+	}
+	return nil
+}
+
+func (app *application) settingsExport(w http.ResponseWriter, r *http.Request) {
+	if form := app.parseSettingsForm(w, r); form == nil {
+		return
+	}
+	u := app.getUserFromContext(r.Context())
+
+	latest, err := app.exportRepo.GetLatestExport(u.ID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	if latest != nil && latest.Status == exportStatusPending {
+		app.session.Put(r, "flash", "Your data export is already being prepared")
+		http.Redirect(w, r, "/settings", http.StatusSeeOther)
+		return
+	}
+
+	e, token, err := app.exportRepo.CreateExport(u.ID, exportTTL)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	// Large accounts can take a while, so the archive is built after the
+	// response and its link sent by email.
+	app.background(func() {
+		if err := app.generateExport(e, token); err != nil {
+			app.errorLog.Printf("data export %d: %v", e.ID, err)
+			app.exportRepo.FailExport(e.ID)
+		}
+	})
+
+	app.session.Put(r, "flash", fmt.Sprintf("We are preparing your data and will email a download link to %s", u.Email))
+	http.Redirect(w, r, "/settings", http.StatusSeeOther)
+}
+
+func (app *application) settingsExportDownload(w http.ResponseWriter, r *http.Request) {
+	u := app.getUserFromContext(r.Context())
+	e, err := app.exportRepo.GetExport(r.URL.Query().Get("token"))
+	if errors.Is(err, ErrInvalidToken) || (err == nil && (e.UserID != u.ID || e.Status != exportStatusReady)) {
+		app.session.Put(r, "flash", "This download link is invalid or has expired")
+		http.Redirect(w, r, "/settings", http.StatusSeeOther)
+		return
+	} else if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	f, err := os.Open(e.Path)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	defer f.Close()
+
+	name := fmt.Sprintf("hnews-export-%s.zip", e.CreatedAt.Format("2006-01-02"))
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+	w.Header().Set("Cache-Control", "no-store")
+	http.ServeContent(w, r, name, e.CreatedAt, f)
+}
+
+// expireDataExports fails abandoned pending exports and removes exports
+// whose download link has expired.
+func (app *application) expireDataExports(ctx context.Context) error {
+	n, err := app.exportRepo.FailStaleExports(time.Now().Add(-exportPendingTimeout))
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		app.infoLog.Printf("failed %d abandoned data exports", n)
+	}
+
+	exports, err := app.exportRepo.GetExpiredExports(time.Now())
+	if err != nil {
+		return err
+	}
+	for _, e := range exports {
+		if e.Path != "" {
+			if err := os.Remove(e.Path); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+		}
+		if err := app.exportRepo.DeleteExport(e.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
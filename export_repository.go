@@ -0,0 +1,365 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+const (
+	exportStatusPending = "pending"
+	exportStatusReady   = "ready"
+	exportStatusFailed  = "failed"
+)
+
+// DataExport is a user's request for a copy of their personal data. The
+// archive is written to Path once the export is ready and can be downloaded
+// with the token emailed to the user until ExpiresAt.
+type DataExport struct {
+	ID        int
+	UserID    int
+	Status    string
+	Path      string
+	ExpiresAt time.Time
+	CreatedAt time.Time
+}
+
+// Vote is a post the user voted for.
+type Vote struct {
+	PostID    int       `json:"post_id"`
+	PostTitle string    `json:"post_title"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Favorite is a post or comment the user favorited; CommentID is 0 for
+// posts.
+type Favorite struct {
+	PostID    int       `json:"post_id"`
+	CommentID int       `json:"comment_id,omitempty"`
+	PostTitle string    `json:"post_title"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// HiddenPost is a post the user hid from their front page.
+type HiddenPost struct {
+	PostID    int       `json:"post_id"`
+	PostTitle string    `json:"post_title"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// UserData is everything hnews stores about a user.
+type UserData struct {
+	User        *User         `json:"user"`
+	Posts       []Post        `json:"posts"`
+	Comments    []Comment     `json:"comments"`
+	Votes       []Vote        `json:"votes"`
+	Favorites   []Favorite    `json:"favorites"`
+	Hidden      []HiddenPost  `json:"hidden"`
+	Searches    []SavedSearch `json:"searches"`
+	Sessions    []OAuthToken  `json:"sessions"`
+	Invitations []Invitation  `json:"invitations"`
+	Logins      []LoginEvent  `json:"logins"`
+}
+
+type ExportRepository interface {
+	CreateExport(userID int, ttl time.Duration) (*DataExport, string, error)
+	FinishExport(id int, path string) error
+	FailExport(id int) error
+	FailStaleExports(before time.Time) (int, error)
+	GetExport(token string) (*DataExport, error)
+	GetLatestExport(userID int) (*DataExport, error)
+	GetExpiredExports(now time.Time) ([]DataExport, error)
+	DeleteExport(id int) error
+	CollectUserData(userID int) (*UserData, error)
+}
+
+type SQLExportRepository struct {
+	db *sql.DB
+}
+
+// NewSQLExportRepository creates a new instance of SQLExportRepository
+func NewSQLExportRepository(db *sql.DB) *SQLExportRepository {
+	return &SQLExportRepository{db: db}
+}
+
+const exportSelect = "SELECT id, user_id, status, path, expires_at, created_at FROM data_exports"
+
+func scanExport(row rowScanner) (*DataExport, error) {
+	var e DataExport
+	if err := row.Scan(&e.ID, &e.UserID, &e.Status, &e.Path, &e.ExpiresAt, &e.CreatedAt); err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+// CreateExport records a pending export and returns it with the download
+// token; only the token's hash is stored.
+func (r *SQLExportRepository) CreateExport(userID int, ttl time.Duration) (*DataExport, string, error) {
+	token := randomToken(32)
+	e := &DataExport{
+		UserID:    userID,
+		Status:    exportStatusPending,
+		ExpiresAt: time.Now().Add(ttl).UTC(),
+		CreatedAt: time.Now().UTC(),
+	}
+	stmt := "INSERT INTO data_exports (user_id, token_hash, status, expires_at, created_at) VALUES (?, ?, ?, ?, ?)"
+	result, err := r.db.Exec(stmt, userID, hashToken(token), e.Status, e.ExpiresAt, e.CreatedAt)
+	if err != nil {
+		return nil, "", err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return nil, "", err
+	}
+	e.ID = int(id)
+	return e, token, nil
+}
+
+func (r *SQLExportRepository) FinishExport(id int, path string) error {
+	_, err := r.db.Exec("UPDATE data_exports SET status = ?, path = ? WHERE id = ?", exportStatusReady, path, id)
+	return err
+}
+
+func (r *SQLExportRepository) FailExport(id int) error {
+	_, err := r.db.Exec("UPDATE data_exports SET status = ? WHERE id = ?", exportStatusFailed, id)
+	return err
+}
+
+// FailStaleExports marks exports still pending since before as failed and
+// returns how many there were.
+func (r *SQLExportRepository) FailStaleExports(before time.Time) (int, error) {
+	result, err := r.db.Exec("UPDATE data_exports SET status = ? WHERE status = ? AND created_at < ?",
+		exportStatusFailed, exportStatusPending, before.UTC())
+	if err != nil {
+		return 0, err
+	}
+	n, err := result.RowsAffected()
+	return int(n), err
+}
+
+// GetExport returns the unexpired export for a download token, or
+// ErrInvalidToken.
+func (r *SQLExportRepository) GetExport(token string) (*DataExport, error) {
+	e, err := scanExport(r.db.QueryRow(exportSelect+" WHERE token_hash = ?", hashToken(token)))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrInvalidToken
+	} else if err != nil {
+		return nil, err
+	}
+	if time.Now().After(e.ExpiresAt) {
+		return nil, ErrInvalidToken
+	}
+	return e, nil
+}
+
+// GetLatestExport returns the user's most recent unexpired export, or nil if
+// there is none.
+func (r *SQLExportRepository) GetLatestExport(userID int) (*DataExport, error) {
+	stmt := exportSelect + " WHERE user_id = ? AND expires_at > ? ORDER BY id DESC LIMIT 1"
+	e, err := scanExport(r.db.QueryRow(stmt, userID, time.Now().UTC()))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return e, err
+}
+
+func (r *SQLExportRepository) GetExpiredExports(now time.Time) ([]DataExport, error) {
+	rows, err := r.db.Query(exportSelect+" WHERE expires_at <= ?", now.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var exports []DataExport
+	for rows.Next() {
+		e, err := scanExport(rows)
+		if err != nil {
+			return nil, err
+		}
+		exports = append(exports, *e)
+	}
+	return exports, rows.Err()
+}
+
+func (r *SQLExportRepository) DeleteExport(id int) error {
+	_, err := r.db.Exec("DELETE FROM data_exports WHERE id = ?", id)
+	return err
+}
+
+// CollectUserData loads the user's account, posts, comments, votes,
+// favorites, hidden posts, saved searches, API sessions, sent invitations and login history for an export.
+func (r *SQLExportRepository) CollectUserData(userID int) (*UserData, error) {
+	u, err := scanUser(r.db.QueryRow(userSelect+" WHERE u.id = ?", userID))
+	if err != nil {
+		return nil, err
+	}
+	data := &UserData{User: u}
+
+	rows, err := r.db.Query(`SELECT p.id, p.title, p.url, p.user_id, u.name, p.created_at,
+		(SELECT COUNT(*) FROM comments c WHERE c.post_id = p.id),
+		(SELECT COUNT(*) FROM votes v WHERE v.post_id = p.id)
+		FROM posts p INNER JOIN users u ON u.id = p.user_id
+		WHERE p.user_id = ? ORDER BY p.created_at`, userID)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var p Post
+		if err := rows.Scan(&p.ID, &p.Title, &p.URL, &p.UserID, &p.UserName, &p.CreatedAt, &p.CommentCount, &p.VoteCount); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		data.Posts = append(data.Posts, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = r.db.Query(`SELECT c.id, c.body, c.user_id, c.post_id, u.name, c.created_at
+		FROM comments c INNER JOIN users u ON u.id = c.user_id
+		WHERE c.user_id = ? ORDER BY c.created_at`, userID)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var c Comment
+		if err := rows.Scan(&c.ID, &c.Body, &c.UserID, &c.PostID, &c.UserName, &c.CreatedAt); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		data.Comments = append(data.Comments, c)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = r.db.Query(`SELECT v.post_id, p.title, v.created_at
+		FROM votes v INNER JOIN posts p ON p.id = v.post_id
+		WHERE v.user_id = ? ORDER BY v.created_at`, userID)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var v Vote
+		if err := rows.Scan(&v.PostID, &v.PostTitle, &v.CreatedAt); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		data.Votes = append(data.Votes, v)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = r.db.Query(`SELECT f.post_id, 0, p.title, f.created_at
+		FROM favorite_posts f INNER JOIN posts p ON p.id = f.post_id WHERE f.user_id = ?1
+		UNION ALL
+		SELECT c.post_id, f.comment_id, p.title, f.created_at
+		FROM favorite_comments f INNER JOIN comments c ON c.id = f.comment_id INNER JOIN posts p ON p.id = c.post_id
+		WHERE f.user_id = ?1
+		ORDER BY 4`, userID)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var f Favorite
+		if err := rows.Scan(&f.PostID, &f.CommentID, &f.PostTitle, &f.CreatedAt); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		data.Favorites = append(data.Favorites, f)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = r.db.Query(`SELECT h.post_id, p.title, h.created_at
+		FROM hidden_posts h INNER JOIN posts p ON p.id = h.post_id
+		WHERE h.user_id = ? ORDER BY h.created_at`, userID)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var h HiddenPost
+		if err := rows.Scan(&h.PostID, &h.PostTitle, &h.CreatedAt); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		data.Hidden = append(data.Hidden, h)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = r.db.Query(`SELECT query, email, created_at FROM saved_searches
+		WHERE user_id = ? ORDER BY created_at, id`, userID)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var s SavedSearch
+		if err := rows.Scan(&s.Query, &s.Email, &s.CreatedAt); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		data.Searches = append(data.Searches, s)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = r.db.Query(`SELECT client_id, user_id, kind, scope, expires_at, created_at
+		FROM oauth_tokens WHERE user_id = ? ORDER BY created_at`, userID)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var t OAuthToken
+		if err := rows.Scan(&t.ClientID, &t.UserID, &t.Kind, &t.Scope, &t.ExpiresAt, &t.CreatedAt); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		data.Sessions = append(data.Sessions, t)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = r.db.Query(invitationSelect+" WHERE i.inviter_id = ? ORDER BY i.created_at", userID)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		inv, err := scanInvitation(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		data.Invitations = append(data.Invitations, *inv)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = r.db.Query(loginEventSelect+" WHERE user_id = ? ORDER BY created_at, id", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		e, err := scanLoginEvent(rows)
+		if err != nil {
+			return nil, err
+		}
+		data.Logins = append(data.Logins, *e)
+	}
+	return data, rows.Err()
+}
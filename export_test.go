@@ -0,0 +1,125 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"net/http"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func readZip(t *testing.T, b []byte) map[string][]byte {
+	zr, err := zip.NewReader(bytes.NewReader(b), int64(len(b)))
+	require.NoError(t, err)
+	files := make(map[string][]byte)
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		require.NoError(t, err)
+		content, err := io.ReadAll(rc)
+		rc.Close()
+		require.NoError(t, err)
+		files[f.Name] = content
+	}
+	return files
+}
+
+func TestWriteExportArchive(t *testing.T) {
+	data := &UserData{
+		User:     &User{ID: 1, Name: "Ada", Email: "ada@test.com", Status: userStatusActive, HashedPassword: "secret-hash"},
+		Posts:    []Post{{ID: 2, Title: "Hello, world", URL: "https://example.com"}},
+		Comments: []Comment{{ID: 3, PostID: 2, Body: "multi\nline"}},
+		Votes:    []Vote{{PostID: 2, PostTitle: "Hello, world"}},
+	}
+	var buf bytes.Buffer
+	require.NoError(t, writeExportArchive(&buf, data))
+	files := readZip(t, buf.Bytes())
+
+	for _, name := range []string{"user", "posts", "comments", "votes", "favorites", "hidden", "searches", "sessions", "invitations", "logins"} {
+		assert.Contains(t, files, name+".json")
+		assert.Contains(t, files, name+".csv")
+	}
+	assert.NotContains(t, string(files["user.json"]), "secret-hash")
+
+	var posts []Post
+	require.NoError(t, json.Unmarshal(files["posts.json"], &posts))
+	assert.Equal(t, "Hello, world", posts[0].Title)
+
+	records, err := csv.NewReader(bytes.NewReader(files["comments.csv"])).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+	assert.Equal(t, "multi\nline", records[1][2])
+}
+
+func TestSettingsExport_DeliversExpiringLink(t *testing.T) {
+	defer cleanupTestData(t)
+	u := createTestUser(t, "Export User", "export@test.com")
+	postID, err := testApp.postRepo.CreatePost("Exported post", "https://example.com", u.ID)
+	require.NoError(t, err)
+	_, err = testApp.postRepo.AddComment(u.ID, postID, "Exported comment")
+	require.NoError(t, err)
+
+	w := serveAs(testApp.settingsExport, u, http.MethodPost, "/settings/export", nil)
+	assert.Equal(t, http.StatusSeeOther, w.Code)
+	testApp.wg.Wait()
+
+	mail, ok := testApp.mailer.(*testMailer).last("export@test.com")
+	require.True(t, ok)
+	token := regexp.MustCompile(`token=(\S+)`).FindStringSubmatch(mail.Body)
+	require.Len(t, token, 2)
+
+	other := createTestUser(t, "Someone Else", "other@test.com")
+	w = serveAs(testApp.settingsExportDownload, other, http.MethodGet, "/settings/export/download?token="+token[1], nil)
+	assert.Equal(t, http.StatusSeeOther, w.Code, "links only work for their owner")
+
+	w = serveAs(testApp.settingsExportDownload, u, http.MethodGet, "/settings/export/download?token="+token[1], nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/zip", w.Header().Get("Content-Type"))
+	files := readZip(t, w.Body.Bytes())
+	assert.Contains(t, string(files["posts.csv"]), "Exported post")
+	assert.Contains(t, string(files["comments.json"]), "Exported comment")
+
+	// Once expired, the archive is removed and the link stops working.
+	require.NoError(t, testApp.expireDataExports(context.Background()))
+	export, err := testApp.exportRepo.GetExport(token[1])
+	require.NoError(t, err)
+	_, err = testDB.Exec("UPDATE data_exports SET expires_at = ? WHERE id = ?", time.Now().Add(-time.Minute).UTC(), export.ID)
+	require.NoError(t, err)
+	require.NoError(t, testApp.expireDataExports(context.Background()))
+	assert.NoFileExists(t, export.Path)
+
+	w = serveAs(testApp.settingsExportDownload, u, http.MethodGet, "/settings/export/download?token="+token[1], nil)
+	assert.Equal(t, http.StatusSeeOther, w.Code)
+}
+
+func TestExpireDataExports_FailsAbandonedExports(t *testing.T) {
+	defer cleanupTestData(t)
+	u := createTestUser(t, "Export User", "export@test.com")
+	e, _, err := testApp.exportRepo.CreateExport(u.ID, exportTTL)
+	require.NoError(t, err)
+
+	require.NoError(t, testApp.expireDataExports(context.Background()))
+	latest, err := testApp.exportRepo.GetLatestExport(u.ID)
+	require.NoError(t, err)
+	assert.Equal(t, exportStatusPending, latest.Status, "recent exports are still being built")
+
+	_, err = testDB.Exec("UPDATE data_exports SET created_at = ? WHERE id = ?", time.Now().Add(-2*exportPendingTimeout).UTC(), e.ID)
+	require.NoError(t, err)
+	require.NoError(t, testApp.expireDataExports(context.Background()))
+	latest, err = testApp.exportRepo.GetLatestExport(u.ID)
+	require.NoError(t, err)
+	assert.Equal(t, exportStatusFailed, latest.Status)
+
+	w := serveAs(testApp.settingsExport, u, http.MethodPost, "/settings/export", nil)
+	assert.Equal(t, http.StatusSeeOther, w.Code)
+	testApp.wg.Wait()
+	_, ok := testApp.mailer.(*testMailer).last("export@test.com")
+	assert.True(t, ok, "a new export can be requested")
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// favorite adds the posted post_id or comment_id to the user's favorites,
+// or removes it when un is set, and returns to the page the form was on.
+func (app *application) favorite(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	u := app.getUserFromContext(r.Context())
+	favorite := r.PostForm.Get("un") == ""
+
+	var err error
+	if postID, _ := strconv.Atoi(r.PostForm.Get("post_id")); postID > 0 {
+		err = app.postRepo.SetPostFavorite(u.ID, postID, favorite)
+	} else if commentID, _ := strconv.Atoi(r.PostForm.Get("comment_id")); commentID > 0 {
+		err = app.postRepo.SetCommentFavorite(u.ID, commentID, favorite)
+	} else {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		app.session.Put(r, "flash", "item not found")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	} else if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	if favorite {
+		app.session.Put(r, "flash", "Added to your favorites")
+	} else {
+		app.session.Put(r, "flash", "Removed from your favorites")
+	}
+	fallback := fmt.Sprintf("/favorites?id=%d", u.ID)
+	http.Redirect(w, r, safeRedirect(r.PostForm.Get("redirectTo"), fallback), http.StatusSeeOther)
+}
+
+// favorites lists a user's favorite posts, or their favorite comments with
+// comments=t. Favorites are public unless the user made them private.
+func (app *application) favorites(w http.ResponseWriter, r *http.Request) {
+	member := app.memberFromQuery(w, r)
+	if member == nil {
+		return
+	}
+	viewer, _ := r.Context().Value(contextUserKey).(*User)
+	data := &templateData{
+		Member:        member,
+		OwnFavorites:  viewer != nil && viewer.ID == member.ID,
+		ShowComments:  r.URL.Query().Get("comments") == "t",
+		FavoritesOpen: member.FavoritesPublic || (viewer != nil && viewer.ID == member.ID),
+	}
+	if !data.FavoritesOpen {
+		app.render(w, r, "favorites.html", data)
+		return
+	}
+
+	filter := app.userFilter(r)
+	var err error
+	if data.ShowComments {
+		data.Comments, data.Metadata, err = app.postRepo.GetFavoriteComments(member.ID, filter)
+	} else {
+		data.Posts, data.Metadata, err = app.postRepo.GetFavoritePosts(member.ID, filter)
+	}
+	if errors.Is(err, ErrInvalidPageSize) {
+		app.session.Put(r, "flash", err.Error())
+		http.Redirect(w, r, fmt.Sprintf("/favorites?id=%d", member.ID), http.StatusSeeOther)
+		return
+	} else if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	link := fmt.Sprintf("/favorites?id=%d&page=%%d&page_size=%d", member.ID, filter.PageSize)
+	if data.ShowComments {
+		link += "&comments=t"
+	}
+	data.NextLink = fmt.Sprintf(link, data.Metadata.NextPage)
+	data.PrevLink = fmt.Sprintf(link, data.Metadata.PrevPage)
+	app.render(w, r, "favorites.html", data)
+}
+
+func (app *application) settingsFavorites(w http.ResponseWriter, r *http.Request) {
+	form := app.parseSettingsForm(w, r)
+	if form == nil {
+		return
+	}
+	u := app.getUserFromContext(r.Context())
+	public := form.Get("favorites_public") != ""
+	if err := app.userRepo.UpdateFavoritesPublic(u.ID, public); err != nil {
+		app.serverError(w, err)
+		return
+	}
+	if public {
+		app.session.Put(r, "flash", "Your favorites are now public")
+	} else {
+		app.session.Put(r, "flash", "Your favorites are now private")
+	}
+	http.Redirect(w, r, "/settings", http.StatusSeeOther)
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"net/url"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFavorites_PostsAndComments(t *testing.T) {
+	defer cleanupTestData(t)
+	author := createTestUser(t, "Author", "author@test.com")
+	fan := createTestUser(t, "Fan", "fan@test.com")
+	postID, err := testApp.postRepo.CreatePost("A favorite post", "https://example.com", author.ID)
+	require.NoError(t, err)
+	commentID, err := testApp.postRepo.AddComment(author.ID, postID, "A favorite comment")
+	require.NoError(t, err)
+
+	for i := 0; i < 2; i++ {
+		w := serveAs(testApp.favorite, fan, http.MethodPost, "/favorite", url.Values{
+			"post_id":    {strconv.Itoa(postID)},
+			"redirectTo": {"/?page=1"},
+		})
+		require.Equal(t, http.StatusSeeOther, w.Code)
+		assert.Equal(t, "/?page=1", w.Header().Get("Location"))
+	}
+	w := serveAs(testApp.favorite, fan, http.MethodPost, "/favorite", url.Values{"comment_id": {strconv.Itoa(commentID)}})
+	require.Equal(t, http.StatusSeeOther, w.Code)
+	assert.Equal(t, "/favorites?id="+strconv.Itoa(fan.ID), w.Header().Get("Location"))
+
+	posts, _, err := testApp.postRepo.GetAll(Filter{Page: 1, PageSize: 10, ViewerID: fan.ID})
+	require.NoError(t, err)
+	require.Len(t, posts, 1)
+	assert.Equal(t, 1, posts[0].FavoriteCount)
+	assert.True(t, posts[0].Favorited)
+	posts, _, err = testApp.postRepo.GetAll(Filter{Page: 1, PageSize: 10, ViewerID: author.ID})
+	require.NoError(t, err)
+	assert.False(t, posts[0].Favorited)
+
+	w = serveAs(testApp.favorites, author, http.MethodGet, "/favorites?id="+strconv.Itoa(fan.ID), nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "A favorite post")
+	assert.Contains(t, w.Body.String(), "1 favorite")
+
+	w = serveAs(testApp.favorites, nil, http.MethodGet, "/favorites?id="+strconv.Itoa(fan.ID)+"&comments=t", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "A favorite comment")
+
+	// A bad page size sends the reader back to the first page.
+	w = serveAs(testApp.favorites, nil, http.MethodGet, "/favorites?id="+strconv.Itoa(fan.ID)+"&page_size=500", nil)
+	assert.Equal(t, http.StatusSeeOther, w.Code)
+	assert.Equal(t, "/favorites?id="+strconv.Itoa(fan.ID), w.Header().Get("Location"))
+
+	w = serveAs(testApp.favorite, fan, http.MethodPost, "/favorite", url.Values{"post_id": {strconv.Itoa(postID)}, "un": {"t"}})
+	require.Equal(t, http.StatusSeeOther, w.Code)
+	favorites, meta, err := testApp.postRepo.GetFavoritePosts(fan.ID, Filter{Page: 1, PageSize: 10})
+	require.NoError(t, err)
+	assert.Empty(t, favorites)
+	assert.Equal(t, 0, meta.TotalRecords)
+
+	assert.ErrorIs(t, testApp.postRepo.SetPostFavorite(fan.ID, postID+1000, true), sql.ErrNoRows)
+}
+
+func TestFavorites_Private(t *testing.T) {
+	defer cleanupTestData(t)
+	owner := createTestUser(t, "Private Fan", "private@test.com")
+	other := createTestUser(t, "Other", "other@test.com")
+	postID, err := testApp.postRepo.CreatePost("A secret favorite", "https://example.com", other.ID)
+	require.NoError(t, err)
+	require.NoError(t, testApp.postRepo.SetPostFavorite(owner.ID, postID, true))
+
+	w := serveAs(testApp.settingsFavorites, owner, http.MethodPost, "/settings/favorites", url.Values{})
+	require.Equal(t, http.StatusSeeOther, w.Code)
+	owner, err = testApp.userRepo.GetUserByID(owner.ID)
+	require.NoError(t, err)
+	assert.False(t, owner.FavoritesPublic)
+
+	target := "/favorites?id=" + strconv.Itoa(owner.ID)
+	for _, viewer := range []*User{nil, other} {
+		w = serveAs(testApp.favorites, viewer, http.MethodGet, target, nil)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), "keeps their favorites private")
+		assert.NotContains(t, w.Body.String(), "A secret favorite")
+	}
+
+	w = serveAs(testApp.favorites, owner, http.MethodGet, target, nil)
+	assert.Contains(t, w.Body.String(), "A secret favorite")
+	assert.Contains(t, w.Body.String(), "un-favorite")
+}
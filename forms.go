@@ -101,3 +101,18 @@ func (f *Form) IsEmail(field string) *Form {
 
 	return f
 }
+
+// IsURL checks that the field holds an absolute http or https URL.
+func (f *Form) IsURL(field string) *Form {
+	value := f.Get(field)
+	if value == "" {
+		return f
+	}
+
+	u, err := url.Parse(value)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		f.Errors.Add(field, fmt.Sprintf("This field %s is not a valid URL", field))
+	}
+
+	return f
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// dayLayout is the format of the day parameter of /front.
+const dayLayout = "2006-01-02"
+
+// front shows the top posts created on one day, yesterday unless the day
+// parameter says otherwise.
+func (app *application) front(w http.ResponseWriter, r *http.Request) {
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	day := today.AddDate(0, 0, -1)
+	if v := r.URL.Query().Get("day"); v != "" {
+		var err error
+		day, err = time.Parse(dayLayout, v)
+		if err != nil {
+			app.session.Put(r, "flash", "Pick a day as YYYY-MM-DD")
+			http.Redirect(w, r, "/front", http.StatusSeeOther)
+			return
+		}
+	}
+	if day.After(today) {
+		http.Redirect(w, r, "/front?day="+today.Format(dayLayout), http.StatusSeeOther)
+		return
+	}
+
+	filter := Filter{
+		OrderBy:  orderPopular,
+		Page:     app.readIntWithDefault(r, "page", 1),
+		PageSize: app.readIntWithDefault(r, "page_size", 30),
+		Since:    day,
+		Until:    day.AddDate(0, 0, 1),
+	}
+	if u, ok := r.Context().Value(contextUserKey).(*User); ok {
+		filter.ViewerID = u.ID
+	}
+	posts, metadata, err := app.postRepo.GetAll(filter)
+	if errors.Is(err, ErrInvalidPageSize) {
+		app.session.Put(r, "flash", err.Error())
+		http.Redirect(w, r, "/front?day="+day.Format(dayLayout), http.StatusSeeOther)
+		return
+	} else if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	data := &templateData{
+		PageTitle: day.Format("Monday, January 2, 2006"),
+		Posts:     posts,
+		Metadata:  metadata,
+		Day:       day.Format(dayLayout),
+		Today:     today.Format(dayLayout),
+		PrevDay:   day.AddDate(0, 0, -1).Format(dayLayout),
+		NextLink: fmt.Sprintf("/front?day=%s&page=%d&page_size=%d",
+			day.Format(dayLayout), metadata.NextPage, filter.PageSize),
+		PrevLink: fmt.Sprintf("/front?day=%s&page=%d&page_size=%d",
+			day.Format(dayLayout), metadata.PrevPage, filter.PageSize),
+	}
+	if day.Before(today) {
+		data.NextDay = day.AddDate(0, 0, 1).Format(dayLayout)
+	}
+	app.render(w, r, "front.html", data)
+}
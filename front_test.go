@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// createPostAt submits a post and backdates it to createdAt.
+func createPostAt(t *testing.T, u *User, title, createdAt string) int {
+	id, err := testApp.postRepo.CreatePost(title, "https://example.com/"+title, u.ID)
+	require.NoError(t, err)
+	_, err = testDB.Exec("UPDATE posts SET created_at = ? WHERE id = ?", createdAt, id)
+	require.NoError(t, err)
+	return id
+}
+
+func TestFront_ListsTopPostsOfDay(t *testing.T) {
+	defer cleanupTestData(t)
+	u := createTestUser(t, "Poster", "poster@test.com")
+	voter := createTestUser(t, "Voter", "voter@test.com")
+	createPostAt(t, u, "Midnight post", "2024-03-10 00:00:00")
+	top := createPostAt(t, u, "Top post", "2024-03-10 18:30:00")
+	createPostAt(t, u, "Day before", "2024-03-09 23:59:59")
+	createPostAt(t, u, "Day after", "2024-03-11 00:00:00")
+	require.NoError(t, testApp.postRepo.AddVote(voter.ID, top))
+
+	w := serveAs(testApp.front, nil, http.MethodGet, "/front?day=2024-03-10", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	body := w.Body.String()
+	assert.Contains(t, body, "Sunday, March 10, 2024")
+	assert.Contains(t, body, "Midnight post")
+	assert.NotContains(t, body, "Day before")
+	assert.NotContains(t, body, "Day after")
+	assert.Less(t, strings.Index(body, "Top post"), strings.Index(body, "Midnight post"))
+	assert.Contains(t, body, `href="/front?day=2024-03-09"`)
+	assert.Contains(t, body, `href="/front?day=2024-03-11"`)
+}
+
+func TestFront_InvalidAndFutureDays(t *testing.T) {
+	w := serveAs(testApp.front, nil, http.MethodGet, "/front?day=10/03/2024", nil)
+	assert.Equal(t, http.StatusSeeOther, w.Code)
+	assert.Equal(t, "/front", w.Header().Get("Location"))
+
+	today := time.Now().UTC().Format(dayLayout)
+	w = serveAs(testApp.front, nil, http.MethodGet, "/front?day=2999-01-01", nil)
+	assert.Equal(t, http.StatusSeeOther, w.Code)
+	assert.Equal(t, "/front?day="+today, w.Header().Get("Location"))
+
+	w = serveAs(testApp.front, nil, http.MethodGet, "/front?day="+today, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotContains(t, w.Body.String(), "next day")
+}
@@ -1,15 +1,26 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
+	"time"
 )
 
 const (
 	loggedInUserKey = "logged_in_user_id"
+	sessionEpochKey = "session_epoch"
 )
 
+// startSession logs u in for the rest of the browser session.
+func (app *application) startSession(r *http.Request, u *User) {
+	app.session.Put(r, loggedInUserKey, u.Email)
+	app.session.Put(r, sessionEpochKey, u.SessionEpoch)
+}
+
 func (app *application) readIntWithDefault(r *http.Request, key string, dvalue int) int {
 	v, err := strconv.Atoi(r.URL.Query().Get(key))
 	if err != nil {
@@ -19,15 +30,26 @@ func (app *application) readIntWithDefault(r *http.Request, key string, dvalue i
 }
 func (app *application) home(w http.ResponseWriter, r *http.Request) {
 
+	window := r.URL.Query().Get("t")
 	filter := Filter{
 		Query:    r.URL.Query().Get("q"),
 		OrderBy:  r.URL.Query().Get("order_by"),
 		Page:     app.readIntWithDefault(r, "page", 1),
 		PageSize: app.readIntWithDefault(r, "page_size", 10),
+		Since:    windowSince(window, time.Now()),
+	}
+	if u, ok := r.Context().Value(contextUserKey).(*User); ok {
+		filter.ViewerID = u.ID
 	}
 
 	posts, metadata, err := app.postRepo.GetAll(filter)
-	if err != nil {
+	if errors.Is(err, ErrInvalidSearch) {
+		form := NewForm(r.URL.Query())
+		form.Errors.Add("q", err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		app.render(w, r, "index.html", &templateData{Form: form, Filter: filter, Window: window})
+		return
+	} else if err != nil {
 		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 		return
 	}
@@ -37,10 +59,12 @@ func (app *application) home(w http.ResponseWriter, r *http.Request) {
 	app.render(w, r, "index.html", &templateData{
 		Posts:    posts,
 		Metadata: metadata,
-		NextLink: fmt.Sprintf("/?q=%s&order_by=%s&page=%d&page_size=%d",
-			filter.Query, filter.OrderBy, metadata.NextPage, filter.PageSize),
-		PrevLink: fmt.Sprintf("/?q=%s&order_by=%s&page=%d&page_size=%d",
-			filter.Query, filter.OrderBy, metadata.PrevPage, filter.PageSize),
+		Filter:   filter,
+		Window:   window,
+		NextLink: fmt.Sprintf("/?q=%s&order_by=%s&t=%s&page=%d&page_size=%d",
+			url.QueryEscape(filter.Query), url.QueryEscape(filter.OrderBy), url.QueryEscape(window), metadata.NextPage, filter.PageSize),
+		PrevLink: fmt.Sprintf("/?q=%s&order_by=%s&t=%s&page=%d&page_size=%d",
+			url.QueryEscape(filter.Query), url.QueryEscape(filter.OrderBy), url.QueryEscape(window), metadata.PrevPage, filter.PageSize),
 	})
 }
 
@@ -76,24 +100,35 @@ func (app *application) login(w http.ResponseWriter, r *http.Request) {
 
 		email := r.FormValue("email")
 		password := r.FormValue("password")
-		_, err := app.userRepo.Authenticate(email, password)
+		userID, err := app.userRepo.Authenticate(email, password)
 		if err != nil {
+			app.recordLogin(w, r, email, nil, false)
 			form.Errors.Add("generic", err.Error())
 			app.render(w, r, "login.html", &templateData{
 				Form: form,
 			})
 			return
 		}
+		u, err := app.userRepo.GetUserByID(userID)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
 		// logged in
-		app.session.Put(r, loggedInUserKey, email)
+		app.recordLogin(w, r, email, u, true)
+		app.startSession(r, u)
 		app.session.Put(r, "flash", "You are logged In")
+		if u.DeletionScheduledAt != nil {
+			app.session.Put(r, "flash", fmt.Sprintf("Your account is scheduled for deletion on %s. You can cancel this in settings.",
+				u.DeletionScheduledAt.Format("January 2, 2006")))
+		}
 		app.infoLog.Printf("Logged in with email %s", email)
-		http.Redirect(w, r, "/submit", http.StatusSeeOther)
+		http.Redirect(w, r, safeRedirect(r.PostForm.Get("redirectTo"), "/submit"), http.StatusSeeOther)
 		return
 	}
 
 	app.render(w, r, "login.html", &templateData{
-		Form: NewForm(r.PostForm),
+		Form: NewForm(r.URL.Query()),
 	})
 }
 
@@ -103,26 +138,68 @@ func (app *application) register(w http.ResponseWriter, r *http.Request) {
 		http.Redirect(w, r, "/", http.StatusSeeOther)
 		return
 	}
-	if r.Method == http.MethodPost {
-		if err := r.ParseForm(); err != nil {
-			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	// An invitation lets people in even when registration is closed.
+	var invitation *Invitation
+	inviteToken := r.Form.Get("invite")
+	if inviteToken != "" {
+		var err error
+		invitation, err = app.invitationRepo.GetInvitation(inviteToken)
+		if errors.Is(err, ErrInvalidToken) {
+			app.session.Put(r, "flash", "This invitation is invalid or has already been used")
+			http.Redirect(w, r, "/register", http.StatusSeeOther)
+			return
+		} else if err != nil {
+			app.serverError(w, err)
 			return
 		}
-
+	}
+	if invitation == nil && app.config.registration.Closed() {
+		app.render(w, r, "register.html", &templateData{
+			Form:               NewForm(nil),
+			RegistrationClosed: true,
+		})
+		return
+	}
+	if r.Method == http.MethodPost {
 		form := NewForm(r.PostForm)
 		form.Required("email", "password", "name").
 			MaxLength("email", 255).
 			MaxLength("password", 255).
-			MinLength("password", 3).
 			MinLength("name", 3).
 			MinLength("email", 3).
 			IsEmail("email")
+		if form.Get("email") != "" && !app.config.registration.DomainAllowed(form.Get("email")) {
+			form.Errors.Add("email", fmt.Sprintf("Use an email address at %s",
+				strings.Join(app.config.registration.Domains, ", ")))
+		}
+		// An invitation is for the address it was sent to, not for whoever
+		// gets hold of the link.
+		if invitation != nil && form.Get("email") != "" && !strings.EqualFold(strings.TrimSpace(form.Get("email")), invitation.Email) {
+			form.Errors.Add("email", "Use the email address this invitation was sent to")
+		}
+
+		if form.Get("password") != "" {
+			problems, err := app.passwords.Check(form.Get("password"), form.Get("name"), form.Get("email"))
+			if err != nil {
+				app.serverError(w, err)
+				return
+			}
+			for _, problem := range problems {
+				form.Errors.Add("password", problem)
+			}
+		}
 
 		if !form.Valid() {
 			app.errorLog.Printf("Invalid form: %+v", form.Errors)
 			form.Errors.Add("generic", "The data you submitted was not valid")
 			app.render(w, r, "register.html", &templateData{
-				Form: form,
+				Form:       form,
+				Invitation: invitation,
 			})
 			return
 		}
@@ -131,21 +208,48 @@ func (app *application) register(w http.ResponseWriter, r *http.Request) {
 		password := r.FormValue("password")
 		name := r.FormValue("name")
 		avatar := r.FormValue("avatar")
-		_, err := app.userRepo.CreateUser(name, email, password, avatar)
-		if err != nil {
+		var err error
+		if invitation != nil {
+			_, err = app.userRepo.CreateInvitedUser(name, email, password, avatar, inviteToken)
+		} else {
+			_, err = app.userRepo.CreateUser(name, email, password, avatar)
+		}
+		if errors.Is(err, ErrInvalidToken) {
+			// Someone else used the invitation in the meantime.
+			app.session.Put(r, "flash", "This invitation is invalid or has already been used")
+			http.Redirect(w, r, "/register", http.StatusSeeOther)
+			return
+		} else if err != nil {
 			form.Errors.Add("generic", err.Error())
 			app.render(w, r, "register.html", &templateData{
-				Form: form,
+				Form:       form,
+				Invitation: invitation,
 			})
 			return
 		}
+		if invitation != nil {
+			app.session.Put(r, "flash", "You are registered")
+			http.Redirect(w, r, "/login", http.StatusSeeOther)
+			return
+		}
+		if app.config.registration.InitialStatus() == userStatusPending {
+			app.session.Put(r, "flash", "Thanks for registering. We will email you once an administrator has approved your account.")
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+			return
+		}
 		app.session.Put(r, "flash", "You are registered")
 		http.Redirect(w, r, "/login", http.StatusSeeOther)
 		return
 	}
 
+	form := NewForm(r.PostForm)
+	if invitation != nil {
+		form.Set("invite", inviteToken)
+		form.Set("email", invitation.Email)
+	}
 	app.render(w, r, "register.html", &templateData{
-		Form: NewForm(r.PostForm),
+		Form:       form,
+		Invitation: invitation,
 	})
 }
 
@@ -159,6 +263,10 @@ func (app *application) contact(w http.ResponseWriter, r *http.Request) {
 	app.render(w, r, "contact.html", nil)
 }
 
+func (app *application) admin(w http.ResponseWriter, r *http.Request) {
+	app.render(w, r, "admin.html", nil)
+}
+
 func (app *application) vote(w http.ResponseWriter, r *http.Request) {
 	app.infoLog.Printf("Received request for %s", r.URL.Path)
 	postID := app.readIntWithDefault(r, "post_id", 0)
@@ -258,11 +366,36 @@ func (app *application) submit(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		title := r.FormValue("title")
-		url := r.FormValue("url")
+		url, err := canonicalURL(r.FormValue("url"))
+		if err != nil {
+			form.Errors.Add("url", err.Error())
+			app.render(w, r, "submit.html", &templateData{
+				Form: form,
+			})
+			return
+		}
 
 		user := app.getUserFromContext(r.Context())
+		if app.config.duplicateWindow > 0 {
+			existing, err := app.postRepo.FindRecentPostByURL(urlVariants(url), time.Now().Add(-app.config.duplicateWindow))
+			if err != nil {
+				app.serverError(w, err)
+				return
+			}
+			if existing != 0 {
+				app.redirectToDuplicate(w, r, user, existing)
+				return
+			}
+		}
+
 		id, err := app.postRepo.CreatePost(title, url, user.ID)
-		if err != nil {
+		if errors.Is(err, ErrSiteBanned) {
+			form.Errors.Add("url", err.Error())
+			app.render(w, r, "submit.html", &templateData{
+				Form: form,
+			})
+			return
+		} else if err != nil {
 			app.errorLog.Printf("error creating post: %s\n", err.Error())
 			form.Errors.Add("generic", "creation of post failed")
 			app.render(w, r, "submit.html", &templateData{
@@ -271,6 +404,7 @@ func (app *application) submit(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
+		app.snapshotPost(id, url)
 		app.session.Put(r, "flash", "post created")
 		app.infoLog.Printf("post created with %d", id)
 		http.Redirect(w, r, "/", http.StatusSeeOther)
@@ -281,6 +415,28 @@ func (app *application) submit(w http.ResponseWriter, r *http.Request) {
 		Form: NewForm(r.PostForm),
 	})
 }
+
+// redirectToDuplicate sends a user who submitted a link that was posted
+// recently to the existing discussion, upvoting it on their behalf unless
+// it is their own post.
+func (app *application) redirectToDuplicate(w http.ResponseWriter, r *http.Request, user *User, postID int) {
+	post, err := app.postRepo.GetByID(postID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	flash := "You submitted that link recently, so here is its discussion."
+	if post.UserID != user.ID {
+		if err := app.postRepo.AddVote(user.ID, postID); err != nil && !errors.Is(err, ErrDuplicateVote) {
+			app.serverError(w, err)
+			return
+		}
+		flash = "That link was submitted recently, so here is its discussion. We upvoted it for you."
+	}
+	app.session.Put(r, "flash", flash)
+	http.Redirect(w, r, fmt.Sprintf("/comments?post_id=%d", postID), http.StatusSeeOther)
+}
+
 func (app *application) logout(w http.ResponseWriter, r *http.Request) {
 	app.session.Remove(r, loggedInUserKey)
 	app.session.Put(r, "flash", "You are logged out")
@@ -116,3 +116,35 @@ func TestLogin_POST_InvalidAuthenticationData(t *testing.T) {
 	assert.Contains(t, body, "sql: no rows in result set")
 
 }
+
+func TestRegister_POST_WeakPassword(t *testing.T) {
+	defer cleanupTestData(t)
+
+	handler := testApp.session.Enable(testApp.authenticate(http.HandlerFunc(testApp.register)))
+	formData := "name=Jane+Doe&email=jane@doe.com&password=jane12345"
+	req := httptest.NewRequest(http.MethodPost, "/register", strings.NewReader(formData))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	body := w.Body.String()
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, body, "Use at least 10 characters")
+	assert.Contains(t, body, "Do not include your name or email address in your password")
+}
+
+func TestRegister_POST_StrongPassword(t *testing.T) {
+	defer cleanupTestData(t)
+
+	handler := testApp.session.Enable(testApp.authenticate(http.HandlerFunc(testApp.register)))
+	formData := "name=Jane+Doe&email=jane@doe.com&password=correct+horse+battery+staple"
+	req := httptest.NewRequest(http.MethodPost, "/register", strings.NewReader(formData))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusSeeOther, w.Code)
+	assert.Equal(t, "/login", w.Header().Get("Location"))
+}
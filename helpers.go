@@ -2,9 +2,18 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"net"
 	"net/http"
+	"net/url"
 	"runtime/debug"
+	"strings"
+	"unicode"
 )
 
 func (app *application) serverError(w http.ResponseWriter, err error) {
@@ -21,3 +30,75 @@ func (app *application) getUserFromContext(ctx context.Context) *User {
 	}
 	return u
 }
+
+// background runs fn in its own goroutine, logging instead of crashing the
+// server if it panics. app.wg can be used to wait for it to finish.
+func (app *application) background(fn func()) {
+	app.wg.Add(1)
+	go func() {
+		defer app.wg.Done()
+		defer func() {
+			if err := recover(); err != nil {
+				app.errorLog.Printf("%v\n%s", err, debug.Stack())
+			}
+		}()
+		fn()
+	}()
+}
+
+// remoteIP returns the address of the direct peer of r, or nil if it cannot
+// be parsed.
+func remoteIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return net.ParseIP(host)
+}
+
+// randomToken returns n bytes from crypto/rand encoded as unpadded base64url,
+// suitable for state parameters, one-time codes and throwaway passwords.
+func randomToken(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// hashToken returns the hex SHA-256 of a high-entropy secret token so that
+// tokens can be looked up without being stored in the clear.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func (app *application) writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		app.errorLog.Printf("error encoding json: %s", err)
+	}
+}
+
+// safeRedirect returns target when it is a path on this site and fallback
+// otherwise, so that redirectTo parameters cannot send users elsewhere.
+// Browsers drop tabs and newlines from URLs and read a backslash as a slash,
+// so "/\t/evil.com" and "/\\evil.com" both lead off the site.
+func safeRedirect(target, fallback string) string {
+	if strings.IndexFunc(target, func(r rune) bool { return unicode.IsSpace(r) || unicode.IsControl(r) }) >= 0 {
+		return fallback
+	}
+	u, err := url.Parse(target)
+	if err != nil || u.Scheme != "" || u.Host != "" || u.Opaque != "" ||
+		!strings.HasPrefix(u.Path, "/") || strings.HasPrefix(u.Path, "//") || strings.HasPrefix(u.Path, "/\\") {
+		return fallback
+	}
+	return target
+}
+
+// absoluteURL turns a site path into a link that works outside the browser,
+// such as in an email.
+func (app *application) absoluteURL(path string) string {
+	return strings.TrimSuffix(app.config.baseURL, "/") + path
+}
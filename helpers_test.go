@@ -0,0 +1,19 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSafeRedirect(t *testing.T) {
+	for _, target := range []string{"/", "/submit", "/?page=2&q=go", "/from?site=example.org"} {
+		assert.Equal(t, target, safeRedirect(target, "/fallback"), target)
+	}
+	for _, target := range []string{
+		"", "submit", "https://evil.com", "//evil.com", "/\\evil.com",
+		"/\t/evil.com", "/\n/evil.com", "/ /evil.com", "/%2F/evil.com", "javascript:alert(1)",
+	} {
+		assert.Equal(t, "/fallback", safeRedirect(target, "/fallback"), target)
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// undoHideKey holds the post just hidden so that the next page can offer to
+// undo it.
+const undoHideKey = "undo_hide"
+
+// hide hides the posted post_id from the user's front page, or shows it
+// again when un is set, and returns to the page the form was on.
+func (app *application) hide(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	postID, err := strconv.Atoi(r.PostForm.Get("post_id"))
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	u := app.getUserFromContext(r.Context())
+	hidden := r.PostForm.Get("un") == ""
+
+	err = app.postRepo.SetPostHidden(u.ID, postID, hidden)
+	if errors.Is(err, sql.ErrNoRows) {
+		app.session.Put(r, "flash", "post not found")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	} else if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	if hidden {
+		app.session.Put(r, "flash", "The post was hidden from your front page.")
+		app.session.Put(r, undoHideKey, postID)
+	} else {
+		app.session.Put(r, "flash", "The post is back on your front page.")
+	}
+	http.Redirect(w, r, safeRedirect(r.PostForm.Get("redirectTo"), "/"), http.StatusSeeOther)
+}
+
+// hidden lists the posts the user hid so that they can unhide them.
+func (app *application) hidden(w http.ResponseWriter, r *http.Request) {
+	u := app.getUserFromContext(r.Context())
+	filter := Filter{
+		Page:     app.readIntWithDefault(r, "page", 1),
+		PageSize: app.readIntWithDefault(r, "page_size", 10),
+		ViewerID: u.ID,
+	}
+	posts, metadata, err := app.postRepo.GetHiddenPosts(u.ID, filter)
+	if errors.Is(err, ErrInvalidPageSize) {
+		app.session.Put(r, "flash", err.Error())
+		http.Redirect(w, r, "/hidden", http.StatusSeeOther)
+		return
+	} else if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.render(w, r, "hidden.html", &templateData{
+		Posts:    posts,
+		Metadata: metadata,
+		Hidden:   true,
+		NextLink: fmt.Sprintf("/hidden?page=%d&page_size=%d", metadata.NextPage, filter.PageSize),
+		PrevLink: fmt.Sprintf("/hidden?page=%d&page_size=%d", metadata.PrevPage, filter.PageSize),
+	})
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHide_ExcludedFromFrontPageForViewer(t *testing.T) {
+	defer cleanupTestData(t)
+	reader := createTestUser(t, "Reader", "reader@test.com")
+	other := createTestUser(t, "Other Reader", "other-reader@test.com")
+	var ids []int
+	for _, title := range []string{"First story", "Second story", "Third story"} {
+		id, err := testApp.postRepo.CreatePost(title, "https://example.com/"+title, other.ID)
+		require.NoError(t, err)
+		ids = append(ids, id)
+	}
+
+	w := serveAs(testApp.hide, reader, http.MethodPost, "/hide", url.Values{
+		"post_id":    {strconv.Itoa(ids[1])},
+		"redirectTo": {"/?page=1"},
+	})
+	require.Equal(t, http.StatusSeeOther, w.Code)
+	assert.Equal(t, "/?page=1", w.Header().Get("Location"))
+
+	posts, meta, err := testApp.postRepo.GetAll(Filter{Page: 1, PageSize: 2, ViewerID: reader.ID})
+	require.NoError(t, err)
+	require.Len(t, posts, 2)
+	for _, p := range posts {
+		assert.NotEqual(t, ids[1], p.ID)
+	}
+	assert.Equal(t, 2, meta.TotalRecords)
+	assert.Equal(t, 1, meta.LastPage)
+
+	posts, meta, err = testApp.postRepo.GetAll(Filter{Page: 1, PageSize: 10, ViewerID: other.ID})
+	require.NoError(t, err)
+	assert.Len(t, posts, 3)
+	assert.Equal(t, 3, meta.TotalRecords)
+
+	w = serveAs(testApp.hidden, reader, http.MethodGet, "/hidden", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "Second story")
+	assert.Contains(t, w.Body.String(), "unhide")
+	assert.NotContains(t, w.Body.String(), "First story")
+
+	w = serveAs(testApp.hide, reader, http.MethodPost, "/hide", url.Values{"post_id": {strconv.Itoa(ids[1])}, "un": {"t"}})
+	require.Equal(t, http.StatusSeeOther, w.Code)
+	posts, _, err = testApp.postRepo.GetAll(Filter{Page: 1, PageSize: 10, ViewerID: reader.ID})
+	require.NoError(t, err)
+	assert.Len(t, posts, 3)
+}
+
+func TestHide_UndoOfferedInFlash(t *testing.T) {
+	defer cleanupTestData(t)
+	reader := createTestUser(t, "Reader", "reader@test.com")
+	postID, err := testApp.postRepo.CreatePost("Boring story", "https://example.com", reader.ID)
+	require.NoError(t, err)
+
+	w := serveAs(testApp.hide, reader, http.MethodPost, "/hide", url.Values{"post_id": {strconv.Itoa(postID)}})
+	require.Equal(t, http.StatusSeeOther, w.Code)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	for _, cookie := range w.Result().Cookies() {
+		req.AddCookie(cookie)
+	}
+	home := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		testApp.home(w, r.WithContext(contextWithUser(r.Context(), reader)))
+	})
+	w = httptest.NewRecorder()
+	testApp.session.Enable(home).ServeHTTP(w, req)
+	body := w.Body.String()
+	assert.Contains(t, body, "The post was hidden from your front page.")
+	assert.Contains(t, body, `name="post_id" value="`+strconv.Itoa(postID)+`"`)
+	assert.Contains(t, body, "Undo")
+	assert.NotContains(t, body, "Boring story")
+}
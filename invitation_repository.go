@@ -0,0 +1,122 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Invitation is an invite link a member sent to someone they know. Only the
+// SHA-256 of the link's token is stored.
+type Invitation struct {
+	ID          int        `json:"id"`
+	InviterID   int        `json:"inviter_id"`
+	InviterName string     `json:"-"`
+	Email       string     `json:"email"`
+	Note        string     `json:"note"`
+	InviteeID   int        `json:"invitee_id,omitempty"`
+	InviteeName string     `json:"invitee_name,omitempty"`
+	UsedAt      *time.Time `json:"used_at,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+type InvitationRepository interface {
+	CreateInvitation(inviterID int, email, note string) (string, error)
+	GetInvitation(token string) (*Invitation, error)
+	GetInvitationsByInviter(inviterID int) ([]Invitation, error)
+}
+
+type SQLInvitationRepository struct {
+	db *sql.DB
+}
+
+// NewSQLInvitationRepository creates a new instance of SQLInvitationRepository
+func NewSQLInvitationRepository(db *sql.DB) *SQLInvitationRepository {
+	return &SQLInvitationRepository{db: db}
+}
+
+const invitationSelect = `SELECT i.id, i.inviter_id, inviter.name, i.email, i.note,
+	COALESCE(i.invitee_id, 0), COALESCE(invitee.name, ''), i.used_at, i.created_at
+	FROM invitations i
+	INNER JOIN users inviter ON inviter.id = i.inviter_id
+	LEFT JOIN users invitee ON invitee.id = i.invitee_id`
+
+func scanInvitation(row rowScanner) (*Invitation, error) {
+	var inv Invitation
+	var usedAt sql.NullTime
+	err := row.Scan(&inv.ID, &inv.InviterID, &inv.InviterName, &inv.Email, &inv.Note,
+		&inv.InviteeID, &inv.InviteeName, &usedAt, &inv.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if usedAt.Valid {
+		inv.UsedAt = &usedAt.Time
+	}
+	return &inv, nil
+}
+
+// CreateInvitation stores an invitation and returns the token for its link.
+func (r *SQLInvitationRepository) CreateInvitation(inviterID int, email, note string) (string, error) {
+	token := randomToken(32)
+	stmt := "INSERT INTO invitations (token_hash, inviter_id, email, note) VALUES (?, ?, ?, ?)"
+	if _, err := r.db.Exec(stmt, hashToken(token), inviterID, email, note); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// GetInvitation returns the unused invitation for token, or ErrInvalidToken.
+func (r *SQLInvitationRepository) GetInvitation(token string) (*Invitation, error) {
+	inv, err := scanInvitation(r.db.QueryRow(invitationSelect+" WHERE i.token_hash = ? AND i.used_at IS NULL", hashToken(token)))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrInvalidToken
+	}
+	return inv, err
+}
+
+func (r *SQLInvitationRepository) GetInvitationsByInviter(inviterID int) ([]Invitation, error) {
+	rows, err := r.db.Query(invitationSelect+" WHERE i.inviter_id = ? ORDER BY i.created_at DESC", inviterID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var invitations []Invitation
+	for rows.Next() {
+		inv, err := scanInvitation(rows)
+		if err != nil {
+			return nil, err
+		}
+		invitations = append(invitations, *inv)
+	}
+	return invitations, rows.Err()
+}
+
+// redeemInvitation uses up the invitation for the account inviteeID,
+// records who invited it and activates it even if registration needs
+// approval: the inviter vouches for the invitee.
+func redeemInvitation(tx *sql.Tx, token string, inviteeID int) error {
+	var inviterID int
+	err := tx.QueryRow("SELECT inviter_id FROM invitations WHERE token_hash = ? AND used_at IS NULL", hashToken(token)).Scan(&inviterID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrInvalidToken
+	} else if err != nil {
+		return err
+	}
+
+	stmt := "UPDATE invitations SET used_at = ?, invitee_id = ? WHERE token_hash = ? AND used_at IS NULL"
+	res, err := tx.Exec(stmt, time.Now().UTC(), inviteeID, hashToken(token))
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrInvalidToken
+	}
+	stmt = "UPDATE users SET invited_by = ?, status = CASE WHEN status = ? THEN ? ELSE status END WHERE id = ?"
+	if _, err := tx.Exec(stmt, inviterID, userStatusPending, userStatusActive, inviteeID); err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// canInvite reports whether u has earned enough karma to invite others.
+func (app *application) canInvite(u *User) bool {
+	return u.IsAdmin || u.Karma >= app.config.inviteKarma
+}
+
+func (app *application) invitations(w http.ResponseWriter, r *http.Request) {
+	u := app.getUserFromContext(r.Context())
+	form := NewForm(nil)
+
+	if r.Method == http.MethodPost {
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+		form = NewForm(r.PostForm)
+		form.Required("email").
+			MaxLength("email", 255).
+			IsEmail("email").
+			MaxLength("note", 500)
+		if !app.canInvite(u) {
+			form.Errors.Add("generic", fmt.Sprintf("You need %d karma to invite people", app.config.inviteKarma))
+		}
+		if form.Get("email") != "" && !app.config.registration.DomainAllowed(form.Get("email")) {
+			form.Errors.Add("email", "This address is not in an allowed email domain")
+		}
+
+		if form.Valid() {
+			token, err := app.invitationRepo.CreateInvitation(u.ID, form.Get("email"), form.Get("note"))
+			if err != nil {
+				app.serverError(w, err)
+				return
+			}
+			link := app.absoluteURL("/register?invite=" + url.QueryEscape(token))
+			body := fmt.Sprintf("%s invited you to join hnews.\n\n", u.Name)
+			if note := form.Get("note"); note != "" {
+				body += note + "\n\n"
+			}
+			body += fmt.Sprintf("Create your account here:\n\n%s\n", link)
+			if err := app.mailer.Send(form.Get("email"), fmt.Sprintf("%s invited you to hnews", u.Name), body); err != nil {
+				app.serverError(w, err)
+				return
+			}
+			app.session.Put(r, "flash", fmt.Sprintf("Invitation sent to %s", form.Get("email")))
+			http.Redirect(w, r, "/invitations", http.StatusSeeOther)
+			return
+		}
+	}
+
+	sent, err := app.invitationRepo.GetInvitationsByInviter(u.ID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	app.render(w, r, "invitations.html", &templateData{
+		Form:        form,
+		User:        u,
+		Invitations: sent,
+		CanInvite:   app.canInvite(u),
+		InviteKarma: app.config.inviteKarma,
+	})
+}
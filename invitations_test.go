@@ -0,0 +1,171 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// invite sends an invitation as inviter and returns the token from the email.
+func invite(t *testing.T, inviter *User, email string) string {
+	w := serveAs(testApp.invitations, inviter, http.MethodPost, "/invitations", url.Values{
+		"email": {email},
+		"note":  {"Come and join us"},
+	})
+	require.Equal(t, http.StatusSeeOther, w.Code)
+	mail, ok := testApp.mailer.(*testMailer).last(email)
+	require.True(t, ok)
+	assert.Contains(t, mail.Body, "Come and join us")
+	token := regexp.MustCompile(`invite=(\S+)`).FindStringSubmatch(mail.Body)
+	require.Len(t, token, 2)
+	return token[1]
+}
+
+func TestInvitations_KarmaThreshold(t *testing.T) {
+	defer cleanupTestData(t)
+	newcomer := createTestUser(t, "Newcomer", "newcomer@test.com")
+
+	w := serveAs(testApp.invitations, newcomer, http.MethodPost, "/invitations", url.Values{"email": {"friend@test.com"}})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "You can invite people once you have 10 karma")
+	_, ok := testApp.mailer.(*testMailer).last("friend@test.com")
+	assert.False(t, ok)
+
+	newcomer.Karma = 10
+	invite(t, newcomer, "friend@test.com")
+}
+
+func TestRegister_WithInvitationWhenClosed(t *testing.T) {
+	defer cleanupTestData(t)
+	setRegistrationPolicy(t, RegistrationPolicy{Mode: registrationClosed})
+	inviter := createTestUser(t, "Inviter", "inviter@test.com")
+	inviter.IsAdmin = true
+	token := invite(t, inviter, "invitee@test.com")
+
+	form := registrationForm("invitee@test.com")
+	form.Set("invite", token)
+	w := postRegister(form)
+	assert.Equal(t, http.StatusSeeOther, w.Code)
+	assert.Equal(t, "/login", w.Header().Get("Location"))
+
+	invitee, err := testApp.userRepo.GetUserByEmail("invitee@test.com")
+	require.NoError(t, err)
+	assert.Equal(t, inviter.ID, invitee.InvitedBy)
+	assert.Equal(t, userStatusActive, invitee.Status)
+
+	// Invitations can only be used once.
+	form = registrationForm("second@test.com")
+	form.Set("invite", token)
+	w = postRegister(form)
+	assert.Equal(t, http.StatusSeeOther, w.Code)
+	assert.Equal(t, "/register", w.Header().Get("Location"))
+	_, err = testApp.userRepo.GetUserByEmail("second@test.com")
+	assert.Error(t, err)
+
+	w = serveAs(testApp.userProfile, nil, http.MethodGet, "/user?id="+strconv.Itoa(invitee.ID), nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "Invited by")
+	assert.Contains(t, w.Body.String(), "Inviter")
+}
+
+func TestRegister_InvitationIsForItsAddress(t *testing.T) {
+	defer cleanupTestData(t)
+	setRegistrationPolicy(t, RegistrationPolicy{Mode: registrationClosed})
+	inviter := createTestUser(t, "Inviter", "inviter@test.com")
+	inviter.IsAdmin = true
+	token := invite(t, inviter, "invitee@test.com")
+
+	w := serveAs(testApp.register, nil, http.MethodGet, "/register?invite="+url.QueryEscape(token), nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `value="invitee@test.com"`)
+
+	form := registrationForm("someone-else@test.com")
+	form.Set("invite", token)
+	w = postRegister(form)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "Use the email address this invitation was sent to")
+	_, err := testApp.userRepo.GetUserByEmail("someone-else@test.com")
+	assert.Error(t, err)
+}
+
+func TestSQLUserRepository_CreateInvitedUser_UsedInvitation(t *testing.T) {
+	defer cleanupTestData(t)
+	inviter := createTestUser(t, "Inviter", "inviter@test.com")
+	token, err := testApp.invitationRepo.CreateInvitation(inviter.ID, "invitee@test.com", "")
+	require.NoError(t, err)
+
+	_, err = testApp.userRepo.CreateInvitedUser("Invitee", "invitee@test.com", "correct horse battery staple", "", token)
+	require.NoError(t, err)
+
+	// The losing side of a race for the same link gets no account.
+	_, err = testApp.userRepo.CreateInvitedUser("Racer", "racer@test.com", "correct horse battery staple", "", token)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+	_, err = testApp.userRepo.GetUserByEmail("racer@test.com")
+	assert.Error(t, err)
+}
+
+func TestRegister_InvitationSkipsApproval(t *testing.T) {
+	defer cleanupTestData(t)
+	setRegistrationPolicy(t, RegistrationPolicy{Mode: registrationApproval})
+	inviter := createTestUser(t, "Inviter", "inviter@test.com")
+	require.NoError(t, testApp.userRepo.SetStatus(inviter.ID, userStatusActive))
+	inviter.IsAdmin = true
+	token := invite(t, inviter, "invitee@test.com")
+
+	form := registrationForm("invitee@test.com")
+	form.Set("invite", token)
+	postRegister(form)
+
+	_, err := testApp.userRepo.Authenticate("invitee@test.com", "a long and unusual passphrase 7")
+	assert.NoError(t, err)
+}
+
+func TestBanBranch(t *testing.T) {
+	defer cleanupTestData(t)
+	root := createTestUser(t, "Root", "root@test.com")
+	root.IsAdmin = true
+	// register redeems the invitation and records who invited whom.
+	register := func(inviter *User, name, email string) *User {
+		token, err := testApp.invitationRepo.CreateInvitation(inviter.ID, email, "")
+		require.NoError(t, err)
+		form := registrationForm(email)
+		form.Set("name", name)
+		form.Set("invite", token)
+		require.Equal(t, http.StatusSeeOther, postRegister(form).Code)
+		u, err := testApp.userRepo.GetUserByEmail(email)
+		require.NoError(t, err)
+		return u
+	}
+	spammer := register(root, "Spammer", "spammer@test.com")
+	sock := register(spammer, "Sock Puppet", "sock@test.com")
+	bystander := register(root, "Bystander", "bystander@test.com")
+
+	tree, err := testApp.userRepo.GetInviteTree()
+	require.NoError(t, err)
+	require.Len(t, tree, 1)
+	assert.Equal(t, root.ID, tree[0].User.ID)
+	require.Len(t, tree[0].Children, 2)
+	assert.Equal(t, sock.ID, tree[0].Children[0].Children[0].User.ID)
+
+	w := serveAs(testApp.inviteTree, nil, http.MethodGet, "/users/tree", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "Sock Puppet")
+
+	w = serveAs(testApp.adminBanBranch, root, http.MethodPost, "/admin/users/ban-branch",
+		url.Values{"user_id": {strconv.Itoa(spammer.ID)}})
+	assert.Equal(t, http.StatusSeeOther, w.Code)
+
+	for _, u := range []*User{spammer, sock} {
+		_, err := testApp.userRepo.Authenticate(u.Email, "a long and unusual passphrase 7")
+		assert.ErrorIs(t, err, ErrAccountBanned, u.Name)
+	}
+	_, err = testApp.userRepo.Authenticate(bystander.Email, "a long and unusual passphrase 7")
+	assert.NoError(t, err)
+	_, err = testApp.userRepo.Authenticate(root.Email, "correct horse battery staple")
+	assert.NoError(t, err)
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// every runs fn each interval until ctx is cancelled. Errors are logged and
+// do not stop the job.
+func (app *application) every(ctx context.Context, name string, interval time.Duration, fn func(context.Context) error) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			if err := fn(ctx); err != nil {
+				app.errorLog.Printf("job %s: %v", name, err)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// startJobs starts the background maintenance jobs.
+func (app *application) startJobs(ctx context.Context) {
+	app.every(ctx, "purge deleted accounts", time.Hour, app.purgeDeletedAccounts)
+	app.every(ctx, "expire data exports", time.Hour, app.expireDataExports)
+	app.every(ctx, "match saved searches", savedSearchInterval, app.matchSavedSearches)
+	app.every(ctx, "email search alerts", time.Hour, app.emailSearchAlerts)
+	app.every(ctx, "rebuild search suggestions", 10*time.Minute, app.rebuildSuggestions)
+	if app.config.checkLinks {
+		app.every(ctx, "check links", 15*time.Minute, app.checkLinks)
+	}
+	if app.snapshots != nil && app.config.snapshotRetention > 0 {
+		app.every(ctx, "expire snapshots", time.Hour, app.expireSnapshots)
+	}
+}
+
+func (app *application) purgeDeletedAccounts(ctx context.Context) error {
+	n, err := app.userRepo.PurgeScheduledDeletions(time.Now())
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		app.infoLog.Printf("anonymized %d deleted accounts", n)
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"database/sql"
+	"time"
+)
+
+// LinkCheck is the outcome of the latest check of a post's link. Status is
+// the HTTP status, or 0 when no response arrived and Error says why.
+// Failures counts the consecutive failed checks; after linkDeadAfter of
+// them the link is Dead until a check succeeds again.
+type LinkCheck struct {
+	PostID      int
+	Title       string
+	URL         string
+	Status      int
+	Error       string
+	Failures    int
+	Dead        bool
+	CheckedAt   time.Time
+	NextCheckAt time.Time
+}
+
+type LinkCheckRepository interface {
+	GetLinksToCheck(now time.Time, limit int) ([]LinkCheck, error)
+	SaveLinkCheck(c LinkCheck) error
+	GetFailingLinks(limit int) ([]LinkCheck, error)
+	RecheckLink(postID int, at time.Time) error
+}
+
+type SQLLinkCheckRepository struct {
+	db *sql.DB
+}
+
+// NewSQLLinkCheckRepository creates a new instance of SQLLinkCheckRepository
+func NewSQLLinkCheckRepository(db *sql.DB) *SQLLinkCheckRepository {
+	return &SQLLinkCheckRepository{db: db}
+}
+
+const linkCheckSelect = `SELECT p.id, p.title, p.url, COALESCE(lc.status, 0), COALESCE(lc.error, ''),
+	COALESCE(lc.failures, 0), COALESCE(lc.dead, 0), lc.checked_at, lc.next_check_at
+	FROM posts p LEFT JOIN link_checks lc ON lc.post_id = p.id`
+
+func (r *SQLLinkCheckRepository) queryLinkChecks(query string, args ...interface{}) ([]LinkCheck, error) {
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var checks []LinkCheck
+	for rows.Next() {
+		var c LinkCheck
+		var checkedAt, nextCheckAt sql.NullTime
+		err := rows.Scan(&c.PostID, &c.Title, &c.URL, &c.Status, &c.Error, &c.Failures, &c.Dead, &checkedAt, &nextCheckAt)
+		if err != nil {
+			return nil, err
+		}
+		c.CheckedAt, c.NextCheckAt = checkedAt.Time, nextCheckAt.Time
+		checks = append(checks, c)
+	}
+	return checks, rows.Err()
+}
+
+// GetLinksToCheck returns up to limit links that are due at now: links that
+// were never checked come first, then the most overdue.
+func (r *SQLLinkCheckRepository) GetLinksToCheck(now time.Time, limit int) ([]LinkCheck, error) {
+	return r.queryLinkChecks(linkCheckSelect+` WHERE lc.post_id IS NULL OR lc.next_check_at <= ?
+		ORDER BY lc.next_check_at IS NOT NULL, lc.next_check_at, p.id DESC LIMIT ?`,
+		now.UTC().Format(sqliteTimeLayout), limit)
+}
+
+func (r *SQLLinkCheckRepository) SaveLinkCheck(c LinkCheck) error {
+	stmt := `INSERT INTO link_checks (post_id, status, error, failures, dead, checked_at, next_check_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (post_id) DO UPDATE SET status = excluded.status, error = excluded.error,
+		failures = excluded.failures, dead = excluded.dead, checked_at = excluded.checked_at,
+		next_check_at = excluded.next_check_at`
+	_, err := r.db.Exec(stmt, c.PostID, c.Status, c.Error, c.Failures, c.Dead,
+		c.CheckedAt.UTC().Format(sqliteTimeLayout), c.NextCheckAt.UTC().Format(sqliteTimeLayout))
+	return err
+}
+
+// GetFailingLinks returns up to limit links whose latest check failed, dead
+// links first.
+func (r *SQLLinkCheckRepository) GetFailingLinks(limit int) ([]LinkCheck, error) {
+	return r.queryLinkChecks(linkCheckSelect+` WHERE lc.failures > 0
+		ORDER BY lc.dead DESC, lc.failures DESC, lc.checked_at DESC LIMIT ?`, limit)
+}
+
+// RecheckLink makes the post's link due for a check at at.
+func (r *SQLLinkCheckRepository) RecheckLink(postID int, at time.Time) error {
+	_, err := r.db.Exec("UPDATE link_checks SET next_check_at = ? WHERE post_id = ?",
+		at.UTC().Format(sqliteTimeLayout), postID)
+	return err
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// linkCheckBatch is how many links one run of the checker looks at.
+	linkCheckBatch = 100
+	// linkCheckWorkers is how many sites are checked at once; the links of
+	// one site are checked one after another.
+	linkCheckWorkers = 4
+	// linkRecheckAfter is how long a working link goes unchecked.
+	linkRecheckAfter = 7 * 24 * time.Hour
+	// linkRetryAfter is the wait after the first failure; it doubles with
+	// every failure after that, up to linkRecheckAfter.
+	linkRetryAfter = 6 * time.Hour
+	// linkDeadAfter is how many failures in a row make a link dead. With
+	// the backoff above they span about two days.
+	linkDeadAfter = 4
+)
+
+// CheckLink requests rawURL and returns the response status. It tries HEAD
+// first and falls back to GET when HEAD fails, since many servers do not
+// answer HEAD properly. The body is not read.
+func (f *LinkFetcher) CheckLink(ctx context.Context, rawURL string) (int, error) {
+	status, err := f.checkLink(ctx, http.MethodHead, rawURL)
+	if err == nil && status < 400 {
+		return status, nil
+	}
+	if ctx.Err() != nil {
+		return 0, ctx.Err()
+	}
+	return f.checkLink(ctx, http.MethodGet, rawURL)
+}
+
+func (f *LinkFetcher) checkLink(ctx context.Context, method, rawURL string) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, f.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, method, rawURL, nil)
+	if err != nil {
+		return 0, ErrInvalidLink
+	}
+	req.Header.Set("User-Agent", f.UserAgent)
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	// drain a little so that small responses can reuse the connection
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4<<10))
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
+
+// linkBroken tells whether a check failed. Refusals such as 401, 403 and
+// 429 mean the server is up, so they do not count; paywalls use them.
+func linkBroken(status int, err error) bool {
+	return err != nil || status == http.StatusNotFound || status == http.StatusGone || status >= 500
+}
+
+// nextLinkCheck records the outcome of checking prev's link at now and
+// schedules the next check.
+func nextLinkCheck(prev LinkCheck, status int, err error, now time.Time) LinkCheck {
+	c := prev
+	c.Status, c.Error, c.CheckedAt = status, "", now
+	if err != nil {
+		c.Error = err.Error()
+		if len(c.Error) > 255 {
+			c.Error = c.Error[:255]
+		}
+	}
+	if !linkBroken(status, err) {
+		c.Failures, c.Dead = 0, false
+		c.NextCheckAt = now.Add(linkRecheckAfter)
+		return c
+	}
+
+	c.Failures++
+	c.Dead = c.Failures >= linkDeadAfter
+	wait := linkRecheckAfter
+	if c.Failures < 10 {
+		wait = linkRetryAfter << (c.Failures - 1)
+	}
+	if wait > linkRecheckAfter {
+		wait = linkRecheckAfter
+	}
+	c.NextCheckAt = now.Add(wait)
+	return c
+}
+
+// checkLinks checks the links that are due. Links are grouped by site so
+// that no site gets more than one request at a time.
+func (app *application) checkLinks(ctx context.Context) error {
+	due, err := app.linkRepo.GetLinksToCheck(time.Now(), linkCheckBatch)
+	if err != nil {
+		return err
+	}
+
+	var sites []string
+	bySite := map[string][]LinkCheck{}
+	for _, c := range due {
+		site := siteOf(c.URL)
+		if _, ok := bySite[site]; !ok {
+			sites = append(sites, site)
+		}
+		bySite[site] = append(bySite[site], c)
+	}
+
+	work := make(chan []LinkCheck)
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	var firstErr error
+	died := 0
+	for i := 0; i < linkCheckWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for checks := range work {
+				for _, prev := range checks {
+					status, checkErr := app.fetcher.CheckLink(ctx, prev.URL)
+					if ctx.Err() != nil {
+						return // shutting down; the link stays due
+					}
+					c := nextLinkCheck(prev, status, checkErr, time.Now())
+					err := app.linkRepo.SaveLinkCheck(c)
+					mutex.Lock()
+					if err != nil && firstErr == nil {
+						firstErr = err
+					}
+					if c.Dead && !prev.Dead {
+						died++
+					}
+					mutex.Unlock()
+				}
+			}
+		}()
+	}
+	for _, site := range sites {
+		select {
+		case work <- bySite[site]:
+		case <-ctx.Done():
+		}
+	}
+	close(work)
+	wg.Wait()
+
+	if died > 0 {
+		app.infoLog.Printf("checked %d links, %d newly dead", len(due), died)
+	}
+	return firstErr
+}
+
+// adminLinks reports the links that failed their latest check. On POST it
+// makes the posted post_id's link due for a check now.
+func (app *application) adminLinks(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		links, err := app.linkRepo.GetFailingLinks(500)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+		app.render(w, r, "admin-links.html", &templateData{LinkChecks: links})
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	postID, err := strconv.Atoi(r.PostForm.Get("post_id"))
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	if err := app.linkRepo.RecheckLink(postID, time.Now()); err != nil {
+		app.serverError(w, err)
+		return
+	}
+	app.session.Put(r, "flash", "The link will be checked again within a few minutes")
+	http.Redirect(w, r, safeRedirect(r.PostForm.Get("redirectTo"), "/admin/links"), http.StatusSeeOther)
+}
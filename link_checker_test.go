@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNextLinkCheck(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := LinkCheck{PostID: 1}
+	for i, wait := range []time.Duration{6 * time.Hour, 12 * time.Hour, 24 * time.Hour, 48 * time.Hour} {
+		c = nextLinkCheck(c, http.StatusNotFound, nil, now)
+		assert.Equal(t, i+1, c.Failures)
+		assert.Equal(t, now.Add(wait), c.NextCheckAt)
+		assert.Equal(t, i+1 >= linkDeadAfter, c.Dead)
+	}
+	for i := 0; i < 20; i++ {
+		c = nextLinkCheck(c, 0, errors.New("connection refused"), now)
+	}
+	assert.Equal(t, now.Add(linkRecheckAfter), c.NextCheckAt, "the wait is capped")
+	assert.Equal(t, "connection refused", c.Error)
+
+	c = nextLinkCheck(c, http.StatusForbidden, nil, now)
+	assert.Equal(t, LinkCheck{PostID: 1, Status: http.StatusForbidden, CheckedAt: now, NextCheckAt: now.Add(linkRecheckAfter)}, c,
+		"a refusal means the server is up and revives the link")
+}
+
+func TestCheckLink(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/no-head":
+			if r.Method == http.MethodHead {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+				return
+			}
+			w.Write([]byte("ok"))
+		case "/moved":
+			http.Redirect(w, r, "/gone", http.StatusMovedPermanently)
+		case "/gone":
+			w.WriteHeader(http.StatusGone)
+		}
+	}))
+	defer srv.Close()
+	f := NewLinkFetcher()
+	f.AllowPrivate = true
+
+	status, err := f.CheckLink(context.Background(), srv.URL+"/no-head")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, status, "GET is tried when HEAD is refused")
+
+	status, err = f.CheckLink(context.Background(), srv.URL+"/moved")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusGone, status, "redirects are followed")
+
+	srv.Close()
+	_, err = f.CheckLink(context.Background(), srv.URL+"/no-head")
+	assert.Error(t, err)
+}
+
+func TestCheckLinksJob(t *testing.T) {
+	defer cleanupTestData(t)
+	var gone atomic.Bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/gone" || gone.Load() {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+	u := createTestUser(t, "Poster", "poster@test.com")
+	admin := createTestUser(t, "Admin", "admin@test.com")
+	alive, err := testApp.postRepo.CreatePost("Alive", srv.URL+"/ok", u.ID)
+	require.NoError(t, err)
+	dead, err := testApp.postRepo.CreatePost("Dead", srv.URL+"/gone", u.ID)
+	require.NoError(t, err)
+
+	for i := 0; i < linkDeadAfter; i++ {
+		_, err = testDB.Exec("UPDATE link_checks SET next_check_at = '2000-01-01 00:00:00'")
+		require.NoError(t, err)
+		require.NoError(t, testApp.checkLinks(context.Background()))
+	}
+	due, err := testApp.linkRepo.GetLinksToCheck(time.Now(), 10)
+	require.NoError(t, err)
+	assert.Empty(t, due, "checked links are not due again yet")
+
+	posts, _, err := testApp.postRepo.GetAll(Filter{Page: 1, PageSize: 10})
+	require.NoError(t, err)
+	for _, p := range posts {
+		assert.Equal(t, p.ID == dead, p.DeadLink, p.Title)
+	}
+	w := serveAs(testApp.home, nil, http.MethodGet, "/", nil)
+	assert.Contains(t, w.Body.String(), "[dead link]")
+
+	failing, err := testApp.linkRepo.GetFailingLinks(10)
+	require.NoError(t, err)
+	require.Len(t, failing, 1)
+	assert.Equal(t, LinkCheck{PostID: dead, Title: "Dead", URL: srv.URL + "/gone", Status: http.StatusNotFound,
+		Failures: linkDeadAfter, Dead: true, CheckedAt: failing[0].CheckedAt, NextCheckAt: failing[0].NextCheckAt}, failing[0])
+	w = serveAs(testApp.adminLinks, admin, http.MethodGet, "/admin/links", nil)
+	assert.Contains(t, w.Body.String(), srv.URL+"/gone")
+
+	// an admin can have a link checked again right away
+	gone.Store(true)
+	w = serveAs(testApp.adminLinks, admin, http.MethodPost, "/admin/links", url.Values{"post_id": {strconv.Itoa(alive)}})
+	assert.Equal(t, http.StatusSeeOther, w.Code)
+	require.NoError(t, testApp.checkLinks(context.Background()))
+	failing, err = testApp.linkRepo.GetFailingLinks(10)
+	require.NoError(t, err)
+	require.Len(t, failing, 2)
+	assert.Equal(t, alive, failing[1].PostID)
+	assert.False(t, failing[1].Dead, "one failure does not make a link dead")
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"syscall"
+	"time"
+	"unicode/utf8"
+)
+
+var (
+	ErrBlockedAddress = errors.New("links to private network addresses cannot be fetched")
+	ErrTooManyHops    = errors.New("the link redirects too many times")
+)
+
+// LinkMetadata is what LinkFetcher learns about a page. URL is where the
+// link ended up after redirects.
+type LinkMetadata struct {
+	URL          string `json:"url"`
+	CanonicalURL string `json:"canonical_url,omitempty"`
+	ContentType  string `json:"content_type"`
+	Title        string `json:"title,omitempty"`
+	Description  string `json:"description,omitempty"`
+	SiteName     string `json:"site_name,omitempty"`
+	Image        string `json:"image,omitempty"`
+}
+
+// LinkFetcher retrieves pages to suggest titles for submitted links. It
+// follows at most MaxRedirects redirects, reads at most MaxBytes of a page
+// and gives up after Timeout. Unless AllowPrivate is set it refuses to
+// connect to loopback, private and link-local addresses, so that links
+// cannot be used to probe the server's own network.
+type LinkFetcher struct {
+	MaxBytes     int64
+	MaxRedirects int
+	Timeout      time.Duration
+	UserAgent    string
+	AllowPrivate bool
+	client       *http.Client
+}
+
+func NewLinkFetcher() *LinkFetcher {
+	f := &LinkFetcher{
+		MaxBytes:     512 << 10,
+		MaxRedirects: 5,
+		Timeout:      5 * time.Second,
+		UserAgent:    "hnews-link-preview/1.0",
+	}
+	dialer := &net.Dialer{Timeout: 5 * time.Second, Control: f.checkAddress}
+	transport := &http.Transport{
+		DialContext:           dialer.DialContext,
+		TLSHandshakeTimeout:   5 * time.Second,
+		ResponseHeaderTimeout: 5 * time.Second,
+		MaxIdleConns:          10,
+		IdleConnTimeout:       30 * time.Second,
+	}
+	f.client = &http.Client{Transport: transport, CheckRedirect: f.checkRedirect}
+	return f
+}
+
+// checkAddress runs after name resolution, so it also catches host names
+// that resolve to internal addresses.
+func (f *LinkFetcher) checkAddress(network, address string, _ syscall.RawConn) error {
+	if f.AllowPrivate {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsMulticast() {
+		return ErrBlockedAddress
+	}
+	return nil
+}
+
+func (f *LinkFetcher) checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) > f.MaxRedirects {
+		return ErrTooManyHops
+	}
+	if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+		return ErrInvalidLink
+	}
+	return nil
+}
+
+// Fetch retrieves rawURL and extracts its title, OpenGraph data and
+// canonical URL. Pages that are not HTML only report their content type.
+func (f *LinkFetcher) Fetch(ctx context.Context, rawURL string) (*LinkMetadata, error) {
+	p, err := f.fetchPage(ctx, rawURL)
+	if err != nil {
+		return nil, err
+	}
+	meta := &LinkMetadata{URL: p.url.String(), ContentType: p.contentType}
+	if p.isHTML() {
+		parseHTMLMetadata(meta, p.text(), p.url)
+	}
+	return meta, nil
+}
+
+// fetchedPage is the start of a page read by fetchPage: at most MaxBytes of
+// its body, from url, where redirects ended.
+type fetchedPage struct {
+	url         *url.URL
+	contentType string
+	body        []byte
+}
+
+func (p *fetchedPage) isHTML() bool {
+	return p.contentType == "text/html" || p.contentType == "application/xhtml+xml"
+}
+
+// text returns the body as UTF-8 text, dropping invalid bytes.
+func (p *fetchedPage) text() string {
+	return strings.ToValidUTF8(string(p.body), "")
+}
+
+// fetchPage retrieves rawURL. The content type comes from the response
+// header, or is sniffed from the body when the header does not say.
+func (f *LinkFetcher) fetchPage(ctx context.Context, rawURL string) (*fetchedPage, error) {
+	ctx, cancel := context.WithTimeout(ctx, f.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, ErrInvalidLink
+	}
+	req.Header.Set("User-Agent", f.UserAgent)
+	req.Header.Set("Accept", "text/html,application/xhtml+xml;q=0.9,*/*;q=0.5")
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return nil, fmt.Errorf("the page returned %s", resp.Status)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, f.MaxBytes))
+	if err != nil {
+		return nil, err
+	}
+
+	p := &fetchedPage{url: resp.Request.URL, body: body}
+	p.contentType, _, _ = mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if p.contentType == "" || p.contentType == "application/octet-stream" {
+		p.contentType, _, _ = mime.ParseMediaType(http.DetectContentType(body))
+	}
+	return p, nil
+}
+
+var (
+	htmlSkipRX  = regexp.MustCompile(`(?is)<!--.*?-->|<script\b.*?</script\s*>|<style\b.*?</style\s*>`)
+	htmlTitleRX = regexp.MustCompile(`(?is)<title\b[^>]*>(.*?)</title\s*>`)
+	htmlTagRX   = regexp.MustCompile(`(?is)<(meta|link)\b([^>]*)>`)
+	htmlAttrRX  = regexp.MustCompile(`(?is)([a-z][a-z0-9_:.-]*)\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s"'>]+))`)
+	spaceRX     = regexp.MustCompile(`\s+`)
+)
+
+// parseHTMLMetadata fills meta from the <title>, <meta> and <link> tags of
+// page, resolving links against base. OpenGraph and Twitter titles are
+// preferred over <title>, which often carries the site's name as well.
+func parseHTMLMetadata(meta *LinkMetadata, page string, base *url.URL) {
+	page = htmlSkipRX.ReplaceAllString(page, "")
+	var title, canonical string
+	if m := htmlTitleRX.FindStringSubmatch(page); m != nil {
+		title = cleanText(m[1])
+	}
+
+	props := map[string]string{}
+	for _, tag := range htmlTagRX.FindAllStringSubmatch(page, -1) {
+		attrs := map[string]string{}
+		for _, a := range htmlAttrRX.FindAllStringSubmatch(tag[2], -1) {
+			attrs[strings.ToLower(a[1])] = a[2] + a[3] + a[4]
+		}
+		if strings.EqualFold(tag[1], "link") {
+			for _, rel := range strings.Fields(strings.ToLower(attrs["rel"])) {
+				if rel == "canonical" && canonical == "" {
+					canonical = attrs["href"]
+				}
+			}
+			continue
+		}
+		name := strings.ToLower(attrs["property"])
+		if name == "" {
+			name = strings.ToLower(attrs["name"])
+		}
+		if _, seen := props[name]; name != "" && !seen {
+			props[name] = cleanText(attrs["content"])
+		}
+	}
+
+	meta.Title = firstNonEmpty(props["og:title"], props["twitter:title"], title)
+	meta.Description = firstNonEmpty(props["og:description"], props["twitter:description"], props["description"])
+	meta.SiteName = props["og:site_name"]
+	meta.Image = resolveLink(base, firstNonEmpty(props["og:image"], props["twitter:image"]))
+	if c, err := canonicalURL(resolveLink(base, firstNonEmpty(canonical, props["og:url"]))); err == nil {
+		meta.CanonicalURL = c
+	}
+	if utf8.RuneCountInString(meta.Title) > 255 {
+		meta.Title = string([]rune(meta.Title)[:255])
+	}
+}
+
+// cleanText unescapes HTML entities and collapses whitespace.
+func cleanText(s string) string {
+	return strings.TrimSpace(spaceRX.ReplaceAllString(html.UnescapeString(s), " "))
+}
+
+// resolveLink resolves ref against base, returning "" for empty or
+// malformed references and for anything but http and https links.
+func resolveLink(base *url.URL, ref string) string {
+	if ref == "" {
+		return ""
+	}
+	u, err := base.Parse(html.UnescapeString(strings.TrimSpace(ref)))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return ""
+	}
+	return u.String()
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// submitFetch looks up the page a link goes to for the submit form, which
+// uses it to suggest a title: /submit/fetch?url=.
+func (app *application) submitFetch(w http.ResponseWriter, r *http.Request) {
+	link, err := canonicalURL(r.URL.Query().Get("url"))
+	if err != nil {
+		app.writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	meta, err := app.fetcher.Fetch(r.Context(), link)
+	if err != nil {
+		app.infoLog.Printf("fetching %s: %v", link, err)
+		msg := "the page could not be loaded"
+		for _, known := range []error{ErrBlockedAddress, ErrTooManyHops, ErrInvalidLink} {
+			if errors.Is(err, known) {
+				msg = known.Error()
+			}
+		}
+		app.writeJSON(w, http.StatusBadGateway, map[string]string{"error": msg})
+		return
+	}
+	app.writeJSON(w, http.StatusOK, meta)
+}
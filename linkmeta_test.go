@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testPage = `<!DOCTYPE html>
+<html><head>
+<title>
+  A story &amp; more | Example
+</title>
+<!-- <meta property="og:title" content="Commented out"> -->
+<script>var s = '<meta property="og:title" content="In a script">';</script>
+<meta property="og:title" content="A story &amp; more">
+<meta name="description" content="Plain description">
+<meta property='og:description' content='OpenGraph description'>
+<meta property="og:site_name" content="Example">
+<meta property="og:image" content="/cover.png">
+<link rel="stylesheet" href="/style.css">
+<LINK REL="canonical" HREF="/story/?utm_source=feed">
+</head><body>Hello</body></html>`
+
+func TestLinkFetcher_Metadata(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/old":
+			http.Redirect(w, r, "/page", http.StatusMovedPermanently)
+		case "/page":
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Write([]byte(testPage))
+		case "/bare":
+			// no Content-Type: detected from the body
+			w.Header()["Content-Type"] = nil
+			w.Write([]byte("<html><head><title>Bare</title></head></html>"))
+		case "/pdf":
+			w.Header().Set("Content-Type", "application/pdf")
+			w.Write([]byte("%PDF-1.4 <title>Not parsed</title>"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	f := NewLinkFetcher()
+	f.AllowPrivate = true
+
+	meta, err := f.Fetch(context.Background(), srv.URL+"/old")
+	require.NoError(t, err)
+	assert.Equal(t, &LinkMetadata{
+		URL:          srv.URL + "/page",
+		CanonicalURL: srv.URL + "/story",
+		ContentType:  "text/html",
+		Title:        "A story & more",
+		Description:  "OpenGraph description",
+		SiteName:     "Example",
+		Image:        srv.URL + "/cover.png",
+	}, meta)
+
+	meta, err = f.Fetch(context.Background(), srv.URL+"/bare")
+	require.NoError(t, err)
+	assert.Equal(t, "text/html", meta.ContentType)
+	assert.Equal(t, "Bare", meta.Title)
+
+	meta, err = f.Fetch(context.Background(), srv.URL+"/pdf")
+	require.NoError(t, err)
+	assert.Equal(t, "application/pdf", meta.ContentType)
+	assert.Empty(t, meta.Title)
+
+	_, err = f.Fetch(context.Background(), srv.URL+"/missing")
+	assert.Error(t, err)
+}
+
+func TestLinkFetcher_Limits(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/loop":
+			http.Redirect(w, r, "/loop", http.StatusFound)
+		case "/big":
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte("<html><head>" + strings.Repeat(" ", 2048) + "<title>Too far</title>"))
+		case "/slow":
+			time.Sleep(200 * time.Millisecond)
+			w.Write([]byte("<title>Slow</title>"))
+		}
+	}))
+	defer srv.Close()
+	f := NewLinkFetcher()
+	f.AllowPrivate = true
+	f.MaxBytes = 1024
+	f.Timeout = 50 * time.Millisecond
+
+	_, err := f.Fetch(context.Background(), srv.URL+"/loop")
+	assert.ErrorIs(t, err, ErrTooManyHops)
+
+	meta, err := f.Fetch(context.Background(), srv.URL+"/big")
+	require.NoError(t, err)
+	assert.Empty(t, meta.Title, "only the first MaxBytes are read")
+
+	_, err = f.Fetch(context.Background(), srv.URL+"/slow")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	_, err = NewLinkFetcher().Fetch(context.Background(), srv.URL+"/big")
+	assert.ErrorIs(t, err, ErrBlockedAddress, "local addresses are refused by default")
+}
+
+func TestSubmitFetch(t *testing.T) {
+	defer cleanupTestData(t)
+	u := createTestUser(t, "Poster", "poster@test.com")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("<title>Fetched title</title>"))
+	}))
+	defer srv.Close()
+
+	w := serveAs(testApp.submitFetch, u, http.MethodGet, "/submit/fetch?url="+url.QueryEscape(srv.URL+"/a"), nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"title":"Fetched title"`)
+
+	w = serveAs(testApp.submitFetch, u, http.MethodGet, "/submit/fetch?url=ftp://example.com", nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "full http or https link")
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const (
+	// deviceCookie holds a random ID that recognises a browser across
+	// sessions, so that logins from new devices can be reported.
+	deviceCookie    = "hnews_device"
+	deviceCookieAge = 2 * 365 * 24 * time.Hour
+
+	// loginHistoryLength is how many recent logins the settings page shows.
+	loginHistoryLength = 10
+)
+
+// deviceID returns the browser's device ID, issuing a new one if it has none.
+func (app *application) deviceID(w http.ResponseWriter, r *http.Request) string {
+	if c, err := r.Cookie(deviceCookie); err == nil && len(c.Value) >= 16 {
+		return c.Value
+	}
+	id := randomToken(16)
+	http.SetCookie(w, &http.Cookie{
+		Name:     deviceCookie,
+		Value:    id,
+		Path:     "/",
+		MaxAge:   int(deviceCookieAge.Seconds()),
+		HttpOnly: true,
+		Secure:   app.session.Secure,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return id
+}
+
+// networkOf returns the network an address belongs to for the purpose of
+// recognising familiar logins: its /24 for IPv4 and /48 for IPv6.
+func networkOf(ip net.IP) string {
+	if ip == nil {
+		return ""
+	}
+	if v4 := ip.To4(); v4 != nil {
+		return (&net.IPNet{IP: v4.Mask(net.CIDRMask(24, 32)), Mask: net.CIDRMask(24, 32)}).String()
+	}
+	return (&net.IPNet{IP: ip.Mask(net.CIDRMask(48, 128)), Mask: net.CIDRMask(48, 128)}).String()
+}
+
+// recordLogin adds a login attempt to the history. u is nil for failed
+// attempts, which are attributed to the account of email if there is one.
+// Successful logins from a device and network the user has not logged in
+// from before trigger an alert email. Recording is best effort and never
+// fails the login.
+func (app *application) recordLogin(w http.ResponseWriter, r *http.Request, email string, u *User, success bool) {
+	if u == nil {
+		u, _ = app.userRepo.GetUserByEmail(email)
+	}
+	ip := remoteIP(r)
+	e := LoginEvent{
+		Email:     email,
+		Success:   success,
+		UserAgent: r.UserAgent(),
+		Device:    hashToken(app.deviceID(w, r)),
+		Network:   networkOf(ip),
+	}
+	if ip != nil {
+		e.IP = ip.String()
+	}
+	if u != nil {
+		e.UserID = u.ID
+	}
+
+	var familiarity LoginFamiliarity
+	if success {
+		var err error
+		familiarity, err = app.loginRepo.GetLoginFamiliarity(u.ID, e.Device, e.Network)
+		if err != nil {
+			app.errorLog.Printf("login history of user %d: %v", u.ID, err)
+			return
+		}
+	}
+	eventID, err := app.loginRepo.RecordLogin(e)
+	if err != nil {
+		app.errorLog.Printf("record login of %s: %v", email, err)
+		return
+	}
+	if !success || familiarity.FirstLogin || (familiarity.KnownDevice && familiarity.KnownNetwork) {
+		return
+	}
+
+	token, err := app.loginRepo.CreateLoginAlert(eventID)
+	if err != nil {
+		app.errorLog.Printf("login alert for user %d: %v", u.ID, err)
+		return
+	}
+	link := app.absoluteURL("/login/not-me?token=" + url.QueryEscape(token))
+	body := fmt.Sprintf("Hi %s,\n\nyour hnews account was just logged in to from a new device or network:\n\n"+
+		"Time: %s\nIP address: %s\nBrowser: %s\n\n"+
+		"If this was you, you can ignore this email. If it was not, open this link to log out every session "+
+		"and choose a new password:\n\n%s\n",
+		u.Name, time.Now().UTC().Format(time.RFC1123), e.IP, e.UserAgent, link)
+	if err := app.mailer.Send(u.Email, "New login to your hnews account", body); err != nil {
+		app.errorLog.Printf("login alert for user %d: %v", u.ID, err)
+	}
+}
+
+// loginNotMe handles the "this wasn't me" link of a login alert. GET shows
+// the login and asks for confirmation, since mail scanners open links too;
+// POST logs the account out everywhere, disables its password and lets the
+// owner choose a new one straight away.
+func (app *application) loginNotMe(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		token := r.URL.Query().Get("token")
+		e, err := app.loginRepo.GetLoginAlert(token)
+		if errors.Is(err, ErrInvalidToken) {
+			app.session.Put(r, "flash", "This link is invalid or has expired")
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+			return
+		} else if err != nil {
+			app.serverError(w, err)
+			return
+		}
+		form := NewForm(url.Values{"token": {token}})
+		app.render(w, r, "login-not-me.html", &templateData{Form: form, LoginEvent: e})
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	e, err := app.loginRepo.RedeemLoginAlert(r.PostForm.Get("token"))
+	if errors.Is(err, ErrInvalidToken) {
+		app.session.Put(r, "flash", "This link is invalid or has expired")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	} else if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	token, err := app.userRepo.RequirePasswordReset(e.UserID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	if err := app.oauthRepo.RevokeUserTokens(e.UserID); err != nil {
+		app.serverError(w, err)
+		return
+	}
+	app.infoLog.Printf("user %d reported login %d from %s as not theirs", e.UserID, e.ID, e.IP)
+	// The link is also mailed so that it is not lost if this page is closed
+	// before a new password is chosen.
+	if u, err := app.userRepo.GetUserByID(e.UserID); err != nil {
+		app.errorLog.Printf("password reset for user %d: %v", e.UserID, err)
+	} else if err := app.sendPasswordReset(u, token); err != nil {
+		app.errorLog.Printf("password reset for user %d: %v", e.UserID, err)
+	}
+
+	app.session.Remove(r, loggedInUserKey)
+	app.session.Remove(r, sessionEpochKey)
+	app.session.Put(r, "flash", "We logged out every session of your account. Choose a new password to continue.")
+	http.Redirect(w, r, "/password/reset?token="+url.QueryEscape(token), http.StatusSeeOther)
+}
+
+// sendPasswordReset emails u a link to choose a new password.
+func (app *application) sendPasswordReset(u *User, token string) error {
+	link := app.absoluteURL("/password/reset?token=" + url.QueryEscape(token))
+	body := fmt.Sprintf("Hi %s,\n\nopen this link within %d minutes to choose a new password for your hnews account:\n\n%s\n\n"+
+		"If you did not ask for this, you can ignore this email.\n",
+		u.Name, int(passwordResetTTL.Minutes()), link)
+	return app.mailer.Send(u.Email, "Choose a new hnews password", body)
+}
+
+// passwordForgot emails a password reset link to the address entered, if it
+// belongs to an account. The answer is the same either way, so that the form
+// cannot be used to find out who has an account.
+func (app *application) passwordForgot(w http.ResponseWriter, r *http.Request) {
+	form := NewForm(nil)
+	if r.Method == http.MethodPost {
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+		form = NewForm(r.PostForm)
+		form.Required("email").
+			MaxLength("email", 255).
+			IsEmail("email")
+		if form.Valid() {
+			u, err := app.userRepo.GetUserByEmail(form.Get("email"))
+			if err == nil && u.Status != userStatusDeleted && u.Status != userStatusBanned {
+				token, err := app.userRepo.CreatePasswordReset(u.ID)
+				if err != nil {
+					app.serverError(w, err)
+					return
+				}
+				if err := app.sendPasswordReset(u, token); err != nil {
+					app.serverError(w, err)
+					return
+				}
+			} else if err != nil && !errors.Is(err, sql.ErrNoRows) {
+				app.serverError(w, err)
+				return
+			}
+			app.session.Put(r, "flash", "If an account uses that address, we sent it a link to choose a new password")
+			http.Redirect(w, r, "/login", http.StatusSeeOther)
+			return
+		}
+	}
+	app.render(w, r, "password-forgot.html", &templateData{Form: form})
+}
+
+func (app *application) passwordReset(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	form := NewForm(r.Form)
+
+	if r.Method == http.MethodPost {
+		form = NewForm(r.PostForm)
+		form.Required("token", "new_password").
+			MaxLength("new_password", 255)
+		if form.Get("new_password") != "" {
+			problems, err := app.passwords.Check(form.Get("new_password"))
+			if err != nil {
+				app.serverError(w, err)
+				return
+			}
+			for _, problem := range problems {
+				form.Errors.Add("new_password", problem)
+			}
+		}
+
+		if form.Valid() {
+			u, err := app.userRepo.ResetPassword(form.Get("token"), form.Get("new_password"))
+			if err == nil {
+				err = app.oauthRepo.RevokeUserTokens(u.ID)
+			}
+			if errors.Is(err, ErrInvalidToken) {
+				form.Errors.Add("generic", "This link is invalid or has expired")
+			} else if err != nil {
+				app.serverError(w, err)
+				return
+			} else {
+				app.session.Put(r, "flash", "Your password was changed. You can log in now.")
+				http.Redirect(w, r, "/login", http.StatusSeeOther)
+				return
+			}
+		}
+	}
+
+	app.render(w, r, "password-reset.html", &templateData{Form: form})
+}
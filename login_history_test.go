@@ -0,0 +1,166 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// postLogin logs in from the given device ID and remote address.
+func postLogin(email, password, device, remoteAddr string) *httptest.ResponseRecorder {
+	form := url.Values{"email": {email}, "password": {password}}
+	req := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("User-Agent", "TestBrowser/1.0")
+	req.RemoteAddr = remoteAddr
+	req.AddCookie(&http.Cookie{Name: deviceCookie, Value: device})
+	w := httptest.NewRecorder()
+	testApp.session.Enable(http.HandlerFunc(testApp.login)).ServeHTTP(w, req)
+	return w
+}
+
+func TestNetworkOf(t *testing.T) {
+	assert.Equal(t, "203.0.113.0/24", networkOf(net.ParseIP("203.0.113.77")))
+	assert.Equal(t, "2001:db8:1::/48", networkOf(net.ParseIP("2001:db8:1:2::5")))
+	assert.Equal(t, "", networkOf(nil))
+}
+
+func TestLogin_AlertsOnNewDevice(t *testing.T) {
+	defer cleanupTestData(t)
+	u := createTestUser(t, "Alert User", "alert@test.com")
+	device := strings.Repeat("a", 22)
+
+	w := postLogin(u.Email, "correct horse battery staple", device, "203.0.113.7:1234")
+	require.Equal(t, http.StatusSeeOther, w.Code)
+	_, ok := testApp.mailer.(*testMailer).last(u.Email)
+	assert.False(t, ok, "the first login is not reported")
+
+	// Same device, another address in the same network.
+	w = postLogin(u.Email, "correct horse battery staple", device, "203.0.113.8:1234")
+	require.Equal(t, http.StatusSeeOther, w.Code)
+	_, ok = testApp.mailer.(*testMailer).last(u.Email)
+	assert.False(t, ok)
+
+	w = postLogin(u.Email, "correct horse battery staple", strings.Repeat("b", 22), "198.51.100.1:1234")
+	require.Equal(t, http.StatusSeeOther, w.Code)
+	mail, ok := testApp.mailer.(*testMailer).last(u.Email)
+	require.True(t, ok)
+	assert.Contains(t, mail.Body, "198.51.100.1")
+	assert.Contains(t, mail.Body, "TestBrowser/1.0")
+	assert.Contains(t, mail.Body, "/login/not-me?token=")
+
+	history, err := testApp.loginRepo.GetLoginHistory(u.ID, loginHistoryLength)
+	require.NoError(t, err)
+	assert.Len(t, history, 3)
+}
+
+func TestLogin_RecordsFailedAttempt(t *testing.T) {
+	defer cleanupTestData(t)
+	u := createTestUser(t, "Failing User", "failing@test.com")
+
+	w := postLogin(u.Email, "wrong password", strings.Repeat("a", 22), "203.0.113.7:1234")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	history, err := testApp.loginRepo.GetLoginHistory(u.ID, loginHistoryLength)
+	require.NoError(t, err)
+	require.Len(t, history, 1)
+	assert.False(t, history[0].Success)
+	assert.Equal(t, "203.0.113.7", history[0].IP)
+
+	w = serveAs(testApp.settings, u, http.MethodGet, "/settings", nil)
+	assert.Contains(t, w.Body.String(), "203.0.113.7")
+}
+
+func TestLoginNotMe(t *testing.T) {
+	defer cleanupTestData(t)
+	u := createTestUser(t, "Victim", "victim@test.com")
+	postLogin(u.Email, "correct horse battery staple", strings.Repeat("a", 22), "203.0.113.7:1234")
+	postLogin(u.Email, "correct horse battery staple", strings.Repeat("b", 22), "198.51.100.1:1234")
+	mail, ok := testApp.mailer.(*testMailer).last(u.Email)
+	require.True(t, ok)
+	token := regexp.MustCompile(`not-me\?token=(\S+)`).FindStringSubmatch(mail.Body)
+	require.Len(t, token, 2)
+
+	// Opening the link only asks for confirmation, so mail scanners that
+	// follow it change nothing.
+	w := serveAs(testApp.loginNotMe, nil, http.MethodGet, "/login/not-me?token="+token[1], nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "198.51.100.1")
+	_, err := testApp.userRepo.Authenticate(u.Email, "correct horse battery staple")
+	require.NoError(t, err)
+
+	w = serveAs(testApp.loginNotMe, nil, http.MethodPost, "/login/not-me", url.Values{"token": {token[1]}})
+	require.Equal(t, http.StatusSeeOther, w.Code)
+	location := w.Header().Get("Location")
+	assert.True(t, strings.HasPrefix(location, "/password/reset?token="))
+
+	_, err = testApp.userRepo.Authenticate(u.Email, "correct horse battery staple")
+	assert.ErrorIs(t, err, ErrPasswordReset)
+	after, err := testApp.userRepo.GetUserByID(u.ID)
+	require.NoError(t, err)
+	assert.NotEqual(t, u.SessionEpoch, after.SessionEpoch)
+
+	// The alert link works only once.
+	w = serveAs(testApp.loginNotMe, nil, http.MethodPost, "/login/not-me", url.Values{"token": {token[1]}})
+	assert.Equal(t, "/", w.Header().Get("Location"))
+
+	// The reset link is also emailed to the account.
+	mail, ok = testApp.mailer.(*testMailer).last(u.Email)
+	require.True(t, ok)
+	assert.Contains(t, mail.Body, "/password/reset?token=")
+
+	resetURL, err := url.Parse(location)
+	require.NoError(t, err)
+	w = serveAs(testApp.passwordReset, nil, http.MethodPost, "/password/reset", url.Values{
+		"token":        {resetURL.Query().Get("token")},
+		"new_password": {"a long and unusual passphrase 7"},
+	})
+	require.Equal(t, http.StatusSeeOther, w.Code)
+	assert.Equal(t, "/login", w.Header().Get("Location"))
+	_, err = testApp.userRepo.Authenticate(u.Email, "a long and unusual passphrase 7")
+	assert.NoError(t, err)
+}
+
+func TestPasswordForgot(t *testing.T) {
+	defer cleanupTestData(t)
+	u := createTestUser(t, "Forgetful", "forgetful@test.com")
+	_, err := testApp.userRepo.RequirePasswordReset(u.ID)
+	require.NoError(t, err)
+
+	w := serveAs(testApp.passwordForgot, nil, http.MethodPost, "/password/forgot", url.Values{"email": {"nobody@test.com"}})
+	require.Equal(t, http.StatusSeeOther, w.Code)
+	_, ok := testApp.mailer.(*testMailer).last("nobody@test.com")
+	assert.False(t, ok)
+
+	w = serveAs(testApp.passwordForgot, nil, http.MethodPost, "/password/forgot", url.Values{"email": {u.Email}})
+	require.Equal(t, http.StatusSeeOther, w.Code)
+	assert.Equal(t, "/login", w.Header().Get("Location"))
+	mail, ok := testApp.mailer.(*testMailer).last(u.Email)
+	require.True(t, ok)
+	token := regexp.MustCompile(`reset\?token=(\S+)`).FindStringSubmatch(mail.Body)
+	require.Len(t, token, 2)
+	before, err := testApp.userRepo.GetUserByID(u.ID)
+	require.NoError(t, err)
+
+	w = serveAs(testApp.passwordReset, nil, http.MethodPost, "/password/reset", url.Values{
+		"token":        {token[1]},
+		"new_password": {"a long and unusual passphrase 7"},
+	})
+	require.Equal(t, http.StatusSeeOther, w.Code)
+	_, err = testApp.userRepo.Authenticate(u.Email, "a long and unusual passphrase 7")
+	assert.NoError(t, err)
+
+	// The reset logs out every session, and its link works only once.
+	after, err := testApp.userRepo.GetUserByID(u.ID)
+	require.NoError(t, err)
+	assert.Equal(t, before.SessionEpoch+1, after.SessionEpoch)
+	_, err = testApp.userRepo.ResetPassword(token[1], "another long passphrase 8")
+	assert.ErrorIs(t, err, ErrInvalidToken)
+}
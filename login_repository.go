@@ -0,0 +1,167 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// loginAlertTTL is how long the "this wasn't me" link in a new-device alert
+// stays valid.
+const loginAlertTTL = 7 * 24 * time.Hour
+
+// LoginEvent is one attempt to log in. UserID is 0 when the email address
+// did not belong to an account. Device is the hash of the browser's device
+// cookie and Network the IP prefix it connected from.
+type LoginEvent struct {
+	ID        int       `json:"-"`
+	UserID    int       `json:"-"`
+	Email     string    `json:"email"`
+	Success   bool      `json:"success"`
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"user_agent"`
+	Device    string    `json:"-"`
+	Network   string    `json:"-"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// LoginFamiliarity describes how a login compares with the user's earlier
+// successful logins.
+type LoginFamiliarity struct {
+	FirstLogin   bool
+	KnownDevice  bool
+	KnownNetwork bool
+}
+
+type LoginRepository interface {
+	RecordLogin(e LoginEvent) (int, error)
+	GetLoginHistory(userID, limit int) ([]LoginEvent, error)
+	GetLoginFamiliarity(userID int, device, network string) (LoginFamiliarity, error)
+	CreateLoginAlert(eventID int) (string, error)
+	GetLoginAlert(token string) (*LoginEvent, error)
+	RedeemLoginAlert(token string) (*LoginEvent, error)
+}
+
+type SQLLoginRepository struct {
+	db *sql.DB
+}
+
+// NewSQLLoginRepository creates a new instance of SQLLoginRepository
+func NewSQLLoginRepository(db *sql.DB) *SQLLoginRepository {
+	return &SQLLoginRepository{db: db}
+}
+
+const loginEventSelect = `SELECT id, COALESCE(user_id, 0), email, success, ip, user_agent, device, network, created_at
+	FROM login_events`
+
+func scanLoginEvent(row rowScanner) (*LoginEvent, error) {
+	var e LoginEvent
+	err := row.Scan(&e.ID, &e.UserID, &e.Email, &e.Success, &e.IP, &e.UserAgent, &e.Device, &e.Network, &e.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+func (r *SQLLoginRepository) RecordLogin(e LoginEvent) (int, error) {
+	var userID sql.NullInt64
+	if e.UserID != 0 {
+		userID = sql.NullInt64{Int64: int64(e.UserID), Valid: true}
+	}
+	stmt := `INSERT INTO login_events (user_id, email, success, ip, user_agent, device, network, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
+	result, err := r.db.Exec(stmt, userID, e.Email, e.Success, e.IP, e.UserAgent, e.Device, e.Network, time.Now().UTC())
+	if err != nil {
+		return 0, err
+	}
+	id, err := result.LastInsertId()
+	return int(id), err
+}
+
+// GetLoginHistory returns the user's most recent login attempts, newest
+// first.
+func (r *SQLLoginRepository) GetLoginHistory(userID, limit int) ([]LoginEvent, error) {
+	rows, err := r.db.Query(loginEventSelect+" WHERE user_id = ? ORDER BY created_at DESC, id DESC LIMIT ?", userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []LoginEvent
+	for rows.Next() {
+		e, err := scanLoginEvent(rows)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, *e)
+	}
+	return events, rows.Err()
+}
+
+// GetLoginFamiliarity compares device and network with the user's earlier
+// successful logins.
+func (r *SQLLoginRepository) GetLoginFamiliarity(userID int, device, network string) (LoginFamiliarity, error) {
+	var logins, devices, networks int
+	stmt := `SELECT COUNT(*),
+		COALESCE(SUM(CASE WHEN device = ? THEN 1 ELSE 0 END), 0),
+		COALESCE(SUM(CASE WHEN network = ? THEN 1 ELSE 0 END), 0)
+		FROM login_events WHERE user_id = ? AND success = 1`
+	if err := r.db.QueryRow(stmt, device, network, userID).Scan(&logins, &devices, &networks); err != nil {
+		return LoginFamiliarity{}, err
+	}
+	return LoginFamiliarity{
+		FirstLogin:   logins == 0,
+		KnownDevice:  devices > 0,
+		KnownNetwork: networks > 0,
+	}, nil
+}
+
+// CreateLoginAlert returns the token of the "this wasn't me" link for a
+// login event.
+func (r *SQLLoginRepository) CreateLoginAlert(eventID int) (string, error) {
+	token := randomToken(32)
+	_, err := r.db.Exec("UPDATE login_events SET alert_token_hash = ? WHERE id = ?", hashToken(token), eventID)
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// GetLoginAlert returns the login a "this wasn't me" token reports, without
+// consuming the token.
+func (r *SQLLoginRepository) GetLoginAlert(token string) (*LoginEvent, error) {
+	e, err := scanLoginEvent(r.db.QueryRow(loginEventSelect+" WHERE alert_token_hash = ?", hashToken(token)))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrInvalidToken
+	} else if err != nil {
+		return nil, err
+	}
+	if time.Now().After(e.CreatedAt.Add(loginAlertTTL)) {
+		return nil, ErrInvalidToken
+	}
+	return e, nil
+}
+
+// RedeemLoginAlert consumes a "this wasn't me" token and returns the login
+// it was sent for, or ErrInvalidToken.
+func (r *SQLLoginRepository) RedeemLoginAlert(token string) (*LoginEvent, error) {
+	e, err := scanLoginEvent(r.db.QueryRow(loginEventSelect+" WHERE alert_token_hash = ?", hashToken(token)))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrInvalidToken
+	} else if err != nil {
+		return nil, err
+	}
+	res, err := r.db.Exec("UPDATE login_events SET alert_token_hash = NULL WHERE id = ? AND alert_token_hash = ?", e.ID, hashToken(token))
+	if err != nil {
+		return nil, err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return nil, err
+	} else if n == 0 {
+		return nil, ErrInvalidToken
+	}
+	if time.Now().After(e.CreatedAt.Add(loginAlertTTL)) {
+		return nil, ErrInvalidToken
+	}
+	return e, nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// Mailer sends plain text email.
+type Mailer interface {
+	Send(to, subject, body string) error
+}
+
+// LogMailer writes messages to a logger instead of sending them. It is used
+// when no SMTP server is configured, e.g. in local development.
+type LogMailer struct {
+	log *log.Logger
+}
+
+func NewLogMailer(l *log.Logger) *LogMailer {
+	return &LogMailer{log: l}
+}
+
+func (m *LogMailer) Send(to, subject, body string) error {
+	m.log.Printf("mail to %s: %s\n%s", to, subject, body)
+	return nil
+}
+
+// SMTPMailer delivers messages through an SMTP relay using PLAIN auth when
+// credentials are configured.
+type SMTPMailer struct {
+	Addr     string
+	Username string
+	Password string
+	From     string
+}
+
+func (m *SMTPMailer) Send(to, subject, body string) error {
+	var auth smtp.Auth
+	if m.Username != "" {
+		host, _, err := net.SplitHostPort(m.Addr)
+		if err != nil {
+			return err
+		}
+		auth = smtp.PlainAuth("", m.Username, m.Password, host)
+	}
+
+	var msg strings.Builder
+	fmt.Fprintf(&msg, "From: %s\r\n", m.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", to)
+	fmt.Fprintf(&msg, "Subject: %s\r\n", subject)
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+
+	return smtp.SendMail(m.Addr, auth, m.From, []string{to}, []byte(msg.String()))
+}
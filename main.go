@@ -1,49 +1,212 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"flag"
+	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/golangcollege/sessions"
 )
 
+const (
+	authModeLocal = "local"
+	authModeProxy = "proxy"
+)
+
+// config holds the settings read from command line flags.
+type config struct {
+	oidc OIDCConfig
+	// authMode is either authModeLocal (login form, registration and
+	// optional SSO) or authModeProxy, where an authenticating reverse proxy
+	// in trustedProxies identifies the user through request headers.
+	authMode       string
+	trustedProxies []*net.IPNet
+	password       struct {
+		minLength    int
+		minScore     int
+		breachedFile string
+	}
+	// baseURL is the public address of the site, used for links in email.
+	baseURL string
+	smtp    SMTPMailer
+	// deletionGrace is how long a deleted account can still be restored
+	// before it is anonymized.
+	deletionGrace time.Duration
+	registration  RegistrationPolicy
+	// inviteKarma is the karma members need before they can invite others.
+	inviteKarma int
+	// exportDir holds generated personal data exports until they expire.
+	exportDir string
+	// duplicateWindow is how long a link cannot be submitted again; the
+	// submitter is sent to the existing discussion instead.
+	duplicateWindow time.Duration
+	// scimToken is the bearer token of the SCIM provisioning API, which is
+	// disabled when it is empty.
+	scimToken string
+	// checkLinks turns on the periodic check for dead links.
+	checkLinks bool
+	// snapshots turns on keeping a copy of each submitted page in
+	// snapshotDir; copies are removed after snapshotRetention, or never
+	// when it is 0.
+	snapshots         bool
+	snapshotDir       string
+	snapshotRetention time.Duration
+}
+
 // application holds the dependencies for our web application, such as loggers and the user repository.
 type application struct {
-	errorLog    *log.Logger
-	infoLog     *log.Logger
-	userRepo    UserRepository
-	postRepo    PostRepository
-	templateDir string
-	publicPath  string
-	tp          *TemplateRenderer
-	session     *sessions.Session
+	config         config
+	errorLog       *log.Logger
+	infoLog        *log.Logger
+	userRepo       UserRepository
+	postRepo       PostRepository
+	oauthRepo      OAuthRepository
+	exportRepo     ExportRepository
+	invitationRepo InvitationRepository
+	loginRepo      LoginRepository
+	searchRepo     SavedSearchRepository
+	linkRepo       LinkCheckRepository
+	snapshotRepo   SnapshotRepository
+	templateDir    string
+	publicPath     string
+	tp             *TemplateRenderer
+	session        *sessions.Session
+	oidc           *OIDCProvider // nil unless single sign-on is configured
+	passwords      *PasswordPolicy
+	mailer         Mailer
+	suggester      *Suggester
+	fetcher        *LinkFetcher
+	snapshots      *Snapshotter // nil unless snapshots are enabled
+	// wg tracks work started with background.
+	wg sync.WaitGroup
 }
 
 func main() {
+	var cfg config
+	var err error
+	flag.StringVar(&cfg.oidc.Issuer, "oidc-issuer", "", "OpenID Connect issuer URL; enables single sign-on when set")
+	flag.StringVar(&cfg.oidc.ClientID, "oidc-client-id", "", "OpenID Connect client ID")
+	flag.StringVar(&cfg.oidc.ClientSecret, "oidc-client-secret", "", "OpenID Connect client secret")
+	flag.StringVar(&cfg.oidc.RedirectURL, "oidc-redirect-url", "http://localhost:8080/auth/oidc/callback", "OpenID Connect redirect URL")
+	flag.StringVar(&cfg.authMode, "auth-mode", authModeLocal, "authentication mode: local or proxy")
+	flag.IntVar(&cfg.password.minLength, "password-min-length", 10, "minimum password length")
+	flag.IntVar(&cfg.password.minScore, "password-min-score", 2, "minimum password strength score, 0 (any) to 4")
+	flag.StringVar(&cfg.password.breachedFile, "breached-passwords", "", "path to a sorted SHA-1 breached password list (HIBP format)")
+	flag.StringVar(&cfg.baseURL, "base-url", "http://localhost:8080", "public URL of the site, used in email links")
+	flag.StringVar(&cfg.smtp.Addr, "smtp-addr", "", "SMTP relay host:port; email is only logged when empty")
+	flag.StringVar(&cfg.smtp.Username, "smtp-username", "", "SMTP username")
+	flag.StringVar(&cfg.smtp.Password, "smtp-password", "", "SMTP password")
+	flag.StringVar(&cfg.smtp.From, "smtp-from", "hnews <no-reply@localhost>", "sender address for email")
+	flag.DurationVar(&cfg.deletionGrace, "deletion-grace", 14*24*time.Hour, "how long deleted accounts can be restored before they are anonymized")
+	registrationMode := flag.String("registration", registrationOpen, "who may register: open, closed, domains or approval")
+	registrationDomainList := flag.String("registration-domains", "", "comma separated email domains allowed to register and log in with -registration domains")
+	flag.IntVar(&cfg.inviteKarma, "invite-karma", 10, "karma needed to send invitations")
+	flag.StringVar(&cfg.exportDir, "export-dir", "./exports", "directory for personal data export archives")
+	flag.DurationVar(&cfg.duplicateWindow, "duplicate-window", 30*24*time.Hour, "how long a link cannot be submitted again; 0 allows duplicates")
+	flag.StringVar(&cfg.scimToken, "scim-token", "", "bearer token for the SCIM 2.0 provisioning API at /scim/v2; disabled when empty")
+	flag.BoolVar(&cfg.checkLinks, "check-links", true, "periodically check posted links and mark dead ones")
+	flag.BoolVar(&cfg.snapshots, "snapshots", false, "keep a readable copy of each submitted page")
+	flag.StringVar(&cfg.snapshotDir, "snapshot-dir", "./snapshots", "directory for the copies kept with -snapshots")
+	flag.DurationVar(&cfg.snapshotRetention, "snapshot-retention", 90*24*time.Hour, "how long copies of pages are kept; 0 keeps them forever")
+	trustedProxies := flag.String("trusted-proxies", "", "comma separated CIDRs of proxies trusted to set X-Forwarded-User/X-Forwarded-Email")
+	flag.Parse()
+
+	cfg.trustedProxies, err = parseCIDRs(*trustedProxies)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if cfg.authMode != authModeLocal && cfg.authMode != authModeProxy {
+		log.Fatalf("unknown -auth-mode %q", cfg.authMode)
+	}
+	if cfg.authMode == authModeProxy && len(cfg.trustedProxies) == 0 {
+		log.Fatal("-auth-mode proxy requires -trusted-proxies")
+	}
+	cfg.registration, err = ParseRegistrationPolicy(*registrationMode, *registrationDomainList)
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	db, err := connectToDatabase("users_database.db")
 	if err != nil {
 		log.Fatal(err)
 	}
 	defer db.Close()
+	if err := migrate(db); err != nil {
+		log.Fatal(err)
+	}
 
 	session := sessions.New([]byte("u46IpCV9y5Vlur8YvODJEhgOY8m9JVE4"))
 	session.Lifetime = 24 * time.Hour
 	session.Secure = true
 	session.SameSite = http.SameSiteLaxMode
 
+	errorLog := log.New(os.Stderr, "ERROR\t", log.Ldate|log.Ltime|log.LUTC|log.Lshortfile)
+	users := NewSQLUserRepository(db)
+	users.errorLog = errorLog
+
 	app := &application{
-		errorLog:    log.New(os.Stderr, "ERROR\t", log.Ldate|log.Ltime|log.LUTC|log.Lshortfile),
-		infoLog:     log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime|log.LUTC|log.Lshortfile),
-		userRepo:    NewSQLUserRepository(db),
-		postRepo:    NewSQLPostRepository(db),
-		templateDir: "./templates",
-		publicPath:  "./public",
-		session:     session,
+		config:         cfg,
+		errorLog:       errorLog,
+		infoLog:        log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime|log.LUTC|log.Lshortfile),
+		userRepo:       users,
+		postRepo:       NewSQLPostRepository(db),
+		oauthRepo:      NewSQLOAuthRepository(db),
+		exportRepo:     NewSQLExportRepository(db),
+		invitationRepo: NewSQLInvitationRepository(db),
+		loginRepo:      NewSQLLoginRepository(db),
+		searchRepo:     NewSQLSavedSearchRepository(db),
+		linkRepo:       NewSQLLinkCheckRepository(db),
+		snapshotRepo:   NewSQLSnapshotRepository(db),
+		templateDir:    "./templates",
+		publicPath:     "./public",
+		session:        session,
+		suggester:      NewSuggester(),
+		fetcher:        NewLinkFetcher(),
+		passwords: &PasswordPolicy{
+			MinLength: cfg.password.minLength,
+			MinScore:  cfg.password.minScore,
+		},
 	}
 	app.tp = NewTemplateRenderer(app.templateDir, false) // 2nd parameter isDev is for running in localdev
+	users.registration = &app.config.registration
+	if cfg.snapshots {
+		app.snapshots = NewSnapshotter(NewFileBlobStore(cfg.snapshotDir), app.fetcher)
+	}
+
+	if cfg.smtp.Addr != "" {
+		app.mailer = &cfg.smtp
+	} else {
+		app.mailer = NewLogMailer(app.infoLog)
+	}
+
+	if cfg.password.breachedFile != "" {
+		app.passwords.Breached, err = OpenBreachedPasswords(cfg.password.breachedFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer app.passwords.Breached.Close()
+	}
+
+	if cfg.oidc.Issuer != "" && cfg.authMode == authModeLocal {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		app.oidc, err = NewOIDCProvider(ctx, cfg.oidc, nil)
+		cancel()
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	ctx, stopJobs := context.WithCancel(context.Background())
+	defer stopJobs()
+	app.startJobs(ctx)
 
 	log.Println("Listening on :8080")
 	if err := app.serve(); err != nil {
@@ -74,3 +237,33 @@ func connectToDatabase(dbName string) (*sql.DB, error) {
 	}
 	return db, nil
 }
+
+// parseCIDRs parses a comma separated list of CIDRs; bare IP addresses are
+// treated as single-host networks.
+func parseCIDRs(list string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if !strings.Contains(item, "/") {
+			ip := net.ParseIP(item)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid proxy address %q", item)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(item)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"strings"
 )
 
 type contextKey string
@@ -36,6 +37,10 @@ func (app *application) recover(next http.Handler) http.Handler {
 
 func (app *application) requireAuth(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !app.isAuthenticated(r) && app.config.authMode == authModeProxy {
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
 		if !app.isAuthenticated(r) {
 			http.Redirect(w, r, fmt.Sprintf("/login?redirectTo=%s", r.URL.Path), http.StatusSeeOther)
 			return
@@ -45,6 +50,18 @@ func (app *application) requireAuth(next http.Handler) http.Handler {
 	})
 }
 
+// requireAdmin must be chained after requireAuth.
+func (app *application) requireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u := app.getUserFromContext(r.Context())
+		if !u.IsAdmin {
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
 func (app *application) isAuthenticated(r *http.Request) bool {
 	isAuth, ok := r.Context().Value(contextAuthKey).(bool)
 	if !ok {
@@ -70,8 +87,75 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 			app.serverError(w, err)
 			return
 		}
+		// Sessions started before the user's sessions were revoked, or of
+		// an account that is not active, are no longer valid.
+		if u.Status != userStatusActive || app.session.GetInt(r, sessionEpochKey) != u.SessionEpoch {
+			app.session.Remove(r, loggedInUserKey)
+			app.session.Remove(r, sessionEpochKey)
+			next.ServeHTTP(w, r)
+			return
+		}
 		ctx := context.WithValue(r.Context(), contextAuthKey, true)
 		ctx = context.WithValue(ctx, contextUserKey, u)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
+
+// authenticateProxy replaces authenticate when hnews runs behind an
+// authenticating reverse proxy. The X-Forwarded-User and X-Forwarded-Email
+// headers are only believed when the request comes directly from one of the
+// trusted proxy networks; unknown users are provisioned on first sight.
+func (app *application) authenticateProxy(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		email := strings.TrimSpace(r.Header.Get("X-Forwarded-Email"))
+		if email == "" || !app.fromTrustedProxy(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		u, err := app.userRepo.GetUserByEmail(email)
+		if errors.Is(err, sql.ErrNoRows) {
+			name := strings.TrimSpace(r.Header.Get("X-Forwarded-User"))
+			if name == "" {
+				name, _, _ = strings.Cut(email, "@")
+			}
+			// Unknown users are provisioned only if they could register.
+			if err := app.config.registration.CheckSignup(email); err != nil {
+				app.infoLog.Printf("not provisioning %s from proxy headers: %v", email, err)
+				next.ServeHTTP(w, r)
+				return
+			}
+			// A concurrent request may provision the same user first, so a
+			// failed insert is followed by another lookup.
+			if _, err := app.userRepo.CreateUser(name, email, randomToken(32), ""); err == nil {
+				app.infoLog.Printf("provisioned user %s from proxy headers", email)
+			}
+			u, err = app.userRepo.GetUserByEmail(email)
+		}
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+		if u.Status != userStatusActive || app.config.registration.CheckLogin(u) != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ctx := context.WithValue(r.Context(), contextAuthKey, true)
+		ctx = context.WithValue(ctx, contextUserKey, u)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// fromTrustedProxy reports whether the direct peer of r is a trusted proxy.
+func (app *application) fromTrustedProxy(r *http.Request) bool {
+	ip := remoteIP(r)
+	if ip == nil {
+		return false
+	}
+	for _, n := range app.config.trustedProxies {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
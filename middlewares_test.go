@@ -3,12 +3,14 @@ package main
 import (
 	"bytes"
 	"context"
+	"database/sql"
 	"log"
 	"net/http"
 	"net/http/httptest"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestLogger(t *testing.T) {
@@ -126,6 +128,126 @@ func TestAuthenticate_ValidSession(t *testing.T) {
 
 }
 
+func setupProxyAuth(t *testing.T, cidrs string) {
+	nets, err := parseCIDRs(cidrs)
+	assert.NoError(t, err)
+	testApp.config.authMode = authModeProxy
+	testApp.config.trustedProxies = nets
+	t.Cleanup(func() {
+		testApp.config.authMode = authModeLocal
+		testApp.config.trustedProxies = nil
+	})
+}
+
+func TestAuthenticateProxy_TrustedProxyProvisionsUser(t *testing.T) {
+	defer cleanupTestData(t)
+	setupProxyAuth(t, "10.0.0.0/8, 192.168.1.1")
+
+	testHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.True(t, testApp.isAuthenticated(r))
+		user := testApp.getUserFromContext(r.Context())
+		assert.Equal(t, "proxy@test.com", user.Email)
+		assert.Equal(t, "proxyuser", user.Name)
+		w.WriteHeader(http.StatusOK)
+	})
+
+	for _, addr := range []string{"10.1.2.3:5555", "192.168.1.1:4444"} {
+		req := httptest.NewRequest(http.MethodGet, "/test", nil)
+		req.RemoteAddr = addr
+		req.Header.Set("X-Forwarded-User", "proxyuser")
+		req.Header.Set("X-Forwarded-Email", "proxy@test.com")
+		w := httptest.NewRecorder()
+		testApp.authenticateProxy(testHandler).ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+	}
+
+	users, err := testApp.userRepo.GetUsers()
+	assert.NoError(t, err)
+	assert.Len(t, users, 1)
+}
+
+func TestAuthenticateProxy_UntrustedPeerIgnored(t *testing.T) {
+	defer cleanupTestData(t)
+	setupProxyAuth(t, "10.0.0.0/8")
+
+	testHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.False(t, testApp.isAuthenticated(r))
+		w.WriteHeader(http.StatusOK)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/test", nil)
+	req.RemoteAddr = "203.0.113.9:1234"
+	req.Header.Set("X-Forwarded-Email", "attacker@test.com")
+	w := httptest.NewRecorder()
+	testApp.authenticateProxy(testHandler).ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	users, err := testApp.userRepo.GetUsers()
+	assert.NoError(t, err)
+	assert.Len(t, users, 0)
+}
+
+func TestAuthenticateProxy_AppliesRegistrationPolicy(t *testing.T) {
+	defer cleanupTestData(t)
+	setupProxyAuth(t, "10.0.0.0/8")
+	setRegistrationPolicy(t, RegistrationPolicy{Mode: registrationDomains, Domains: []string{"corp.example"}})
+	createTestUser(t, "Old Member", "old@test.com")
+
+	testHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.False(t, testApp.isAuthenticated(r))
+		w.WriteHeader(http.StatusOK)
+	})
+	for _, email := range []string{"outsider@test.com", "old@test.com"} {
+		req := httptest.NewRequest(http.MethodGet, "/test", nil)
+		req.RemoteAddr = "10.1.2.3:5555"
+		req.Header.Set("X-Forwarded-Email", email)
+		w := httptest.NewRecorder()
+		testApp.authenticateProxy(testHandler).ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+	}
+
+	_, err := testApp.userRepo.GetUserByEmail("outsider@test.com")
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+}
+
+func TestRoutes_ProxyModeDisablesLocalLogin(t *testing.T) {
+	setupProxyAuth(t, "10.0.0.0/8")
+	handler := testApp.routes()
+
+	for _, path := range []string{"/login", "/register"} {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusNotFound, w.Code, path)
+	}
+}
+
 func contextWithAuth(ctx context.Context, isAuth interface{}) context.Context {
 	return context.WithValue(ctx, contextAuthKey, isAuth)
 }
+
+func TestAuthenticate_RevokedSessionLoggedOut(t *testing.T) {
+	defer cleanupTestData(t)
+	u := createTestUser(t, "Revoked User", "revoked@test.com")
+
+	setupHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		testApp.startSession(r, u)
+		w.WriteHeader(http.StatusOK)
+	})
+	w1 := httptest.NewRecorder()
+	testApp.session.Enable(setupHandler).ServeHTTP(w1, httptest.NewRequest(http.MethodGet, "/setup", nil))
+
+	require.NoError(t, testApp.userRepo.RevokeSessions(u.ID))
+
+	testHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.False(t, testApp.isAuthenticated(r))
+		w.WriteHeader(http.StatusOK)
+	})
+	req := httptest.NewRequest(http.MethodGet, "/test", nil)
+	for _, cookie := range w1.Result().Cookies() {
+		req.AddCookie(cookie)
+	}
+	w2 := httptest.NewRecorder()
+	testApp.session.Enable(testApp.authenticate(testHandler)).ServeHTTP(w2, req)
+	assert.Equal(t, http.StatusOK, w2.Code)
+}
@@ -0,0 +1,410 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// migration is one step in bringing a database up to the schema in
+// scripts/table.sql.
+type migration struct {
+	name string
+	up   func(tx *sql.Tx) error
+}
+
+// migrations run in order, each once and in its own transaction. PRAGMA
+// user_version records how many have run; scripts/table.sql sets it to
+// len(migrations) because it already has the latest schema. Tables are
+// created IF NOT EXISTS and columns only added when missing, so databases
+// created from an older scripts/table.sql, which start at version 0, are
+// upgraded too. Append new migrations; never edit or reorder old ones.
+var migrations = []migration{
+	sqlMigration("create the original tables",
+		`CREATE TABLE IF NOT EXISTS users (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT NOT NULL,
+			email TEXT NOT NULL UNIQUE,
+			hashed_password TEXT NOT NULL,
+			created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE TABLE IF NOT EXISTS profiles (
+			user_id INTEGER PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+			avatar TEXT,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE TABLE IF NOT EXISTS posts (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			url TEXT NOT NULL,
+			title TEXT NOT NULL UNIQUE,
+			user_id INTEGER REFERENCES users(user_id) ON DELETE CASCADE,
+			created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE TABLE IF NOT EXISTS comments (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			body TEXT NOT NULL,
+			user_id INTEGER REFERENCES users(user_id) ON DELETE CASCADE,
+			post_id INTEGER REFERENCES posts(post_id) ON DELETE CASCADE,
+			created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE TABLE IF NOT EXISTS votes (
+			user_id INTEGER REFERENCES users(user_id) ON DELETE CASCADE,
+			post_id INTEGER REFERENCES posts(post_id) ON DELETE CASCADE,
+			created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (user_id, post_id)
+		)`,
+	),
+	sqlMigration("add single sign-on identities",
+		`CREATE TABLE IF NOT EXISTS user_identities (
+			provider TEXT NOT NULL,
+			subject TEXT NOT NULL,
+			user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (provider, subject)
+		)`,
+	),
+	{"add admins and the OAuth2 server", func(tx *sql.Tx) error {
+		if err := addColumn(tx, "users", "is_admin", "BOOLEAN NOT NULL DEFAULT 0"); err != nil {
+			return err
+		}
+		return execAll(tx,
+			`CREATE TABLE IF NOT EXISTS oauth_clients (
+				id TEXT PRIMARY KEY,
+				name TEXT NOT NULL,
+				redirect_uri TEXT NOT NULL,
+				secret_hash TEXT NOT NULL,
+				created_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+				created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+			)`,
+			`CREATE TABLE IF NOT EXISTS oauth_codes (
+				code_hash TEXT PRIMARY KEY,
+				client_id TEXT NOT NULL REFERENCES oauth_clients(id) ON DELETE CASCADE,
+				user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+				redirect_uri TEXT NOT NULL,
+				scope TEXT NOT NULL,
+				code_challenge TEXT NOT NULL,
+				expires_at DATETIME NOT NULL
+			)`,
+			`CREATE TABLE IF NOT EXISTS oauth_tokens (
+				token_hash TEXT PRIMARY KEY,
+				client_id TEXT NOT NULL REFERENCES oauth_clients(id) ON DELETE CASCADE,
+				user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+				kind TEXT NOT NULL,
+				scope TEXT NOT NULL,
+				expires_at DATETIME NOT NULL,
+				revoked_at DATETIME,
+				created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+			)`,
+		)
+	}},
+	sqlMigration("add email changes",
+		`CREATE TABLE IF NOT EXISTS email_changes (
+			token_hash TEXT PRIMARY KEY,
+			user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			new_email TEXT NOT NULL,
+			expires_at DATETIME NOT NULL,
+			created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+		)`,
+	),
+	{"add account status, karma and deletion", func(tx *sql.Tx) error {
+		for _, c := range [][2]string{
+			{"status", "TEXT NOT NULL DEFAULT 'active'"},
+			{"karma", "INTEGER NOT NULL DEFAULT 0"},
+			{"session_epoch", "INTEGER NOT NULL DEFAULT 0"},
+			{"deletion_scheduled_at", "DATETIME"},
+		} {
+			if err := addColumn(tx, "users", c[0], c[1]); err != nil {
+				return err
+			}
+		}
+		// karma is the number of votes a user's posts have received
+		_, err := tx.Exec(`UPDATE users SET karma = (SELECT COUNT(*) FROM votes v
+			INNER JOIN posts p ON p.id = v.post_id WHERE p.user_id = users.id)`)
+		return err
+	}},
+	sqlMigration("add data exports",
+		`CREATE TABLE IF NOT EXISTS data_exports (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			token_hash TEXT NOT NULL UNIQUE,
+			status TEXT NOT NULL DEFAULT 'pending',
+			path TEXT NOT NULL DEFAULT '',
+			expires_at DATETIME NOT NULL,
+			created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+		)`,
+	),
+	{"add invitations", func(tx *sql.Tx) error {
+		if err := addColumn(tx, "users", "invited_by", "INTEGER REFERENCES users(id) ON DELETE SET NULL"); err != nil {
+			return err
+		}
+		return execAll(tx,
+			`CREATE TABLE IF NOT EXISTS invitations (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				token_hash TEXT NOT NULL UNIQUE,
+				inviter_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+				email TEXT NOT NULL,
+				note TEXT NOT NULL DEFAULT '',
+				invitee_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+				used_at DATETIME,
+				created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+			)`,
+		)
+	}},
+	sqlMigration("add login history and password resets",
+		`CREATE TABLE IF NOT EXISTS login_events (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+			email TEXT NOT NULL,
+			success BOOLEAN NOT NULL,
+			ip TEXT NOT NULL,
+			user_agent TEXT NOT NULL,
+			device TEXT NOT NULL,
+			network TEXT NOT NULL,
+			alert_token_hash TEXT UNIQUE,
+			created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE INDEX IF NOT EXISTS login_events_user_id ON login_events (user_id, created_at)`,
+		`CREATE TABLE IF NOT EXISTS password_resets (
+			token_hash TEXT PRIMARY KEY,
+			user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			expires_at DATETIME NOT NULL,
+			created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+		)`,
+	),
+	{"add favorites", func(tx *sql.Tx) error {
+		if err := addColumn(tx, "users", "favorites_public", "BOOLEAN NOT NULL DEFAULT 1"); err != nil {
+			return err
+		}
+		return execAll(tx,
+			`CREATE TABLE IF NOT EXISTS favorite_posts (
+				user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+				post_id INTEGER NOT NULL REFERENCES posts(id) ON DELETE CASCADE,
+				created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+				PRIMARY KEY (user_id, post_id)
+			)`,
+			`CREATE TABLE IF NOT EXISTS favorite_comments (
+				user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+				comment_id INTEGER NOT NULL REFERENCES comments(id) ON DELETE CASCADE,
+				created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+				PRIMARY KEY (user_id, comment_id)
+			)`,
+		)
+	}},
+	sqlMigration("add hidden posts",
+		`CREATE TABLE IF NOT EXISTS hidden_posts (
+			user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			post_id INTEGER NOT NULL REFERENCES posts(id) ON DELETE CASCADE,
+			created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (user_id, post_id)
+		)`,
+	),
+	sqlMigration("add listing indexes",
+		`CREATE INDEX IF NOT EXISTS posts_created_at ON posts (created_at)`,
+		`CREATE INDEX IF NOT EXISTS posts_user_id ON posts (user_id, created_at)`,
+		`CREATE INDEX IF NOT EXISTS comments_post_id ON comments (post_id, created_at)`,
+		`CREATE INDEX IF NOT EXISTS comments_user_id ON comments (user_id, created_at)`,
+		`CREATE INDEX IF NOT EXISTS votes_post_id ON votes (post_id)`,
+		`CREATE INDEX IF NOT EXISTS favorite_posts_post_id ON favorite_posts (post_id)`,
+		`CREATE INDEX IF NOT EXISTS favorite_comments_comment_id ON favorite_comments (comment_id)`,
+	),
+	sqlMigration("add saved searches",
+		`CREATE TABLE IF NOT EXISTS saved_searches (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			query TEXT NOT NULL,
+			email BOOLEAN NOT NULL DEFAULT 0,
+			last_post_id INTEGER NOT NULL DEFAULT 0,
+			last_comment_id INTEGER NOT NULL DEFAULT 0,
+			emailed_at DATETIME,
+			created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE (user_id, query)
+		)`,
+		`CREATE TABLE IF NOT EXISTS search_alerts (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			search_id INTEGER NOT NULL REFERENCES saved_searches(id) ON DELETE CASCADE,
+			user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			post_id INTEGER NOT NULL REFERENCES posts(id) ON DELETE CASCADE,
+			comment_id INTEGER REFERENCES comments(id) ON DELETE CASCADE,
+			read_at DATETIME,
+			emailed_at DATETIME,
+			created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE INDEX IF NOT EXISTS search_alerts_user_id ON search_alerts (user_id, read_at)`,
+		`CREATE INDEX IF NOT EXISTS search_alerts_search_id ON search_alerts (search_id, emailed_at)`,
+	),
+	{"add sites and site sanctions", func(tx *sql.Tx) error {
+		if err := addColumn(tx, "posts", "site", "TEXT NOT NULL DEFAULT ''"); err != nil {
+			return err
+		}
+		err := execAll(tx,
+			`CREATE TABLE IF NOT EXISTS site_sanctions (
+				site TEXT PRIMARY KEY,
+				status TEXT NOT NULL,
+				reason TEXT NOT NULL DEFAULT '',
+				admin_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+				created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+			)`,
+			`CREATE INDEX IF NOT EXISTS posts_site ON posts (site)`,
+		)
+		if err != nil {
+			return err
+		}
+		return updatePostURLs(tx, func(url string) (string, string) { return url, siteOf(url) })
+	}},
+	{"allow duplicate titles and canonicalize links", func(tx *sql.Tx) error {
+		// SQLite cannot drop a UNIQUE constraint, so posts is rebuilt. The
+		// AUTOINCREMENT counter is carried over so that ids of deleted
+		// posts are not handed out again.
+		var seq int
+		err := tx.QueryRow("SELECT COALESCE(MAX(seq), 0) FROM sqlite_sequence WHERE name = 'posts'").Scan(&seq)
+		if err != nil {
+			return err
+		}
+		err = execAll(tx,
+			`CREATE TABLE posts_rebuilt (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				url TEXT NOT NULL,
+				title TEXT NOT NULL,
+				site TEXT NOT NULL DEFAULT '',
+				user_id INTEGER REFERENCES users(user_id) ON DELETE CASCADE,
+				created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+			)`,
+			`INSERT INTO posts_rebuilt (id, url, title, site, user_id, created_at)
+				SELECT id, url, title, site, user_id, created_at FROM posts`,
+			`DROP TABLE posts`,
+			`ALTER TABLE posts_rebuilt RENAME TO posts`,
+			`CREATE INDEX posts_created_at ON posts (created_at)`,
+			`CREATE INDEX posts_user_id ON posts (user_id, created_at)`,
+			`CREATE INDEX posts_site ON posts (site)`,
+			`CREATE INDEX posts_url ON posts (url)`,
+		)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec("UPDATE sqlite_sequence SET seq = MAX(seq, ?) WHERE name = 'posts'", seq); err != nil {
+			return err
+		}
+
+		// duplicate detection only finds links stored in canonical form
+		return updatePostURLs(tx, func(url string) (string, string) {
+			if canonical, err := canonicalURL(url); err == nil {
+				url = canonical
+			}
+			return url, siteOf(url)
+		})
+	}},
+	sqlMigration("add link checks",
+		`CREATE TABLE IF NOT EXISTS link_checks (
+			post_id INTEGER PRIMARY KEY REFERENCES posts(id) ON DELETE CASCADE,
+			status INTEGER NOT NULL DEFAULT 0,
+			error TEXT NOT NULL DEFAULT '',
+			failures INTEGER NOT NULL DEFAULT 0,
+			dead BOOLEAN NOT NULL DEFAULT 0,
+			checked_at DATETIME NOT NULL,
+			next_check_at DATETIME NOT NULL
+		)`,
+		`CREATE INDEX IF NOT EXISTS link_checks_next_check_at ON link_checks (next_check_at)`,
+	),
+	sqlMigration("add snapshots",
+		`CREATE TABLE IF NOT EXISTS snapshots (
+			post_id INTEGER PRIMARY KEY REFERENCES posts(id) ON DELETE CASCADE,
+			blob_key TEXT NOT NULL,
+			url TEXT NOT NULL,
+			title TEXT NOT NULL DEFAULT '',
+			size INTEGER NOT NULL DEFAULT 0,
+			created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE INDEX IF NOT EXISTS snapshots_created_at ON snapshots (created_at)`,
+	),
+}
+
+// sqlMigration is a migration that only runs statements.
+func sqlMigration(name string, stmts ...string) migration {
+	return migration{name, func(tx *sql.Tx) error { return execAll(tx, stmts...) }}
+}
+
+func execAll(tx *sql.Tx, stmts ...string) error {
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addColumn adds a column to table unless it is already there.
+func addColumn(tx *sql.Tx, table, column, definition string) error {
+	var exists bool
+	err := tx.QueryRow("SELECT EXISTS(SELECT 1 FROM pragma_table_info(?) WHERE name = ?)", table, column).Scan(&exists)
+	if err != nil || exists {
+		return err
+	}
+	_, err = tx.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	return err
+}
+
+// updatePostURLs sets the url and site of every post to what fn returns
+// for its current url.
+func updatePostURLs(tx *sql.Tx, fn func(url string) (string, string)) error {
+	rows, err := tx.Query("SELECT id, url FROM posts")
+	if err != nil {
+		return err
+	}
+	type post struct {
+		id  int
+		url string
+	}
+	var posts []post
+	for rows.Next() {
+		var p post
+		if err := rows.Scan(&p.id, &p.url); err != nil {
+			rows.Close()
+			return err
+		}
+		posts = append(posts, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, p := range posts {
+		url, site := fn(p.url)
+		if _, err := tx.Exec("UPDATE posts SET url = ?, site = ? WHERE id = ?", url, site, p.id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrate runs the migrations the database has not had yet.
+func migrate(db *sql.DB) error {
+	var version int
+	if err := db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return err
+	}
+	if version > len(migrations) {
+		return fmt.Errorf("the database schema is version %d, newer than this program's %d", version, len(migrations))
+	}
+	for i := version; i < len(migrations); i++ {
+		if err := runMigration(db, i); err != nil {
+			return fmt.Errorf("migration %d (%s): %w", i+1, migrations[i].name, err)
+		}
+	}
+	return nil
+}
+
+func runMigration(db *sql.DB, i int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := migrations[i].up(tx); err != nil {
+		return err
+	}
+	// user_version is part of the database file, so it commits with tx
+	if _, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", i+1)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
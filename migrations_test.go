@@ -0,0 +1,174 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// legacySchema is the schema of databases from before migrations, such as
+// the committed users_database.db.
+const legacySchema = `
+CREATE TABLE users (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	name TEXT NOT NULL,
+	email TEXT NOT NULL UNIQUE,
+	hashed_password TEXT NOT NULL,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+CREATE TABLE profiles (
+	user_id INTEGER PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+	avatar TEXT,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+CREATE TABLE posts (
+   id INTEGER PRIMARY KEY AUTOINCREMENT,
+   url TEXT NOT NULL,
+   title TEXT NOT NULL UNIQUE,
+   user_id INTEGER REFERENCES users(user_id) ON DELETE CASCADE,
+   created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE TABLE comments (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  body TEXT NOT NULL,
+  user_id INTEGER REFERENCES users(user_id) ON DELETE CASCADE,
+  post_id INTEGER REFERENCES posts(post_id) ON DELETE CASCADE,
+  created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE TABLE votes (
+   user_id INTEGER REFERENCES users(user_id) ON DELETE CASCADE,
+   post_id INTEGER REFERENCES posts(post_id) ON DELETE CASCADE,
+   created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+   PRIMARY KEY (user_id, post_id)
+);
+INSERT INTO users (id, name, email, hashed_password) VALUES
+	(1, 'Alice', 'alice@test.com', '$2a$10$legacy'),
+	(2, 'Bob', 'bob@test.com', '$2a$10$legacy');
+INSERT INTO posts (id, url, title, user_id) VALUES
+	(1, 'HTTPS://WWW.Example.com/story/?utm_source=x', 'A story', 1),
+	(2, 'https://blog.example.org/post', 'A post', 2);
+INSERT INTO posts (id, url, title, user_id) VALUES (3, 'https://deleted.example.com/', 'Deleted', 1);
+DELETE FROM posts WHERE id = 3;
+INSERT INTO votes (user_id, post_id) VALUES (1, 2), (2, 1), (2, 2);
+`
+
+// openMigrationDB opens an empty database on a single connection, since
+// every connection to :memory: gets a database of its own.
+func openMigrationDB(t *testing.T) *sql.DB {
+	db, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func userVersion(t *testing.T, db *sql.DB) int {
+	var version int
+	require.NoError(t, db.QueryRow("PRAGMA user_version").Scan(&version))
+	return version
+}
+
+func TestMigrate_LegacyDatabase(t *testing.T) {
+	db := openMigrationDB(t)
+	_, err := db.Exec(legacySchema)
+	require.NoError(t, err)
+
+	require.NoError(t, migrate(db))
+	assert.Equal(t, len(migrations), userVersion(t, db))
+	var isAdmin bool
+	var status string
+	var karma int
+	require.NoError(t, db.QueryRow("SELECT is_admin, status, karma FROM users WHERE id = 1").Scan(&isAdmin, &status, &karma))
+	assert.False(t, isAdmin)
+	assert.Equal(t, userStatusActive, status)
+	assert.Equal(t, 1, karma, "karma is counted from existing votes")
+	require.NoError(t, db.QueryRow("SELECT karma FROM users WHERE id = 2").Scan(&karma))
+	assert.Equal(t, 2, karma)
+
+	var site string
+	require.NoError(t, db.QueryRow("SELECT site FROM posts WHERE id = 2").Scan(&site))
+	assert.Equal(t, "blog.example.org", site, "sites are filled in for existing posts")
+
+	var link string
+	require.NoError(t, db.QueryRow("SELECT url, site FROM posts WHERE id = 1").Scan(&link, &site))
+	assert.Equal(t, "https://www.example.com/story", link, "existing links are canonicalized")
+	assert.Equal(t, "example.com", site)
+	_, err = db.Exec("INSERT INTO posts (url, title, user_id) VALUES ('https://example.net/', 'A story', 2)")
+	assert.NoError(t, err, "titles no longer have to be unique")
+	var id int
+	require.NoError(t, db.QueryRow("SELECT MAX(id) FROM posts").Scan(&id))
+	assert.Equal(t, 4, id, "post ids are not reused")
+
+	require.NoError(t, migrate(db), "migrating again does nothing")
+}
+
+// schemaOf describes a database's tables by their columns, with names, types
+// and primary key positions, and its indexes.
+func schemaOf(t *testing.T, db *sql.DB) map[string][]string {
+	rows, err := db.Query(`SELECT type, name, tbl_name FROM sqlite_master
+		WHERE type IN ('table', 'index') AND name NOT LIKE 'sqlite_%' ORDER BY name`)
+	require.NoError(t, err)
+	type object struct{ kind, name, table string }
+	var objects []object
+	for rows.Next() {
+		var o object
+		require.NoError(t, rows.Scan(&o.kind, &o.name, &o.table))
+		objects = append(objects, o)
+	}
+	require.NoError(t, rows.Close())
+
+	schema := map[string][]string{}
+	for _, o := range objects {
+		if o.kind == "index" {
+			schema["indexes"] = append(schema["indexes"], o.table+"."+o.name)
+			continue
+		}
+		cols, err := db.Query("SELECT name, type, pk FROM pragma_table_info(?) ORDER BY name", o.name)
+		require.NoError(t, err)
+		for cols.Next() {
+			var name, typ string
+			var pk int
+			require.NoError(t, cols.Scan(&name, &typ, &pk))
+			schema[o.name] = append(schema[o.name], fmt.Sprintf("%s %s pk=%d", name, typ, pk))
+		}
+		require.NoError(t, cols.Close())
+	}
+	return schema
+}
+
+func TestMigrate_MatchesSchema(t *testing.T) {
+	db := openMigrationDB(t)
+	_, err := db.Exec(legacySchema)
+	require.NoError(t, err)
+	require.NoError(t, migrate(db))
+	assert.Equal(t, schemaOf(t, testDB), schemaOf(t, db), "migrations must produce the schema in scripts/table.sql")
+
+	db = openMigrationDB(t)
+	require.NoError(t, migrate(db))
+	assert.Equal(t, schemaOf(t, testDB), schemaOf(t, db), "an empty database gets the whole schema")
+}
+
+func TestMigrate_EmptyDatabase(t *testing.T) {
+	db := openMigrationDB(t)
+	require.NoError(t, migrate(db))
+	assert.Equal(t, len(migrations), userVersion(t, db))
+
+	_, err := db.Exec("PRAGMA user_version = 1000")
+	require.NoError(t, err)
+	assert.Error(t, migrate(db), "a database from a newer version is refused")
+}
+
+func TestTableSQLVersion(t *testing.T) {
+	schema, err := os.ReadFile("scripts/table.sql")
+	require.NoError(t, err)
+	m := regexp.MustCompile(`PRAGMA user_version = (\d+);`).FindSubmatch(schema)
+	require.NotNil(t, m)
+	assert.Equal(t, strconv.Itoa(len(migrations)), string(m[1]),
+		"scripts/table.sql must set user_version to the number of migrations")
+}
@@ -4,12 +4,19 @@ import "time"
 
 // User represents a user in the system
 type User struct {
-	ID             int       `json:"id"`
-	Name           string    `json:"name"`
-	Email          string    `json:"email"`
-	HashedPassword string    `json:"-"` // Do not expose hashed password in JSON
-	CreatedAt      time.Time `json:"created_at"`
-	Profile        Profile   `json:"profile"`
+	ID                  int        `json:"id"`
+	Name                string     `json:"name"`
+	Email               string     `json:"email"`
+	HashedPassword      string     `json:"-"` // Do not expose hashed password in JSON
+	IsAdmin             bool       `json:"is_admin"`
+	Status              string     `json:"status"`
+	Karma               int        `json:"karma"`
+	SessionEpoch        int        `json:"-"` // bumped to log the user out everywhere
+	DeletionScheduledAt *time.Time `json:"deletion_scheduled_at,omitempty"`
+	InvitedBy           int        `json:"invited_by,omitempty"`
+	FavoritesPublic     bool       `json:"favorites_public"`
+	CreatedAt           time.Time  `json:"created_at"`
+	Profile             Profile    `json:"profile"`
 }
 
 // Profile represents a user's profile
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+const (
+	oauthTokenAccess  = "access"
+	oauthTokenRefresh = "refresh"
+)
+
+var ErrInvalidGrant = errors.New("invalid or expired grant")
+
+// OAuthClient is a third-party application registered by an admin.
+type OAuthClient struct {
+	ID          string    `json:"client_id"`
+	Name        string    `json:"name"`
+	RedirectURI string    `json:"redirect_uri"`
+	SecretHash  string    `json:"-"`
+	CreatedBy   int       `json:"created_by"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// OAuthCode is a pending authorization code waiting to be redeemed at the
+// token endpoint.
+type OAuthCode struct {
+	ClientID      string
+	UserID        int
+	RedirectURI   string
+	Scope         string
+	CodeChallenge string
+	ExpiresAt     time.Time
+}
+
+// OAuthToken is an issued access or refresh token. Only the SHA-256 of the
+// token value is stored.
+type OAuthToken struct {
+	ClientID  string    `json:"client_id"`
+	UserID    int       `json:"user_id"`
+	Kind      string    `json:"kind"`
+	Scope     string    `json:"scope"`
+	ExpiresAt time.Time `json:"expires_at"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type OAuthRepository interface {
+	CreateClient(name, redirectURI string, createdBy int) (*OAuthClient, string, error)
+	GetClient(id string) (*OAuthClient, error)
+	GetClients() ([]OAuthClient, error)
+	CreateAuthCode(code OAuthCode) (string, error)
+	RedeemAuthCode(code string) (*OAuthCode, error)
+	IssueTokens(clientID string, userID int, scope, refreshed string) (access, refresh string, err error)
+	GetToken(token string) (*OAuthToken, error)
+	RevokeToken(token string) error
+	RevokeUserTokens(userID int) error
+}
+
+type SQLOAuthRepository struct {
+	db *sql.DB
+}
+
+// NewSQLOAuthRepository creates a new instance of SQLOAuthRepository
+func NewSQLOAuthRepository(db *sql.DB) *SQLOAuthRepository {
+	return &SQLOAuthRepository{db: db}
+}
+
+// CreateClient registers a client and returns it with its secret; the secret
+// is not stored and cannot be recovered later.
+func (r *SQLOAuthRepository) CreateClient(name, redirectURI string, createdBy int) (*OAuthClient, string, error) {
+	client := &OAuthClient{
+		ID:          randomToken(16),
+		Name:        name,
+		RedirectURI: redirectURI,
+		CreatedBy:   createdBy,
+	}
+	secret := randomToken(32)
+	client.SecretHash = hashToken(secret)
+
+	stmt := "INSERT INTO oauth_clients (id, name, redirect_uri, secret_hash, created_by) VALUES (?, ?, ?, ?, ?)"
+	_, err := r.db.Exec(stmt, client.ID, client.Name, client.RedirectURI, client.SecretHash, client.CreatedBy)
+	if err != nil {
+		return nil, "", err
+	}
+	return client, secret, nil
+}
+
+func (r *SQLOAuthRepository) GetClient(id string) (*OAuthClient, error) {
+	stmt := "SELECT id, name, redirect_uri, secret_hash, created_by, created_at FROM oauth_clients WHERE id = ?"
+	var c OAuthClient
+	err := r.db.QueryRow(stmt, id).Scan(&c.ID, &c.Name, &c.RedirectURI, &c.SecretHash, &c.CreatedBy, &c.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+func (r *SQLOAuthRepository) GetClients() ([]OAuthClient, error) {
+	stmt := "SELECT id, name, redirect_uri, secret_hash, created_by, created_at FROM oauth_clients ORDER BY created_at DESC"
+	rows, err := r.db.Query(stmt)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var clients []OAuthClient
+	for rows.Next() {
+		var c OAuthClient
+		if err := rows.Scan(&c.ID, &c.Name, &c.RedirectURI, &c.SecretHash, &c.CreatedBy, &c.CreatedAt); err != nil {
+			return nil, err
+		}
+		clients = append(clients, c)
+	}
+	return clients, rows.Err()
+}
+
+func (r *SQLOAuthRepository) CreateAuthCode(code OAuthCode) (string, error) {
+	raw := randomToken(32)
+	stmt := `INSERT INTO oauth_codes (code_hash, client_id, user_id, redirect_uri, scope, code_challenge, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`
+	_, err := r.db.Exec(stmt, hashToken(raw), code.ClientID, code.UserID, code.RedirectURI,
+		code.Scope, code.CodeChallenge, code.ExpiresAt.UTC())
+	if err != nil {
+		return "", err
+	}
+	return raw, nil
+}
+
+// RedeemAuthCode consumes an authorization code. A code can be redeemed only
+// once, whether or not the rest of the token request turns out valid.
+func (r *SQLOAuthRepository) RedeemAuthCode(raw string) (*OAuthCode, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	stmt := `SELECT client_id, user_id, redirect_uri, scope, code_challenge, expires_at
+		FROM oauth_codes WHERE code_hash = ?`
+	var code OAuthCode
+	err = tx.QueryRow(stmt, hashToken(raw)).Scan(&code.ClientID, &code.UserID, &code.RedirectURI,
+		&code.Scope, &code.CodeChallenge, &code.ExpiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrInvalidGrant
+	} else if err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec("DELETE FROM oauth_codes WHERE code_hash = ?", hashToken(raw)); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	if time.Now().After(code.ExpiresAt) {
+		return nil, ErrInvalidGrant
+	}
+	return &code, nil
+}
+
+// IssueTokens creates an access and a refresh token for userID. When
+// refreshed is not empty it is the refresh token being exchanged: it is
+// revoked in the same transaction, and if it was already revoked nothing is
+// issued and ErrInvalidGrant is returned, so that a refresh token can be
+// used only once even by concurrent requests.
+func (r *SQLOAuthRepository) IssueTokens(clientID string, userID int, scope, refreshed string) (string, string, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return "", "", err
+	}
+	defer tx.Rollback()
+
+	if refreshed != "" {
+		stmt := "UPDATE oauth_tokens SET revoked_at = CURRENT_TIMESTAMP WHERE token_hash = ? AND revoked_at IS NULL"
+		res, err := tx.Exec(stmt, hashToken(refreshed))
+		if err != nil {
+			return "", "", err
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return "", "", err
+		} else if n == 0 {
+			return "", "", ErrInvalidGrant
+		}
+	}
+	access, err := createToken(tx, clientID, userID, oauthTokenAccess, scope, oauthAccessTokenTTL)
+	if err != nil {
+		return "", "", err
+	}
+	refresh, err := createToken(tx, clientID, userID, oauthTokenRefresh, scope, oauthRefreshTokenTTL)
+	if err != nil {
+		return "", "", err
+	}
+	if err := tx.Commit(); err != nil {
+		return "", "", err
+	}
+	return access, refresh, nil
+}
+
+func createToken(tx *sql.Tx, clientID string, userID int, kind, scope string, ttl time.Duration) (string, error) {
+	raw := randomToken(32)
+	stmt := `INSERT INTO oauth_tokens (token_hash, client_id, user_id, kind, scope, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?)`
+	_, err := tx.Exec(stmt, hashToken(raw), clientID, userID, kind, scope, time.Now().Add(ttl).UTC())
+	if err != nil {
+		return "", err
+	}
+	return raw, nil
+}
+
+// GetToken returns an unexpired, unrevoked token, or ErrInvalidGrant.
+func (r *SQLOAuthRepository) GetToken(raw string) (*OAuthToken, error) {
+	stmt := `SELECT client_id, user_id, kind, scope, expires_at, created_at
+		FROM oauth_tokens WHERE token_hash = ? AND revoked_at IS NULL`
+	var t OAuthToken
+	err := r.db.QueryRow(stmt, hashToken(raw)).Scan(&t.ClientID, &t.UserID, &t.Kind, &t.Scope, &t.ExpiresAt, &t.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrInvalidGrant
+	} else if err != nil {
+		return nil, err
+	}
+	if time.Now().After(t.ExpiresAt) {
+		return nil, ErrInvalidGrant
+	}
+	return &t, nil
+}
+
+func (r *SQLOAuthRepository) RevokeToken(raw string) error {
+	stmt := "UPDATE oauth_tokens SET revoked_at = CURRENT_TIMESTAMP WHERE token_hash = ? AND revoked_at IS NULL"
+	_, err := r.db.Exec(stmt, hashToken(raw))
+	return err
+}
+
+// RevokeUserTokens revokes every token issued on behalf of userID.
+func (r *SQLOAuthRepository) RevokeUserTokens(userID int) error {
+	stmt := "UPDATE oauth_tokens SET revoked_at = CURRENT_TIMESTAMP WHERE user_id = ? AND revoked_at IS NULL"
+	_, err := r.db.Exec(stmt, userID)
+	return err
+}
@@ -0,0 +1,452 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	oauthCodeTTL         = 10 * time.Minute
+	oauthAccessTokenTTL  = time.Hour
+	oauthRefreshTokenTTL = 30 * 24 * time.Hour
+)
+
+const contextScopesKey contextKey = contextKey("oauth_scopes")
+
+// oauthScopes lists the scopes third-party apps can request, with the text
+// shown on the consent screen.
+var oauthScopes = map[string]string{
+	"read":  "See your profile, posts and comments",
+	"write": "Submit posts, comment and vote on your behalf",
+}
+
+// parseScope validates a space separated scope parameter and returns it in
+// canonical form. An empty scope means "read".
+func parseScope(scope string) (string, bool) {
+	fields := strings.Fields(scope)
+	if len(fields) == 0 {
+		return "read", true
+	}
+	for _, s := range fields {
+		if _, ok := oauthScopes[s]; !ok {
+			return "", false
+		}
+	}
+	slices.Sort(fields)
+	return strings.Join(slices.Compact(fields), " "), true
+}
+
+// oauthRedirect sends the user agent back to the client with the given
+// query parameters added to its redirect URI.
+func oauthRedirect(w http.ResponseWriter, r *http.Request, redirectURI string, params url.Values) {
+	u, err := url.Parse(redirectURI)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	q := u.Query()
+	for k, v := range params {
+		q[k] = v
+	}
+	u.RawQuery = q.Encode()
+	http.Redirect(w, r, u.String(), http.StatusFound)
+}
+
+func (app *application) oauthAuthorize(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	if !app.isAuthenticated(r) {
+		http.Redirect(w, r, "/login?redirectTo="+url.QueryEscape(r.URL.RequestURI()), http.StatusSeeOther)
+		return
+	}
+	form := NewForm(r.Form)
+
+	// Until the client and redirect URI are known to be genuine, errors are
+	// shown to the user instead of being redirected to an unverified URI.
+	client, err := app.oauthRepo.GetClient(form.Get("client_id"))
+	if errors.Is(err, sql.ErrNoRows) {
+		form.Errors.Add("generic", "Unknown application")
+		app.render(w, r, "oauth-authorize.html", &templateData{Form: form})
+		return
+	} else if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	if form.Get("redirect_uri") != client.RedirectURI {
+		form.Errors.Add("generic", "The redirect URI does not match the one registered for this application")
+		app.render(w, r, "oauth-authorize.html", &templateData{Form: form})
+		return
+	}
+
+	state := form.Get("state")
+	fail := func(code, description string) {
+		oauthRedirect(w, r, client.RedirectURI, url.Values{
+			"error": {code}, "error_description": {description}, "state": {state},
+		})
+	}
+	if form.Get("response_type") != "code" {
+		fail("unsupported_response_type", "only the authorization code flow is supported")
+		return
+	}
+	if form.Get("code_challenge") == "" || form.Get("code_challenge_method") != "S256" {
+		fail("invalid_request", "PKCE with code_challenge_method=S256 is required")
+		return
+	}
+	scope, ok := parseScope(form.Get("scope"))
+	if !ok {
+		fail("invalid_scope", "unknown scope requested")
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		var descriptions []string
+		for _, s := range strings.Fields(scope) {
+			descriptions = append(descriptions, oauthScopes[s])
+		}
+		form.Set("scope", scope)
+		app.render(w, r, "oauth-authorize.html", &templateData{
+			Form:        form,
+			OAuthClient: client,
+			OAuthScopes: descriptions,
+		})
+		return
+	}
+
+	if form.Get("decision") != "allow" {
+		fail("access_denied", "the user denied the request")
+		return
+	}
+
+	u := app.getUserFromContext(r.Context())
+	code, err := app.oauthRepo.CreateAuthCode(OAuthCode{
+		ClientID:      client.ID,
+		UserID:        u.ID,
+		RedirectURI:   client.RedirectURI,
+		Scope:         scope,
+		CodeChallenge: form.Get("code_challenge"),
+		ExpiresAt:     time.Now().Add(oauthCodeTTL),
+	})
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	app.infoLog.Printf("user %d authorized client %s for %q", u.ID, client.ID, scope)
+	oauthRedirect(w, r, client.RedirectURI, url.Values{"code": {code}, "state": {state}})
+}
+
+// oauthError writes an RFC 6749 section 5.2 error response.
+func (app *application) oauthError(w http.ResponseWriter, status int, code, description string) {
+	if status == http.StatusUnauthorized {
+		w.Header().Set("WWW-Authenticate", `Basic realm="hnews"`)
+	}
+	app.writeJSON(w, status, map[string]string{"error": code, "error_description": description})
+}
+
+// authenticateClient checks client credentials sent either with HTTP Basic
+// authentication or as client_id/client_secret form parameters.
+func (app *application) authenticateClient(r *http.Request) (*OAuthClient, bool) {
+	id, secret, ok := r.BasicAuth()
+	if ok {
+		id, _ = url.QueryUnescape(id)
+		secret, _ = url.QueryUnescape(secret)
+	} else {
+		id, secret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+	client, err := app.oauthRepo.GetClient(id)
+	if err != nil {
+		return nil, false
+	}
+	if subtle.ConstantTimeCompare([]byte(hashToken(secret)), []byte(client.SecretHash)) != 1 {
+		return nil, false
+	}
+	return client, true
+}
+
+func (app *application) oauthToken(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		app.oauthError(w, http.StatusMethodNotAllowed, "invalid_request", "use POST")
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		app.oauthError(w, http.StatusBadRequest, "invalid_request", "malformed body")
+		return
+	}
+	client, ok := app.authenticateClient(r)
+	if !ok {
+		app.oauthError(w, http.StatusUnauthorized, "invalid_client", "client authentication failed")
+		return
+	}
+
+	switch r.PostForm.Get("grant_type") {
+	case "authorization_code":
+		code, err := app.oauthRepo.RedeemAuthCode(r.PostForm.Get("code"))
+		if errors.Is(err, ErrInvalidGrant) {
+			app.oauthError(w, http.StatusBadRequest, "invalid_grant", err.Error())
+			return
+		} else if err != nil {
+			app.serverError(w, err)
+			return
+		}
+		verifier := r.PostForm.Get("code_verifier")
+		if code.ClientID != client.ID || code.RedirectURI != r.PostForm.Get("redirect_uri") ||
+			subtle.ConstantTimeCompare([]byte(pkceChallenge(verifier)), []byte(code.CodeChallenge)) != 1 {
+			app.oauthError(w, http.StatusBadRequest, "invalid_grant", "code was not issued for this request")
+			return
+		}
+		app.issueTokens(w, client.ID, code.UserID, code.Scope, "")
+
+	case "refresh_token":
+		raw := r.PostForm.Get("refresh_token")
+		token, err := app.oauthRepo.GetToken(raw)
+		if errors.Is(err, ErrInvalidGrant) || (err == nil && (token.Kind != oauthTokenRefresh || token.ClientID != client.ID)) {
+			app.oauthError(w, http.StatusBadRequest, "invalid_grant", "invalid refresh token")
+			return
+		} else if err != nil {
+			app.serverError(w, err)
+			return
+		}
+		scope := token.Scope
+		if requested := r.PostForm.Get("scope"); requested != "" {
+			granted := strings.Fields(token.Scope)
+			for _, s := range strings.Fields(requested) {
+				if !slices.Contains(granted, s) {
+					app.oauthError(w, http.StatusBadRequest, "invalid_scope", "scope exceeds the original grant")
+					return
+				}
+			}
+			scope, _ = parseScope(requested)
+		}
+		// Refresh tokens are rotated: the old one stops working once used.
+		app.issueTokens(w, client.ID, token.UserID, scope, raw)
+
+	default:
+		app.oauthError(w, http.StatusBadRequest, "unsupported_grant_type", "supported grants: authorization_code, refresh_token")
+	}
+}
+
+// issueTokens responds with a new token pair. refreshed is the refresh
+// token being exchanged, if any.
+func (app *application) issueTokens(w http.ResponseWriter, clientID string, userID int, scope, refreshed string) {
+	access, refresh, err := app.oauthRepo.IssueTokens(clientID, userID, scope, refreshed)
+	if errors.Is(err, ErrInvalidGrant) {
+		app.oauthError(w, http.StatusBadRequest, "invalid_grant", "invalid refresh token")
+		return
+	} else if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	app.writeJSON(w, http.StatusOK, map[string]any{
+		"access_token":  access,
+		"token_type":    "Bearer",
+		"expires_in":    int(oauthAccessTokenTTL.Seconds()),
+		"refresh_token": refresh,
+		"scope":         scope,
+	})
+}
+
+// oauthIntrospect implements RFC 7662 token introspection for registered
+// clients.
+func (app *application) oauthIntrospect(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		app.oauthError(w, http.StatusMethodNotAllowed, "invalid_request", "use POST")
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		app.oauthError(w, http.StatusBadRequest, "invalid_request", "malformed body")
+		return
+	}
+	client, ok := app.authenticateClient(r)
+	if !ok {
+		app.oauthError(w, http.StatusUnauthorized, "invalid_client", "client authentication failed")
+		return
+	}
+
+	// Clients may only introspect their own tokens; anything else is
+	// reported as inactive, as if it did not exist.
+	token, err := app.oauthRepo.GetToken(r.PostForm.Get("token"))
+	if errors.Is(err, ErrInvalidGrant) || (err == nil && token.ClientID != client.ID) {
+		app.writeJSON(w, http.StatusOK, map[string]any{"active": false})
+		return
+	} else if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	u, err := app.userRepo.GetUserByID(token.UserID)
+	if errors.Is(err, sql.ErrNoRows) {
+		app.writeJSON(w, http.StatusOK, map[string]any{"active": false})
+		return
+	} else if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	tokenType := "access_token"
+	if token.Kind == oauthTokenRefresh {
+		tokenType = "refresh_token"
+	}
+	app.writeJSON(w, http.StatusOK, map[string]any{
+		"active":     true,
+		"scope":      token.Scope,
+		"client_id":  token.ClientID,
+		"username":   u.Email,
+		"sub":        u.ID,
+		"exp":        token.ExpiresAt.Unix(),
+		"iat":        token.CreatedAt.Unix(),
+		"token_type": tokenType,
+	})
+}
+
+// authenticateToken authenticates API requests carrying an OAuth access
+// token in the Authorization header. The token's user and scopes are put in
+// the request context like a logged-in session user.
+func (app *application) authenticateToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := r.Header.Get("Authorization")
+		if header == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		scheme, raw, ok := strings.Cut(header, " ")
+		if !ok || !strings.EqualFold(scheme, "Bearer") {
+			app.bearerError(w, http.StatusUnauthorized, "invalid_request", "expected a Bearer token")
+			return
+		}
+
+		token, err := app.oauthRepo.GetToken(strings.TrimSpace(raw))
+		if errors.Is(err, ErrInvalidGrant) || (err == nil && token.Kind != oauthTokenAccess) {
+			app.bearerError(w, http.StatusUnauthorized, "invalid_token", "the access token is invalid or expired")
+			return
+		} else if err != nil {
+			app.serverError(w, err)
+			return
+		}
+		u, err := app.userRepo.GetUserByID(token.UserID)
+		if errors.Is(err, sql.ErrNoRows) || (err == nil && u.Status != userStatusActive) {
+			app.bearerError(w, http.StatusUnauthorized, "invalid_token", "the access token is invalid or expired")
+			return
+		} else if err != nil {
+			app.serverError(w, err)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), contextAuthKey, true)
+		ctx = context.WithValue(ctx, contextUserKey, u)
+		ctx = context.WithValue(ctx, contextScopesKey, strings.Fields(token.Scope))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// requireScope rejects API requests whose token was not granted scope.
+func (app *application) requireScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !app.isAuthenticated(r) {
+				app.bearerError(w, http.StatusUnauthorized, "invalid_request", "authentication required")
+				return
+			}
+			scopes, _ := r.Context().Value(contextScopesKey).([]string)
+			if !slices.Contains(scopes, scope) {
+				app.bearerError(w, http.StatusForbidden, "insufficient_scope", "this request requires the "+scope+" scope")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// bearerError writes an RFC 6750 error response.
+func (app *application) bearerError(w http.ResponseWriter, status int, code, description string) {
+	w.Header().Set("WWW-Authenticate", `Bearer realm="hnews", error="`+code+`"`)
+	app.writeJSON(w, status, map[string]string{"error": code, "error_description": description})
+}
+
+func (app *application) apiMe(w http.ResponseWriter, r *http.Request) {
+	app.writeJSON(w, http.StatusOK, app.getUserFromContext(r.Context()))
+}
+
+func (app *application) apiPosts(w http.ResponseWriter, r *http.Request) {
+	filter := Filter{
+		Query:    r.URL.Query().Get("q"),
+		OrderBy:  r.URL.Query().Get("order_by"),
+		Page:     app.readIntWithDefault(r, "page", 1),
+		PageSize: app.readIntWithDefault(r, "page_size", 10),
+	}
+	posts, metadata, err := app.postRepo.GetAll(filter)
+	if errors.Is(err, ErrInvalidSearch) || errors.Is(err, ErrInvalidPageSize) {
+		app.writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	} else if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	app.writeJSON(w, http.StatusOK, map[string]any{"posts": posts, "metadata": metadata})
+}
+
+func (app *application) apiVote(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		app.writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "use POST"})
+		return
+	}
+	u := app.getUserFromContext(r.Context())
+	postID := app.readIntWithDefault(r, "post_id", 0)
+	err := app.postRepo.AddVote(u.ID, postID)
+	if errors.Is(err, ErrDuplicateVote) {
+		app.writeJSON(w, http.StatusConflict, map[string]string{"error": err.Error()})
+		return
+	} else if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	app.writeJSON(w, http.StatusCreated, map[string]int{"post_id": postID})
+}
+
+func (app *application) adminOAuthClients(w http.ResponseWriter, r *http.Request) {
+	data := &templateData{Form: NewForm(r.PostForm)}
+
+	if r.Method == http.MethodPost {
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+		form := NewForm(r.PostForm)
+		form.Required("name", "redirect_uri").
+			MaxLength("name", 100).
+			MaxLength("redirect_uri", 255).
+			IsURL("redirect_uri")
+		data.Form = form
+
+		if form.Valid() {
+			u := app.getUserFromContext(r.Context())
+			client, secret, err := app.oauthRepo.CreateClient(form.Get("name"), form.Get("redirect_uri"), u.ID)
+			if err != nil {
+				app.serverError(w, err)
+				return
+			}
+			app.infoLog.Printf("admin %d registered oauth client %s", u.ID, client.ID)
+			data.OAuthClient = client
+			data.OAuthSecret = secret
+			data.Form = NewForm(nil)
+		}
+	}
+
+	clients, err := app.oauthRepo.GetClients()
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	data.OAuthClients = clients
+	app.render(w, r, "admin-oauth-clients.html", data)
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func contextWithUser(ctx context.Context, u *User) context.Context {
+	ctx = context.WithValue(ctx, contextAuthKey, true)
+	return context.WithValue(ctx, contextUserKey, u)
+}
+
+// setupOAuthClient registers a client and a user who will grant it access.
+func setupOAuthClient(t *testing.T) (*OAuthClient, string, *User) {
+	userID, err := testApp.userRepo.CreateUser("oauth", "oauth@test.com", "goodpassword", "avatar")
+	require.NoError(t, err)
+	u, err := testApp.userRepo.GetUserByID(userID)
+	require.NoError(t, err)
+
+	client, secret, err := testApp.oauthRepo.CreateClient("Tool", "https://tool.test/cb", userID)
+	require.NoError(t, err)
+	return client, secret, u
+}
+
+func authorize(t *testing.T, u *User, params url.Values) *httptest.ResponseRecorder {
+	handler := testApp.session.Enable(http.HandlerFunc(testApp.oauthAuthorize))
+	req := httptest.NewRequest(http.MethodPost, "/oauth/authorize", strings.NewReader(params.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req = req.WithContext(contextWithUser(req.Context(), u))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	return w
+}
+
+func postForm(handler http.HandlerFunc, path string, params url.Values, clientID, secret string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(params.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if clientID != "" {
+		req.SetBasicAuth(clientID, secret)
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	return w
+}
+
+func TestOAuth_AuthorizationCodeFlow(t *testing.T) {
+	defer cleanupTestData(t)
+	client, secret, u := setupOAuthClient(t)
+
+	verifier := randomToken(32)
+	w := authorize(t, u, url.Values{
+		"response_type":         {"code"},
+		"client_id":             {client.ID},
+		"redirect_uri":          {client.RedirectURI},
+		"scope":                 {"read"},
+		"state":                 {"xyz"},
+		"code_challenge":        {pkceChallenge(verifier)},
+		"code_challenge_method": {"S256"},
+		"decision":              {"allow"},
+	})
+	require.Equal(t, http.StatusFound, w.Code)
+	location, err := url.Parse(w.Header().Get("Location"))
+	require.NoError(t, err)
+	assert.Equal(t, "tool.test", location.Host)
+	assert.Equal(t, "xyz", location.Query().Get("state"))
+	code := location.Query().Get("code")
+	require.NotEmpty(t, code)
+
+	w = postForm(testApp.oauthToken, "/oauth/token", url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {client.RedirectURI},
+		"code_verifier": {verifier},
+	}, client.ID, secret)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var tokens struct {
+		AccessToken  string `json:"access_token"`
+		RefreshToken string `json:"refresh_token"`
+		Scope        string `json:"scope"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &tokens))
+	assert.Equal(t, "read", tokens.Scope)
+
+	// The code is single use.
+	w = postForm(testApp.oauthToken, "/oauth/token", url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {client.RedirectURI},
+		"code_verifier": {verifier},
+	}, client.ID, secret)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// Introspection reports the access token as active.
+	w = postForm(testApp.oauthIntrospect, "/oauth/introspect", url.Values{"token": {tokens.AccessToken}}, client.ID, secret)
+	require.Equal(t, http.StatusOK, w.Code)
+	var info map[string]any
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &info))
+	assert.Equal(t, true, info["active"])
+	assert.Equal(t, "oauth@test.com", info["username"])
+
+	// Other clients cannot introspect it.
+	other, otherSecret, err := testApp.oauthRepo.CreateClient("Other", "https://other.test/cb", u.ID)
+	require.NoError(t, err)
+	w = postForm(testApp.oauthIntrospect, "/oauth/introspect", url.Values{"token": {tokens.AccessToken}}, other.ID, otherSecret)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"active": false}`, w.Body.String())
+
+	// The API middleware populates the context user from the token.
+	api := testApp.authenticateToken(testApp.requireScope("read")(http.HandlerFunc(testApp.apiMe)))
+	req := httptest.NewRequest(http.MethodGet, "/api/me", nil)
+	req.Header.Set("Authorization", "Bearer "+tokens.AccessToken)
+	w = httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"email":"oauth@test.com"`)
+
+	// A read token cannot be used for write endpoints.
+	write := testApp.authenticateToken(testApp.requireScope("write")(http.HandlerFunc(testApp.apiVote)))
+	req = httptest.NewRequest(http.MethodPost, "/api/vote?post_id=1", nil)
+	req.Header.Set("Authorization", "Bearer "+tokens.AccessToken)
+	w = httptest.NewRecorder()
+	write.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	// Refreshing rotates the refresh token.
+	w = postForm(testApp.oauthToken, "/oauth/token", url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {tokens.RefreshToken},
+	}, client.ID, secret)
+	require.Equal(t, http.StatusOK, w.Code)
+	w = postForm(testApp.oauthToken, "/oauth/token", url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {tokens.RefreshToken},
+	}, client.ID, secret)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestOAuthRepository_IssueTokens_RefreshOnce(t *testing.T) {
+	defer cleanupTestData(t)
+	client, _, u := setupOAuthClient(t)
+
+	_, refresh, err := testApp.oauthRepo.IssueTokens(client.ID, u.ID, "read", "")
+	require.NoError(t, err)
+	access, _, err := testApp.oauthRepo.IssueTokens(client.ID, u.ID, "read", refresh)
+	require.NoError(t, err)
+	_, err = testApp.oauthRepo.GetToken(access)
+	require.NoError(t, err)
+
+	// A second exchange of the same refresh token issues nothing.
+	_, _, err = testApp.oauthRepo.IssueTokens(client.ID, u.ID, "read", refresh)
+	assert.ErrorIs(t, err, ErrInvalidGrant)
+	_, err = testApp.oauthRepo.GetToken(refresh)
+	assert.ErrorIs(t, err, ErrInvalidGrant)
+}
+
+func TestOAuth_Token_RejectsWrongVerifier(t *testing.T) {
+	defer cleanupTestData(t)
+	client, secret, u := setupOAuthClient(t)
+
+	w := authorize(t, u, url.Values{
+		"response_type":         {"code"},
+		"client_id":             {client.ID},
+		"redirect_uri":          {client.RedirectURI},
+		"code_challenge":        {pkceChallenge("right")},
+		"code_challenge_method": {"S256"},
+		"decision":              {"allow"},
+	})
+	require.Equal(t, http.StatusFound, w.Code)
+	location, _ := url.Parse(w.Header().Get("Location"))
+
+	w = postForm(testApp.oauthToken, "/oauth/token", url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {location.Query().Get("code")},
+		"redirect_uri":  {client.RedirectURI},
+		"code_verifier": {"wrong"},
+	}, client.ID, secret)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "invalid_grant")
+
+	w = postForm(testApp.oauthToken, "/oauth/token", url.Values{"grant_type": {"authorization_code"}}, client.ID, "bad-secret")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestOAuth_Authorize_ValidatesRequest(t *testing.T) {
+	defer cleanupTestData(t)
+	client, _, u := setupOAuthClient(t)
+
+	// An unregistered redirect URI is never redirected to.
+	w := authorize(t, u, url.Values{
+		"response_type": {"code"},
+		"client_id":     {client.ID},
+		"redirect_uri":  {"https://evil.test/cb"},
+	})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "redirect URI does not match")
+
+	// Denying consent returns access_denied to the client.
+	w = authorize(t, u, url.Values{
+		"response_type":         {"code"},
+		"client_id":             {client.ID},
+		"redirect_uri":          {client.RedirectURI},
+		"code_challenge":        {pkceChallenge("v")},
+		"code_challenge_method": {"S256"},
+		"decision":              {"deny"},
+	})
+	require.Equal(t, http.StatusFound, w.Code)
+	assert.Contains(t, w.Header().Get("Location"), "error=access_denied")
+}
+
+func TestParseScope(t *testing.T) {
+	scope, ok := parseScope("write read read")
+	assert.True(t, ok)
+	assert.Equal(t, "read write", scope)
+
+	scope, ok = parseScope("")
+	assert.True(t, ok)
+	assert.Equal(t, "read", scope)
+
+	_, ok = parseScope("admin")
+	assert.False(t, ok)
+}
+
+func TestAPIPosts_RejectsInvalidFilters(t *testing.T) {
+	defer cleanupTestData(t)
+	u := createTestUser(t, "API Reader", "reader@test.com")
+
+	w := serveAs(testApp.apiPosts, u, http.MethodGet, "/api/posts?q=site:", nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = serveAs(testApp.apiPosts, u, http.MethodGet, "/api/posts?page_size=500", nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = serveAs(testApp.apiPosts, u, http.MethodGet, "/api/posts?q=hello", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
@@ -0,0 +1,427 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	oidcStateKey    = "oidc_state"
+	oidcNonceKey    = "oidc_nonce"
+	oidcVerifierKey = "oidc_verifier"
+
+	// oidcClockSkew is how far the provider's clock may drift from ours when
+	// checking the exp and iat claims.
+	oidcClockSkew = time.Minute
+)
+
+var (
+	ErrInvalidIDToken   = errors.New("invalid id token")
+	ErrUnverifiedEmail  = errors.New("identity provider did not return a verified email")
+	ErrOIDCTokenRequest = errors.New("token request failed")
+)
+
+// OIDCConfig holds the client registration at an OpenID Connect provider.
+type OIDCConfig struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+}
+
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// IDTokenClaims are the ID token claims hnews relies on.
+type IDTokenClaims struct {
+	Issuer        string   `json:"iss"`
+	Subject       string   `json:"sub"`
+	Audience      audience `json:"aud"`
+	Expiry        int64    `json:"exp"`
+	IssuedAt      int64    `json:"iat"`
+	Nonce         string   `json:"nonce"`
+	Email         string   `json:"email"`
+	EmailVerified bool     `json:"email_verified"`
+	Name          string   `json:"name"`
+}
+
+// audience accepts the aud claim both as a single string and as an array.
+type audience []string
+
+func (a *audience) UnmarshalJSON(b []byte) error {
+	var single string
+	if err := json.Unmarshal(b, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(b, &many); err != nil {
+		return err
+	}
+	*a = many
+	return nil
+}
+
+func (a audience) contains(v string) bool {
+	for _, s := range a {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+// OIDCProvider is an OpenID Connect relying party for a single issuer. It
+// implements the authorization code flow with PKCE and validates RS256
+// signed ID tokens against the provider's published JWKS.
+type OIDCProvider struct {
+	config   OIDCConfig
+	client   *http.Client
+	metadata oidcDiscovery
+
+	mutex sync.RWMutex
+	keys  map[string]*rsa.PublicKey
+}
+
+// NewOIDCProvider fetches the issuer's discovery document and returns a
+// provider ready to build authorization URLs.
+func NewOIDCProvider(ctx context.Context, config OIDCConfig, client *http.Client) (*OIDCProvider, error) {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	p := &OIDCProvider{
+		config: config,
+		client: client,
+		keys:   make(map[string]*rsa.PublicKey),
+	}
+
+	discoveryURL := strings.TrimSuffix(config.Issuer, "/") + "/.well-known/openid-configuration"
+	if err := p.getJSON(ctx, discoveryURL, &p.metadata); err != nil {
+		return nil, fmt.Errorf("oidc discovery: %w", err)
+	}
+	if p.metadata.Issuer != config.Issuer {
+		return nil, fmt.Errorf("oidc discovery: issuer mismatch %q != %q", p.metadata.Issuer, config.Issuer)
+	}
+	if p.metadata.AuthorizationEndpoint == "" || p.metadata.TokenEndpoint == "" || p.metadata.JWKSURI == "" {
+		return nil, errors.New("oidc discovery: incomplete provider metadata")
+	}
+	return p, nil
+}
+
+// AuthCodeURL returns the URL the browser is sent to for authentication.
+func (p *OIDCProvider) AuthCodeURL(state, nonce, verifier string) string {
+	v := url.Values{}
+	v.Set("response_type", "code")
+	v.Set("client_id", p.config.ClientID)
+	v.Set("redirect_uri", p.config.RedirectURL)
+	v.Set("scope", "openid email profile")
+	v.Set("state", state)
+	v.Set("nonce", nonce)
+	v.Set("code_challenge", pkceChallenge(verifier))
+	v.Set("code_challenge_method", "S256")
+
+	sep := "?"
+	if strings.Contains(p.metadata.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return p.metadata.AuthorizationEndpoint + sep + v.Encode()
+}
+
+// Exchange redeems an authorization code and returns the validated claims
+// of the ID token issued with it.
+func (p *OIDCProvider) Exchange(ctx context.Context, code, verifier, nonce string) (*IDTokenClaims, error) {
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.config.RedirectURL)
+	form.Set("client_id", p.config.ClientID)
+	form.Set("code_verifier", verifier)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.metadata.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.config.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.config.ClientID), url.QueryEscape(p.config.ClientSecret))
+	}
+
+	res, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	var body struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrOIDCTokenRequest, err)
+	}
+	if res.StatusCode != http.StatusOK || body.IDToken == "" {
+		return nil, fmt.Errorf("%w: %d %s %s", ErrOIDCTokenRequest, res.StatusCode, body.Error, body.ErrorDescription)
+	}
+	return p.VerifyIDToken(ctx, body.IDToken, nonce)
+}
+
+// VerifyIDToken checks the signature, issuer, audience, lifetime and nonce
+// of a compact-serialized ID token.
+func (p *OIDCProvider) VerifyIDToken(ctx context.Context, rawToken, nonce string) (*IDTokenClaims, error) {
+	parts := strings.Split(rawToken, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: malformed token", ErrInvalidIDToken)
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidIDToken, err)
+	}
+	if header.Alg != "RS256" {
+		return nil, fmt.Errorf("%w: unsupported alg %q", ErrInvalidIDToken, header.Alg)
+	}
+
+	key, err := p.publicKey(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidIDToken, err)
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sig); err != nil {
+		return nil, fmt.Errorf("%w: bad signature", ErrInvalidIDToken)
+	}
+
+	var claims IDTokenClaims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidIDToken, err)
+	}
+
+	now := time.Now()
+	switch {
+	case claims.Issuer != p.metadata.Issuer:
+		return nil, fmt.Errorf("%w: unexpected issuer %q", ErrInvalidIDToken, claims.Issuer)
+	case !claims.Audience.contains(p.config.ClientID):
+		return nil, fmt.Errorf("%w: token not issued for this client", ErrInvalidIDToken)
+	case claims.Expiry == 0 || now.After(time.Unix(claims.Expiry, 0).Add(oidcClockSkew)):
+		return nil, fmt.Errorf("%w: token expired", ErrInvalidIDToken)
+	case claims.IssuedAt != 0 && time.Unix(claims.IssuedAt, 0).After(now.Add(oidcClockSkew)):
+		return nil, fmt.Errorf("%w: token issued in the future", ErrInvalidIDToken)
+	case subtle.ConstantTimeCompare([]byte(claims.Nonce), []byte(nonce)) != 1:
+		return nil, fmt.Errorf("%w: nonce mismatch", ErrInvalidIDToken)
+	case claims.Subject == "":
+		return nil, fmt.Errorf("%w: missing subject", ErrInvalidIDToken)
+	}
+	return &claims, nil
+}
+
+// publicKey looks up a signing key by id, refetching the JWKS once when the
+// id is unknown so that provider key rotation is picked up.
+func (p *OIDCProvider) publicKey(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	p.mutex.RLock()
+	key, ok := p.keys[kid]
+	p.mutex.RUnlock()
+	if ok {
+		return key, nil
+	}
+
+	var jwks struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := p.getJSON(ctx, p.metadata.JWKSURI, &jwks); err != nil {
+		return nil, fmt.Errorf("oidc jwks: %w", err)
+	}
+
+	keys := make(map[string]*rsa.PublicKey)
+	for _, k := range jwks.Keys {
+		if k.Kty != "RSA" {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			continue
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			continue
+		}
+		keys[k.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+
+	p.mutex.Lock()
+	p.keys = keys
+	p.mutex.Unlock()
+
+	key, ok = keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown signing key %q", ErrInvalidIDToken, kid)
+	}
+	return key, nil
+}
+
+func (p *OIDCProvider) getJSON(ctx context.Context, url string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	res, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", url, res.Status)
+	}
+	return json.NewDecoder(res.Body).Decode(v)
+}
+
+func decodeSegment(seg string, v any) error {
+	b, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// pkceChallenge derives the S256 code challenge for a PKCE verifier.
+func pkceChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func (app *application) oidcLogin(w http.ResponseWriter, r *http.Request) {
+	if app.isAuthenticated(r) {
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+	state := randomToken(24)
+	nonce := randomToken(24)
+	verifier := randomToken(32)
+	app.session.Put(r, oidcStateKey, state)
+	app.session.Put(r, oidcNonceKey, nonce)
+	app.session.Put(r, oidcVerifierKey, verifier)
+
+	http.Redirect(w, r, app.oidc.AuthCodeURL(state, nonce, verifier), http.StatusFound)
+}
+
+func (app *application) oidcCallback(w http.ResponseWriter, r *http.Request) {
+	state := app.session.PopString(r, oidcStateKey)
+	nonce := app.session.PopString(r, oidcNonceKey)
+	verifier := app.session.PopString(r, oidcVerifierKey)
+
+	q := r.URL.Query()
+	if e := q.Get("error"); e != "" {
+		app.errorLog.Printf("oidc provider returned error: %s %s", e, q.Get("error_description"))
+		app.session.Put(r, "flash", "single sign-on was cancelled or failed")
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	if state == "" || subtle.ConstantTimeCompare([]byte(state), []byte(q.Get("state"))) != 1 {
+		app.session.Put(r, "flash", "single sign-on session expired, please try again")
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	claims, err := app.oidc.Exchange(r.Context(), q.Get("code"), verifier, nonce)
+	if err != nil {
+		app.errorLog.Printf("oidc exchange: %s", err)
+		app.session.Put(r, "flash", "single sign-on failed")
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	user, err := app.oidcUser(claims)
+	if err == nil {
+		err = app.config.registration.CheckLogin(user)
+	}
+	if errors.Is(err, ErrUnverifiedEmail) || errors.Is(err, ErrRegistrationClosed) ||
+		errors.Is(err, ErrEmailDomainRejected) || errors.Is(err, ErrPendingApproval) {
+		app.session.Put(r, "flash", err.Error())
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	} else if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.recordLogin(w, r, user.Email, user, true)
+	app.startSession(r, user)
+	app.session.Put(r, "flash", "You are logged In")
+	app.infoLog.Printf("Logged in with email %s via %s", user.Email, claims.Issuer)
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// oidcUser resolves the local account for an authenticated identity: an
+// existing link wins, otherwise the identity is linked to the account with
+// the same verified email, or a new account is created just in time.
+func (app *application) oidcUser(claims *IDTokenClaims) (*User, error) {
+	user, err := app.userRepo.GetUserByIdentity(claims.Issuer, claims.Subject)
+	if err == nil {
+		return user, nil
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	if claims.Email == "" || !claims.EmailVerified {
+		return nil, ErrUnverifiedEmail
+	}
+
+	user, err = app.userRepo.GetUserByEmail(claims.Email)
+	if errors.Is(err, sql.ErrNoRows) {
+		if err := app.config.registration.CheckSignup(claims.Email); err != nil {
+			return nil, err
+		}
+		name := claims.Name
+		if name == "" {
+			name, _, _ = strings.Cut(claims.Email, "@")
+		}
+		// The account gets a random password nobody knows; it can only be
+		// used through the identity provider.
+		if _, err := app.userRepo.CreateUser(name, claims.Email, randomToken(32), ""); err != nil {
+			return nil, err
+		}
+		user, err = app.userRepo.GetUserByEmail(claims.Email)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := app.userRepo.LinkIdentity(user.ID, claims.Issuer, claims.Subject); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
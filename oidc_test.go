@@ -0,0 +1,264 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// stubOIDCProvider is a minimal OpenID Connect provider: it authorizes every
+// request immediately and signs ID tokens for the configured identity.
+type stubOIDCProvider struct {
+	*httptest.Server
+	key      *rsa.PrivateKey
+	clientID string
+
+	mutex    sync.Mutex
+	identity map[string]any
+	codes    map[string]stubAuthRequest
+}
+
+type stubAuthRequest struct {
+	nonce     string
+	challenge string
+}
+
+func newStubOIDCProvider(t *testing.T, clientID string) *stubOIDCProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	p := &stubOIDCProvider{key: key, clientID: clientID, codes: map[string]stubAuthRequest{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 p.URL,
+			"authorization_endpoint": p.URL + "/authorize",
+			"token_endpoint":         p.URL + "/token",
+			"jwks_uri":               p.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "test-key",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/authorize", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		code := randomToken(8)
+		p.mutex.Lock()
+		p.codes[code] = stubAuthRequest{nonce: q.Get("nonce"), challenge: q.Get("code_challenge")}
+		p.mutex.Unlock()
+		http.Redirect(w, r, q.Get("redirect_uri")+"?code="+code+"&state="+url.QueryEscape(q.Get("state")), http.StatusFound)
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		p.mutex.Lock()
+		req, ok := p.codes[r.PostForm.Get("code")]
+		delete(p.codes, r.PostForm.Get("code"))
+		p.mutex.Unlock()
+		if !ok || pkceChallenge(r.PostForm.Get("code_verifier")) != req.challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{
+			"access_token": "stub",
+			"token_type":   "Bearer",
+			"id_token":     p.sign(t, req.nonce, nil),
+		})
+	})
+	p.Server = httptest.NewServer(mux)
+	t.Cleanup(p.Close)
+	return p
+}
+
+// sign issues an ID token for the current identity, with overrides applied
+// on top of the standard claims.
+func (p *stubOIDCProvider) sign(t *testing.T, nonce string, overrides map[string]any) string {
+	claims := map[string]any{
+		"iss":   p.URL,
+		"aud":   p.clientID,
+		"iat":   time.Now().Unix(),
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"nonce": nonce,
+	}
+	p.mutex.Lock()
+	for k, v := range p.identity {
+		claims[k] = v
+	}
+	p.mutex.Unlock()
+	for k, v := range overrides {
+		claims[k] = v
+	}
+
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "test-key", "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signingInput))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, p.key, crypto.SHA256, digest[:])
+	require.NoError(t, err)
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func (p *stubOIDCProvider) setIdentity(claims map[string]any) {
+	p.mutex.Lock()
+	p.identity = claims
+	p.mutex.Unlock()
+}
+
+func setupOIDC(t *testing.T) *stubOIDCProvider {
+	stub := newStubOIDCProvider(t, "hnews")
+	provider, err := NewOIDCProvider(context.Background(), OIDCConfig{
+		Issuer:      stub.URL,
+		ClientID:    "hnews",
+		RedirectURL: "http://hnews.test/auth/oidc/callback",
+	}, stub.Client())
+	require.NoError(t, err)
+
+	testApp.oidc = provider
+	t.Cleanup(func() { testApp.oidc = nil })
+	return stub
+}
+
+// oidcRoundTrip drives the browser side of a login: start at the app, follow
+// the provider's authorization redirect and deliver the callback.
+func oidcRoundTrip(t *testing.T, stub *stubOIDCProvider) *httptest.ResponseRecorder {
+	start := testApp.session.Enable(testApp.authenticate(http.HandlerFunc(testApp.oidcLogin)))
+	w1 := httptest.NewRecorder()
+	start.ServeHTTP(w1, httptest.NewRequest(http.MethodGet, "/auth/oidc/login", nil))
+	require.Equal(t, http.StatusFound, w1.Code)
+
+	authURL := w1.Header().Get("Location")
+	require.True(t, strings.HasPrefix(authURL, stub.URL+"/authorize?"))
+
+	client := stub.Client()
+	client.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+	res, err := client.Get(authURL)
+	require.NoError(t, err)
+	res.Body.Close()
+	callback := res.Header.Get("Location")
+
+	finish := testApp.session.Enable(testApp.authenticate(http.HandlerFunc(testApp.oidcCallback)))
+	req := httptest.NewRequest(http.MethodGet, callback, nil)
+	for _, cookie := range w1.Result().Cookies() {
+		req.AddCookie(cookie)
+	}
+	w2 := httptest.NewRecorder()
+	finish.ServeHTTP(w2, req)
+	return w2
+}
+
+func TestOIDC_AuthCodeURL_UsesPKCE(t *testing.T) {
+	setupOIDC(t)
+
+	u, err := url.Parse(testApp.oidc.AuthCodeURL("state", "nonce", "verifier"))
+	require.NoError(t, err)
+	q := u.Query()
+	assert.Equal(t, "code", q.Get("response_type"))
+	assert.Equal(t, "S256", q.Get("code_challenge_method"))
+	assert.Equal(t, pkceChallenge("verifier"), q.Get("code_challenge"))
+	assert.Equal(t, "nonce", q.Get("nonce"))
+}
+
+func TestOIDC_Login_CreatesUser(t *testing.T) {
+	defer cleanupTestData(t)
+	stub := setupOIDC(t)
+	stub.setIdentity(map[string]any{"sub": "abc123", "email": "sso@test.com", "email_verified": true, "name": "SSO User"})
+
+	w := oidcRoundTrip(t, stub)
+	assert.Equal(t, http.StatusSeeOther, w.Code)
+	assert.Equal(t, "/", w.Header().Get("Location"))
+
+	user, err := testApp.userRepo.GetUserByIdentity(stub.URL, "abc123")
+	require.NoError(t, err)
+	assert.Equal(t, "sso@test.com", user.Email)
+	assert.Equal(t, "SSO User", user.Name)
+}
+
+func TestOIDC_Login_LinksExistingUserByVerifiedEmail(t *testing.T) {
+	defer cleanupTestData(t)
+	stub := setupOIDC(t)
+
+	userID, err := testApp.userRepo.CreateUser("local", "local@test.com", "goodpassword", "avatar")
+	require.NoError(t, err)
+	stub.setIdentity(map[string]any{"sub": "xyz", "email": "local@test.com", "email_verified": true})
+
+	w := oidcRoundTrip(t, stub)
+	assert.Equal(t, http.StatusSeeOther, w.Code)
+	assert.Equal(t, "/", w.Header().Get("Location"))
+
+	user, err := testApp.userRepo.GetUserByIdentity(stub.URL, "xyz")
+	require.NoError(t, err)
+	assert.Equal(t, userID, user.ID)
+}
+
+func TestOIDC_Login_RejectsUnverifiedEmail(t *testing.T) {
+	defer cleanupTestData(t)
+	stub := setupOIDC(t)
+
+	_, err := testApp.userRepo.CreateUser("local", "local@test.com", "goodpassword", "avatar")
+	require.NoError(t, err)
+	stub.setIdentity(map[string]any{"sub": "xyz", "email": "local@test.com", "email_verified": false})
+
+	w := oidcRoundTrip(t, stub)
+	assert.Equal(t, http.StatusSeeOther, w.Code)
+	assert.Equal(t, "/login", w.Header().Get("Location"))
+
+	_, err = testApp.userRepo.GetUserByIdentity(stub.URL, "xyz")
+	assert.Error(t, err)
+}
+
+func TestOIDC_Callback_RejectsStateMismatch(t *testing.T) {
+	setupOIDC(t)
+
+	handler := testApp.session.Enable(testApp.authenticate(http.HandlerFunc(testApp.oidcCallback)))
+	req := httptest.NewRequest(http.MethodGet, "/auth/oidc/callback?code=x&state=forged", nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusSeeOther, w.Code)
+	assert.Equal(t, "/login", w.Header().Get("Location"))
+}
+
+func TestOIDC_VerifyIDToken(t *testing.T) {
+	stub := setupOIDC(t)
+	stub.setIdentity(map[string]any{"sub": "abc123"})
+	ctx := context.Background()
+
+	_, err := testApp.oidc.VerifyIDToken(ctx, stub.sign(t, "n", nil), "n")
+	assert.NoError(t, err)
+
+	tests := map[string]string{
+		"wrong audience": stub.sign(t, "n", map[string]any{"aud": "someone-else"}),
+		"wrong issuer":   stub.sign(t, "n", map[string]any{"iss": "https://evil.test"}),
+		"expired":        stub.sign(t, "n", map[string]any{"exp": time.Now().Add(-time.Hour).Unix()}),
+		"wrong nonce":    stub.sign(t, "other", nil),
+		"tampered":       stub.sign(t, "n", nil) + "x",
+	}
+	for name, token := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := testApp.oidc.VerifyIDToken(ctx, token, "n")
+			assert.ErrorIs(t, err, ErrInvalidIDToken)
+		})
+	}
+}
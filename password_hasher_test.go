@@ -0,0 +1,78 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestArgon2idHasher_HashAndVerify(t *testing.T) {
+	h := NewArgon2idHasher()
+
+	encoded, err := h.Hash("correct horse")
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(encoded, "$argon2id$v=19$m=65536,t=3,p=4$"))
+
+	ok, rehash, err := h.Verify("correct horse", encoded)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.False(t, rehash)
+
+	ok, _, err = h.Verify("wrong horse", encoded)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	other, err := h.Hash("correct horse")
+	assert.NoError(t, err)
+	assert.NotEqual(t, encoded, other, "hashes must be salted")
+}
+
+func TestArgon2idHasher_OutdatedParametersNeedRehash(t *testing.T) {
+	weak := &Argon2idHasher{Memory: 8 * 1024, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+	encoded, err := weak.Hash("secret")
+	assert.NoError(t, err)
+
+	ok, rehash, err := NewArgon2idHasher().Verify("secret", encoded)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.True(t, rehash)
+}
+
+func TestArgon2idHasher_VerifiesLegacyBcrypt(t *testing.T) {
+	legacy, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	assert.NoError(t, err)
+
+	h := NewArgon2idHasher()
+	ok, rehash, err := h.Verify("secret", string(legacy))
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.True(t, rehash)
+
+	ok, _, err = h.Verify("nope", string(legacy))
+	assert.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestArgon2idHasher_RejectsUnknownFormat(t *testing.T) {
+	_, _, err := NewArgon2idHasher().Verify("secret", "plaintext")
+	assert.ErrorIs(t, err, ErrUnknownHashFormat)
+
+	_, _, err = NewArgon2idHasher().Verify("secret", "$argon2id$v=19$m=x$salt$key")
+	assert.ErrorIs(t, err, ErrUnknownHashFormat)
+}
+
+func TestArgon2idHasher_RejectsOutOfRangeParameters(t *testing.T) {
+	key := "c2FsdHNhbHRzYWx0c2FsdA$a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2U"
+	for _, params := range []string{
+		"m=65536,t=0,p=4",
+		"m=65536,t=3,p=0",
+		"m=65536,t=1000000,p=4",
+		"m=4294967295,t=3,p=4",
+		"m=16,t=3,p=4",
+	} {
+		_, _, err := NewArgon2idHasher().Verify("secret", "$argon2id$v=19$"+params+"$"+key)
+		assert.ErrorIs(t, err, ErrUnknownHashFormat, params)
+	}
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// commonPasswords are rejected outright regardless of their computed entropy.
+var commonPasswords = map[string]bool{
+	"password": true, "password1": true, "123456": true, "12345678": true, "123456789": true,
+	"1234567890": true, "qwerty": true, "qwertyuiop": true, "abc123": true, "letmein": true,
+	"welcome": true, "monkey": true, "dragon": true, "iloveyou": true, "admin": true,
+	"football": true, "baseball": true, "sunshine": true, "princess": true, "trustno1": true,
+	"passw0rd": true, "master": true, "superman": true, "starwars": true, "hackernews": true,
+}
+
+// keyboardRows are checked for runs of adjacent keys such as "qwer" or "asdf".
+var keyboardRows = []string{"1234567890", "qwertyuiop", "asdfghjkl", "zxcvbnm"}
+
+// PasswordStrength is the result of EstimateStrength. Score ranges from 0
+// (trivially guessable) to 4 (very strong).
+type PasswordStrength struct {
+	Score    int
+	Entropy  float64
+	Feedback []string
+}
+
+// EstimateStrength approximates the guessing entropy of a password from its
+// character classes, discounting characters that repeat or continue a
+// sequence, and suggests how to improve it.
+func EstimateStrength(password string) PasswordStrength {
+	var lower, upper, digit, symbol, other bool
+	for _, c := range password {
+		switch {
+		case c >= 'a' && c <= 'z':
+			lower = true
+		case c >= 'A' && c <= 'Z':
+			upper = true
+		case c >= '0' && c <= '9':
+			digit = true
+		case c < utf8.RuneSelf && unicode.IsPrint(c):
+			symbol = true
+		default:
+			other = true
+		}
+	}
+	pool := 0
+	for _, set := range []struct {
+		present bool
+		size    int
+	}{{lower, 26}, {upper, 26}, {digit, 10}, {symbol, 33}, {other, 100}} {
+		if set.present {
+			pool += set.size
+		}
+	}
+	if pool == 0 {
+		return PasswordStrength{Feedback: []string{"Use a longer password"}}
+	}
+
+	var feedback []string
+	bitsPerChar := math.Log2(float64(pool))
+	lowered := strings.ToLower(password)
+	runes := []rune(lowered)
+	entropy := 0.0
+	repeats, sequences := 0, 0
+	for i, c := range runes {
+		switch {
+		case i > 0 && c == runes[i-1]:
+			repeats++
+			entropy += 1
+		case i > 0 && (c == runes[i-1]+1 || c == runes[i-1]-1 || adjacentKeys(runes[i-1], c)):
+			sequences++
+			entropy += 1
+		default:
+			entropy += bitsPerChar
+		}
+	}
+
+	if commonPasswords[lowered] {
+		entropy = math.Min(entropy, 10)
+		feedback = append(feedback, "This is one of the most common passwords")
+	}
+	if repeats > 1 {
+		feedback = append(feedback, "Avoid repeated characters like aaa")
+	}
+	if sequences > 1 {
+		feedback = append(feedback, "Avoid sequences like abc, 123 or qwerty")
+	}
+	classes := 0
+	for _, present := range []bool{lower, upper, digit, symbol || other} {
+		if present {
+			classes++
+		}
+	}
+	if classes < 3 && len(runes) < 16 {
+		feedback = append(feedback, "Mix upper and lower case letters, digits and symbols, or use a longer passphrase")
+	}
+	if len(runes) < 12 {
+		feedback = append(feedback, "Add another word or two; longer passwords are harder to guess")
+	}
+
+	score := 0
+	for _, threshold := range []float64{28, 36, 60, 80} {
+		if entropy >= threshold {
+			score++
+		}
+	}
+	return PasswordStrength{Score: score, Entropy: entropy, Feedback: feedback}
+}
+
+func adjacentKeys(a, b rune) bool {
+	for _, row := range keyboardRows {
+		i := strings.IndexRune(row, a)
+		if i >= 0 && i+1 < len(row) && rune(row[i+1]) == b {
+			return true
+		}
+	}
+	return false
+}
+
+// PasswordPolicy decides which passwords users may choose.
+type PasswordPolicy struct {
+	MinLength int
+	MinScore  int
+	Breached  *BreachedPasswords // optional
+}
+
+// Check returns the reasons password is not acceptable, if any. personal
+// holds values the password must not contain, such as the user's name and
+// email address.
+func (p *PasswordPolicy) Check(password string, personal ...string) ([]string, error) {
+	var problems []string
+	if utf8.RuneCountInString(password) < p.MinLength {
+		problems = append(problems, fmt.Sprintf("Use at least %d characters", p.MinLength))
+	}
+
+	if containsPersonal(password, personal) {
+		problems = append(problems, "Do not include your name or email address in your password")
+	}
+
+	strength := EstimateStrength(password)
+	if strength.Score < p.MinScore {
+		problems = append(problems, fmt.Sprintf("This password is too weak (strength %d of 4)", strength.Score))
+		problems = append(problems, strength.Feedback...)
+	}
+
+	if p.Breached != nil {
+		breached, err := p.Breached.Contains(password)
+		if err != nil {
+			return nil, err
+		}
+		if breached {
+			problems = append(problems, "This password has appeared in a data breach; choose a different one")
+		}
+	}
+	return problems, nil
+}
+
+func containsPersonal(password string, personal []string) bool {
+	lowered := strings.ToLower(password)
+	for _, value := range personal {
+		for _, part := range personalParts(value) {
+			if strings.Contains(lowered, part) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// personalParts splits a name or email into the lower-cased fragments that
+// are long enough to be meaningful inside a password.
+func personalParts(value string) []string {
+	value = strings.ToLower(value)
+	local, domain, isEmail := strings.Cut(value, "@")
+	fields := strings.FieldsFunc(local, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	if isEmail {
+		fields = append(fields, local)
+		if host, _, _ := strings.Cut(domain, "."); host != "" {
+			fields = append(fields, host)
+		}
+	}
+	var parts []string
+	for _, f := range fields {
+		if utf8.RuneCountInString(f) >= 3 {
+			parts = append(parts, f)
+		}
+	}
+	return parts
+}
+
+// BreachedPasswords looks up passwords in a local copy of the Have I Been
+// Pwned password list: one upper-case SHA-1 hex digest per line, optionally
+// followed by ":count", sorted by hash. The file is searched in place with
+// a binary search over byte offsets, so it never has to fit in memory.
+type BreachedPasswords struct {
+	file *os.File
+	size int64
+}
+
+func OpenBreachedPasswords(path string) (*BreachedPasswords, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &BreachedPasswords{file: f, size: info.Size()}, nil
+}
+
+func (b *BreachedPasswords) Close() error {
+	return b.file.Close()
+}
+
+// Contains reports whether the SHA-1 of password is in the list.
+func (b *BreachedPasswords) Contains(password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	target := strings.ToUpper(hex.EncodeToString(sum[:]))
+
+	// Find the smallest offset whose following line sorts at or after the
+	// target; that line is the only place the hash can be.
+	lo, hi := int64(0), b.size
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		hash, err := b.hashAt(mid)
+		if err != nil && err != io.EOF {
+			return false, err
+		}
+		if err == io.EOF || hash >= target {
+			hi = mid
+		} else {
+			lo = mid + 1
+		}
+	}
+	hash, err := b.hashAt(lo)
+	if err == io.EOF {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return hash == target, nil
+}
+
+// hashAt returns the hash on the first line that starts at or after offset.
+func (b *BreachedPasswords) hashAt(offset int64) (string, error) {
+	start := offset
+	if start > 0 {
+		start-- // the line may start exactly at offset if offset-1 is '\n'
+	}
+	r := bufio.NewReaderSize(io.NewSectionReader(b.file, start, b.size-start), 256)
+	if offset > 0 {
+		if _, err := r.ReadString('\n'); err != nil {
+			return "", io.EOF
+		}
+	}
+	line, err := r.ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		return "", err
+	}
+	hash, _, _ := strings.Cut(strings.TrimSpace(line), ":")
+	return strings.ToUpper(hash), nil
+}
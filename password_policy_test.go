@@ -0,0 +1,96 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEstimateStrength(t *testing.T) {
+	tests := []struct {
+		password string
+		maxScore int
+		minScore int
+	}{
+		{"password", 0, 0},
+		{"aaaaaaaaaaaa", 0, 0},
+		{"abcdefgh", 0, 0},
+		{"qwertyuiop", 0, 0},
+		{"Tr0ub4dor&3", 4, 2},
+		{"correct horse battery staple", 4, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.password, func(t *testing.T) {
+			s := EstimateStrength(tt.password)
+			assert.GreaterOrEqual(t, s.Score, tt.minScore)
+			assert.LessOrEqual(t, s.Score, tt.maxScore)
+		})
+	}
+
+	assert.NotEmpty(t, EstimateStrength("password").Feedback)
+}
+
+func TestPasswordPolicy_Check(t *testing.T) {
+	policy := &PasswordPolicy{MinLength: 10, MinScore: 2}
+
+	problems, err := policy.Check("correct horse battery staple", "John Doe", "john@doe.com")
+	assert.NoError(t, err)
+	assert.Empty(t, problems)
+
+	problems, err = policy.Check("short", "John Doe", "john@doe.com")
+	assert.NoError(t, err)
+	assert.Contains(t, problems, "Use at least 10 characters")
+
+	problems, err = policy.Check("johnny-be-goode-42", "John Doe", "johnny@doe.com")
+	assert.NoError(t, err)
+	assert.Contains(t, problems, "Do not include your name or email address in your password")
+}
+
+func writeBreachedFile(t *testing.T, passwords ...string) string {
+	var lines []string
+	for i, p := range passwords {
+		sum := sha1.Sum([]byte(p))
+		lines = append(lines, fmt.Sprintf("%s:%d", strings.ToUpper(hex.EncodeToString(sum[:])), i+1))
+	}
+	// Pad the list so the search has to cover several regions of the file.
+	for i := 0; i < 500; i++ {
+		sum := sha1.Sum([]byte(fmt.Sprintf("filler-%d", i)))
+		lines = append(lines, fmt.Sprintf("%s:%d", strings.ToUpper(hex.EncodeToString(sum[:])), i))
+	}
+	sort.Strings(lines)
+
+	path := filepath.Join(t.TempDir(), "pwned.txt")
+	require.NoError(t, os.WriteFile(path, []byte(strings.Join(lines, "\r\n")+"\r\n"), 0o600))
+	return path
+}
+
+func TestBreachedPasswords_Contains(t *testing.T) {
+	path := writeBreachedFile(t, "hunter2hunter2", "correct horse battery staple")
+	b, err := OpenBreachedPasswords(path)
+	require.NoError(t, err)
+	defer b.Close()
+
+	for _, p := range []string{"hunter2hunter2", "correct horse battery staple", "filler-0", "filler-499"} {
+		found, err := b.Contains(p)
+		assert.NoError(t, err)
+		assert.True(t, found, p)
+	}
+	for _, p := range []string{"not in the list", "", "filler-500"} {
+		found, err := b.Contains(p)
+		assert.NoError(t, err)
+		assert.False(t, found, p)
+	}
+
+	policy := &PasswordPolicy{MinLength: 10, MinScore: 2, Breached: b}
+	problems, err := policy.Check("correct horse battery staple")
+	assert.NoError(t, err)
+	assert.Contains(t, problems, "This password has appeared in a data breach; choose a different one")
+}
@@ -13,41 +13,116 @@ import (
 )
 
 var (
-	ErrDuplicatePostTitle = errors.New("duplicate post title")
-	ErrDuplicateVote      = errors.New("duplicate vote")
+	ErrDuplicateVote = errors.New("duplicate vote")
+	ErrSiteBanned    = errors.New("links to this site are not allowed")
+	// ErrInvalidPageSize is returned by Filter.Validate; unlike other
+	// errors of the listing methods it is the request's fault.
+	ErrInvalidPageSize = errors.New("invalid page range: 1 to 100 max")
 )
 
 type Post struct {
-	ID           int       `json:"id"`
-	Title        string    `json:"title"`
-	URL          string    `json:"url"`
-	UserID       int       `json:"user_id"`
-	UserName     string    `json:"user_name"`
-	CreatedAt    time.Time `json:"created_at"`
-	CommentCount int       `json:"comment_count"`
-	VoteCount    int       `json:"vote_count"`
-	TotalRecords int       `json:"total_records"`
+	ID            int       `json:"id"`
+	Title         string    `json:"title"`
+	URL           string    `json:"url"`
+	UserID        int       `json:"user_id"`
+	UserName      string    `json:"user_name"`
+	CreatedAt     time.Time `json:"created_at"`
+	CommentCount  int       `json:"comment_count"`
+	VoteCount     int       `json:"vote_count"`
+	FavoriteCount int       `json:"favorite_count"`
+	Favorited     bool      `json:"favorited"` // by Filter.ViewerID
+	DeadLink      bool      `json:"dead_link"`
+	HasSnapshot   bool      `json:"-"` // only loaded by GetByID
+	TotalRecords  int       `json:"total_records"`
 }
 
 type Comment struct {
-	ID        int       `json:"id"`
-	Body      string    `json:"body"`
-	UserID    int       `json:"user_id"`
-	PostID    int       `json:"post_id"`
-	UserName  string    `json:"user_name"`
-	CreatedAt time.Time `json:"created_at"`
+	ID            int       `json:"id"`
+	Body          string    `json:"body"`
+	UserID        int       `json:"user_id"`
+	PostID        int       `json:"post_id"`
+	UserName      string    `json:"user_name"`
+	CreatedAt     time.Time `json:"created_at"`
+	PostTitle     string    `json:"post_title,omitempty"` // only where listed away from the post
+	FavoriteCount int       `json:"favorite_count"`
 }
 
+// Thread is one of a user's comments together with the comments that
+// followed it on the same post.
+type Thread struct {
+	Comment
+	Replies []Comment `json:"replies"`
+}
+
+// threadReplies is how many of the following comments a thread shows.
+const threadReplies = 5
+
 type Filter struct {
 	Page     int    `json:"page"`
 	PageSize int    `json:"page_size"`
 	OrderBy  string `json:"order_by"`
 	Query    string `json:"query"`
+	// ViewerID is the logged-in user the list is shown to, or 0. GetAll
+	// leaves out the posts they hid.
+	ViewerID int `json:"-"`
+	// Since and Until limit GetAll to posts created at or after Since and
+	// before Until; zero times do not limit.
+	Since time.Time `json:"-"`
+	Until time.Time `json:"-"`
+}
+
+const (
+	orderNewest        = "newest"
+	orderPopular       = "popular"
+	orderComments      = "comments"
+	orderActive        = "active"
+	orderControversial = "controversial"
+)
+
+// postOrders maps the order_by values GetAll understands to ORDER BY
+// clauses over postListSelect; anything else lists the newest posts first.
+// Without downvotes, controversial posts are those with the most discussion
+// for their votes.
+var postOrders = map[string]string{
+	orderNewest:   "p.created_at DESC, p.id DESC",
+	orderPopular:  "vote_count DESC, p.created_at DESC",
+	orderComments: "comment_count DESC, p.created_at DESC",
+	orderActive: "COALESCE((SELECT MAX(c.created_at) FROM comments c WHERE c.post_id = p.id), p.created_at) DESC, " +
+		"p.id DESC",
+	orderControversial: "CAST(comment_count AS REAL) / (vote_count + 1) DESC, comment_count DESC, p.created_at DESC",
 }
 
+const (
+	windowDay   = "day"
+	windowWeek  = "week"
+	windowMonth = "month"
+	windowYear  = "year"
+	windowAll   = "all"
+)
+
+// windowSince returns when a time window ending at now starts, or the zero
+// time for all time and unknown windows.
+func windowSince(window string, now time.Time) time.Time {
+	switch window {
+	case windowDay:
+		return now.AddDate(0, 0, -1)
+	case windowWeek:
+		return now.AddDate(0, 0, -7)
+	case windowMonth:
+		return now.AddDate(0, -1, 0)
+	case windowYear:
+		return now.AddDate(-1, 0, 0)
+	}
+	return time.Time{}
+}
+
+// sqliteTimeLayout is how SQLite's CURRENT_TIMESTAMP writes created_at
+// columns; times compared with them must be formatted the same way.
+const sqliteTimeLayout = "2006-01-02 15:04:05"
+
 func (f *Filter) Validate() error {
 	if f.PageSize <= 0 || f.PageSize >= 100 {
-		return errors.New("invalid page range: 1 to 100 max")
+		return ErrInvalidPageSize
 	}
 	return nil
 }
@@ -86,11 +161,27 @@ func calculateMetadata(totalRecords, page, pageSize int) Metadata {
 
 type PostRepository interface {
 	CreatePost(title, url string, userID int) (int, error)
+	FindRecentPostByURL(urls []string, since time.Time) (int, error)
 	AddComment(userID, postID int, body string) (int, error)
 	AddVote(userID, postID int) error
 	GetAll(filter Filter) ([]Post, Metadata, error)
 	GetByID(id int) (*Post, error)
 	GetComments(postID int) ([]Comment, error)
+	SetPostFavorite(userID, postID int, favorite bool) error
+	SetCommentFavorite(userID, commentID int, favorite bool) error
+	GetFavoritePosts(userID int, filter Filter) ([]Post, Metadata, error)
+	GetFavoriteComments(userID int, filter Filter) ([]Comment, Metadata, error)
+	SetPostHidden(userID, postID int, hidden bool) error
+	GetHiddenPosts(userID int, filter Filter) ([]Post, Metadata, error)
+	GetPostsByUser(userID int, filter Filter) ([]Post, Metadata, error)
+	GetUpvotedPosts(userID int, filter Filter) ([]Post, Metadata, error)
+	GetTopPosts(limit int) ([]Post, error)
+	GetThreads(userID int, filter Filter) ([]Thread, Metadata, error)
+	GetSitePosts(site string, filter Filter) ([]Post, Metadata, error)
+	GetSiteStats(site string) (*SiteStats, error)
+	SetSiteSanction(site, status, reason string, adminID int) error
+	RemoveSiteSanction(site string) error
+	GetSiteSanctions() ([]SiteSanction, error)
 }
 
 type SQLPostRepository struct {
@@ -102,13 +193,23 @@ func NewSQLPostRepository(db *sql.DB) *SQLPostRepository {
 	return &SQLPostRepository{db: db}
 }
 
+// CreatePost adds a post, or returns ErrSiteBanned when its link goes to a
+// banned site or a subdomain of one.
 func (r *SQLPostRepository) CreatePost(title, url string, userID int) (int, error) {
-	stmt := "INSERT INTO posts (title, url, user_id) VALUES (?, ?, ?)"
-	result, err := r.db.Exec(stmt, title, url, userID)
+	site := siteOf(url)
+	var banned bool
+	err := r.db.QueryRow("SELECT EXISTS(SELECT 1 FROM site_sanctions ss WHERE "+siteSanctionMatch+")",
+		siteStatusBanned, site, site).Scan(&banned)
+	if err != nil {
+		return 0, err
+	}
+	if banned {
+		return 0, ErrSiteBanned
+	}
+
+	stmt := "INSERT INTO posts (title, url, site, user_id) VALUES (?, ?, ?, ?)"
+	result, err := r.db.Exec(stmt, title, url, site, userID)
 	if err != nil {
-		if strings.Contains(err.Error(), "UNIQUE constraint failed: posts.title") {
-			return 0, ErrDuplicatePostTitle
-		}
 		return 0, err
 	}
 	postID, err := result.LastInsertId()
@@ -118,13 +219,30 @@ func (r *SQLPostRepository) CreatePost(title, url string, userID int) (int, erro
 	return int(postID), nil
 }
 
+// FindRecentPostByURL returns the id of the latest post created since since
+// that links to one of urls, or 0 if there is none.
+func (r *SQLPostRepository) FindRecentPostByURL(urls []string, since time.Time) (int, error) {
+	if len(urls) == 0 {
+		return 0, nil
+	}
+	args := []interface{}{since.UTC().Format(sqliteTimeLayout)}
+	for _, u := range urls {
+		args = append(args, u)
+	}
+	stmt := `SELECT id FROM posts WHERE created_at >= ? AND url IN (?` + strings.Repeat(", ?", len(urls)-1) + `)
+		ORDER BY created_at DESC, id DESC LIMIT 1`
+	var id int
+	err := r.db.QueryRow(stmt, args...).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	return id, err
+}
+
 func (r *SQLPostRepository) AddComment(userID, postID int, body string) (int, error) {
 	stmt := "INSERT INTO comments (user_id, post_id, body) VALUES (?, ?, ?)"
 	result, err := r.db.Exec(stmt, userID, postID, body)
 	if err != nil {
-		if strings.Contains(err.Error(), "UNIQUE constraint failed: posts.title") {
-			return 0, ErrDuplicatePostTitle
-		}
 		return 0, err
 	}
 	commentID, err := result.LastInsertId()
@@ -135,8 +253,14 @@ func (r *SQLPostRepository) AddComment(userID, postID int, body string) (int, er
 }
 
 func (r *SQLPostRepository) AddVote(userID, postID int) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
 	stmt := "INSERT INTO votes (user_id, post_id) VALUES (?, ?)"
-	_, err := r.db.Exec(stmt, userID, postID)
+	_, err = tx.Exec(stmt, userID, postID)
 	if err != nil {
 		if strings.Contains(err.Error(), "UNIQUE constraint failed") ||
 			strings.Contains(err.Error(), "PRIMARY KEY constraint failed") {
@@ -144,7 +268,11 @@ func (r *SQLPostRepository) AddVote(userID, postID int) error {
 		}
 		return err
 	}
-	return nil
+	_, err = tx.Exec("UPDATE users SET karma = karma + 1 WHERE id = (SELECT user_id FROM posts WHERE id = ?)", postID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
 func (r *SQLPostRepository) GetByID(id int) (*Post, error) {
@@ -152,7 +280,10 @@ func (r *SQLPostRepository) GetByID(id int) (*Post, error) {
 	SELECT p.id, p.title, p.url, p.user_id, p.created_at,
 	u.name as user_name,
 	COUNT(DISTINCT c.id) AS comment_count,
-	COUNT(DISTINCT v.user_id) AS vote_count
+	COUNT(DISTINCT v.user_id) AS vote_count,
+	(SELECT COUNT(*) FROM favorite_posts f WHERE f.post_id = p.id) AS favorite_count,
+	` + postDeadLink + ` AS dead_link,
+	EXISTS(SELECT 1 FROM snapshots s WHERE s.post_id = p.id) AS has_snapshot
 	FROM posts p
 	LEFT JOIN users u ON p.user_id = u.id
 	LEFT JOIN comments c ON p.id = c.post_id
@@ -170,51 +301,89 @@ func (r *SQLPostRepository) GetByID(id int) (*Post, error) {
 		&post.CreatedAt,
 		&post.UserName,
 		&post.CommentCount,
-		&post.VoteCount)
+		&post.VoteCount,
+		&post.FavoriteCount,
+		&post.DeadLink,
+		&post.HasSnapshot)
 	if err != nil {
 		return nil, err
 	}
 	return &post, nil
 }
 
+// postDeadLink tells whether the link checker gave up on the post's link.
+const postDeadLink = "EXISTS(SELECT 1 FROM link_checks lc WHERE lc.post_id = p.id AND lc.dead)"
+
+// postListSelect loads a page of posts with their author and counts for
+// queryPosts. Its only parameter is the viewer, for the favorited flag.
+const postListSelect = `
+	SELECT
+		COUNT(*) OVER() as total_records,
+		p.id, p.title, p.url, p.user_id, p.created_at,
+		u.name as user_name,
+		(SELECT COUNT(*) FROM comments c WHERE c.post_id = p.id) as comment_count,
+		(SELECT COUNT(*) FROM votes v WHERE v.post_id = p.id) as vote_count,
+		(SELECT COUNT(*) FROM favorite_posts f WHERE f.post_id = p.id) as favorite_count,
+		EXISTS(SELECT 1 FROM favorite_posts f WHERE f.post_id = p.id AND f.user_id = ?) as favorited,
+		` + postDeadLink + ` as dead_link
+	FROM posts p
+	LEFT JOIN users u ON p.user_id = u.id
+`
+
 func (r *SQLPostRepository) GetAll(filter Filter) ([]Post, Metadata, error) {
-	if err := filter.Validate(); err != nil {
+	var where []string
+	var args []interface{}
+
+	search, err := ParseSearch(filter.Query)
+	if err != nil {
 		return nil, Metadata{}, err
 	}
+	where, args = search.where(postSearch)
+	if !filter.Since.IsZero() {
+		where = append(where, "p.created_at >= ?")
+		args = append(args, filter.Since.UTC().Format(sqliteTimeLayout))
+	}
+	if !filter.Until.IsZero() {
+		where = append(where, "p.created_at < ?")
+		args = append(args, filter.Until.UTC().Format(sqliteTimeLayout))
+	}
+	where = append(where, "NOT "+postSanctioned)
+	args = append(args, siteStatusBanned)
+	if filter.ViewerID != 0 {
+		where = append(where, "NOT EXISTS(SELECT 1 FROM hidden_posts h WHERE h.post_id = p.id AND h.user_id = ?)")
+		args = append(args, filter.ViewerID)
+	}
 
-	baseQuery := `
-		SELECT 
-			COUNT(*) OVER() as total_records,
-			p.id, p.title, p.url, p.user_id, p.created_at,
-			u.name as user_name,
-			COUNT(DISTINCT c.id) as comment_count,
-			COUNT(DISTINCT v.user_id) as vote_count
-		FROM posts p
-		LEFT JOIN users u ON p.user_id = u.id
-		LEFT JOIN comments c ON p.id = c.post_id
-		LEFT JOIN votes v ON p.id = v.post_id
-	`
-
-	var args []interface{}
-
-	if filter.Query != "" {
-		baseQuery += " WHERE LOWER(p.title) LIKE ?"
-		args = append(args, "%"+strings.ToLower(filter.Query)+"%")
+	var clauses string
+	if len(where) > 0 {
+		clauses = " WHERE " + strings.Join(where, " AND ")
 	}
 
-	baseQuery += " GROUP BY p.id, p.title, p.url, p.user_id, p.created_at, u.name"
-	if filter.OrderBy == "popular" {
-		baseQuery += " ORDER BY vote_count DESC, p.created_at DESC"
+	order, ok := postOrders[filter.OrderBy]
+	if !ok || filter.OrderBy == orderNewest {
+		order = postOrders[orderNewest]
 	} else {
-		baseQuery += " ORDER BY p.created_at DESC"
+		// penalized sites rank below everything else
+		order = postSanctioned + ", " + order
+		args = append(args, siteStatusPenalized)
+	}
+	clauses += " ORDER BY " + order
+	return r.queryPosts(filter, clauses, args...)
+}
+
+// queryPosts returns the page of posts that filter asks for, selected and
+// ordered by clauses, which follow postListSelect and take args.
+func (r *SQLPostRepository) queryPosts(filter Filter, clauses string, args ...interface{}) ([]Post, Metadata, error) {
+	if err := filter.Validate(); err != nil {
+		return nil, Metadata{}, err
 	}
 
 	limit := filter.PageSize
 	offset := (filter.Page - 1) * filter.PageSize
-	baseQuery += " LIMIT ? OFFSET ?"
+	args = append([]interface{}{filter.ViewerID}, args...)
 	args = append(args, limit, offset)
 
-	rows, err := r.db.Query(baseQuery, args...)
+	rows, err := r.db.Query(postListSelect+clauses+" LIMIT ? OFFSET ?", args...)
 	if err != nil {
 		return nil, Metadata{}, err
 	}
@@ -225,7 +394,7 @@ func (r *SQLPostRepository) GetAll(filter Filter) ([]Post, Metadata, error) {
 	for rows.Next() {
 		var post Post
 		err := rows.Scan(&totalRecords, &post.ID, &post.Title, &post.URL, &post.UserID,
-			&post.CreatedAt, &post.UserName, &post.CommentCount, &post.VoteCount)
+			&post.CreatedAt, &post.UserName, &post.CommentCount, &post.VoteCount, &post.FavoriteCount, &post.Favorited, &post.DeadLink)
 		if err != nil {
 			return nil, Metadata{}, err
 		}
@@ -247,7 +416,8 @@ func (r *SQLPostRepository) GetAll(filter Filter) ([]Post, Metadata, error) {
 
 func (r *SQLPostRepository) GetComments(postID int) ([]Comment, error) {
 	stmt := `
-		SELECT c.id, c.body, c.user_id, c.post_id, c.created_at, u.name as user_name
+		SELECT c.id, c.body, c.user_id, c.post_id, c.created_at, u.name as user_name,
+			(SELECT COUNT(*) FROM favorite_comments f WHERE f.comment_id = c.id) as favorite_count
 		FROM comments c
 		LEFT JOIN users u ON c.user_id = u.id
 		WHERE c.post_id = ?
@@ -263,7 +433,7 @@ func (r *SQLPostRepository) GetComments(postID int) ([]Comment, error) {
 	for rows.Next() {
 		var comment Comment
 		err := rows.Scan(&comment.ID, &comment.Body, &comment.UserID, &comment.PostID,
-			&comment.CreatedAt, &comment.UserName)
+			&comment.CreatedAt, &comment.UserName, &comment.FavoriteCount)
 		if err != nil {
 			return nil, err
 		}
@@ -278,6 +448,226 @@ func (r *SQLPostRepository) GetComments(postID int) ([]Comment, error) {
 	}
 	return comments, nil
 }
+
+// SetPostFavorite adds the post to the user's favorites or removes it. It
+// returns sql.ErrNoRows if there is no such post.
+func (r *SQLPostRepository) SetPostFavorite(userID, postID int, favorite bool) error {
+	if !favorite {
+		_, err := r.db.Exec("DELETE FROM favorite_posts WHERE user_id = ? AND post_id = ?", userID, postID)
+		return err
+	}
+	if err := r.requireRow("SELECT EXISTS(SELECT 1 FROM posts WHERE id = ?)", postID); err != nil {
+		return err
+	}
+	_, err := r.db.Exec("INSERT OR IGNORE INTO favorite_posts (user_id, post_id) VALUES (?, ?)", userID, postID)
+	return err
+}
+
+// SetCommentFavorite adds the comment to the user's favorites or removes
+// it. It returns sql.ErrNoRows if there is no such comment.
+func (r *SQLPostRepository) SetCommentFavorite(userID, commentID int, favorite bool) error {
+	if !favorite {
+		_, err := r.db.Exec("DELETE FROM favorite_comments WHERE user_id = ? AND comment_id = ?", userID, commentID)
+		return err
+	}
+	if err := r.requireRow("SELECT EXISTS(SELECT 1 FROM comments WHERE id = ?)", commentID); err != nil {
+		return err
+	}
+	_, err := r.db.Exec("INSERT OR IGNORE INTO favorite_comments (user_id, comment_id) VALUES (?, ?)", userID, commentID)
+	return err
+}
+
+// SetPostHidden hides the post from the user's front page or shows it
+// again. It returns sql.ErrNoRows if there is no such post.
+func (r *SQLPostRepository) SetPostHidden(userID, postID int, hidden bool) error {
+	if !hidden {
+		_, err := r.db.Exec("DELETE FROM hidden_posts WHERE user_id = ? AND post_id = ?", userID, postID)
+		return err
+	}
+	if err := r.requireRow("SELECT EXISTS(SELECT 1 FROM posts WHERE id = ?)", postID); err != nil {
+		return err
+	}
+	_, err := r.db.Exec("INSERT OR IGNORE INTO hidden_posts (user_id, post_id) VALUES (?, ?)", userID, postID)
+	return err
+}
+
+// GetHiddenPosts returns the posts the user hid, most recently hidden first.
+func (r *SQLPostRepository) GetHiddenPosts(userID int, filter Filter) ([]Post, Metadata, error) {
+	clauses := " INNER JOIN hidden_posts h ON h.post_id = p.id WHERE h.user_id = ? ORDER BY h.created_at DESC, p.id DESC"
+	return r.queryPosts(filter, clauses, userID)
+}
+
+// GetPostsByUser returns the posts the user submitted, newest first.
+func (r *SQLPostRepository) GetPostsByUser(userID int, filter Filter) ([]Post, Metadata, error) {
+	return r.queryPosts(filter, " WHERE p.user_id = ? ORDER BY p.created_at DESC, p.id DESC", userID)
+}
+
+// GetUpvotedPosts returns the posts the user voted for, most recent vote
+// first.
+func (r *SQLPostRepository) GetUpvotedPosts(userID int, filter Filter) ([]Post, Metadata, error) {
+	clauses := " INNER JOIN votes uv ON uv.post_id = p.id WHERE uv.user_id = ? ORDER BY uv.created_at DESC, p.id DESC"
+	return r.queryPosts(filter, clauses, userID)
+}
+
+// GetTopPosts returns the id, title, URL and vote count of up to limit
+// posts, most voted first, leaving out posts from banned sites.
+func (r *SQLPostRepository) GetTopPosts(limit int) ([]Post, error) {
+	stmt := `SELECT p.id, p.title, p.url, (SELECT COUNT(*) FROM votes v WHERE v.post_id = p.id) as vote_count
+		FROM posts p WHERE NOT ` + postSanctioned + ` ORDER BY vote_count DESC, p.created_at DESC LIMIT ?`
+	rows, err := r.db.Query(stmt, siteStatusBanned, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var posts []Post
+	for rows.Next() {
+		var p Post
+		if err := rows.Scan(&p.ID, &p.Title, &p.URL, &p.VoteCount); err != nil {
+			return nil, err
+		}
+		posts = append(posts, p)
+	}
+	return posts, rows.Err()
+}
+
+// GetThreads returns the user's comments, newest first, each with the title
+// of its post and up to threadReplies comments that followed it there.
+func (r *SQLPostRepository) GetThreads(userID int, filter Filter) ([]Thread, Metadata, error) {
+	if err := filter.Validate(); err != nil {
+		return nil, Metadata{}, err
+	}
+	stmt := `
+		SELECT COUNT(*) OVER() as total_records,
+			c.id, c.body, c.user_id, c.post_id, c.created_at, u.name as user_name, p.title,
+			(SELECT COUNT(*) FROM favorite_comments f WHERE f.comment_id = c.id) as favorite_count
+		FROM comments c
+		INNER JOIN posts p ON p.id = c.post_id
+		LEFT JOIN users u ON c.user_id = u.id
+		WHERE c.user_id = ?
+		ORDER BY c.created_at DESC, c.id DESC
+		LIMIT ? OFFSET ?
+	`
+	rows, err := r.db.Query(stmt, userID, filter.PageSize, (filter.Page-1)*filter.PageSize)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	threads := []Thread{}
+	var totalRecords int
+	for rows.Next() {
+		var t Thread
+		err := rows.Scan(&totalRecords, &t.ID, &t.Body, &t.UserID, &t.PostID, &t.CreatedAt, &t.UserName,
+			&t.PostTitle, &t.FavoriteCount)
+		if err != nil {
+			rows.Close()
+			return nil, Metadata{}, err
+		}
+		threads = append(threads, t)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	for i := range threads {
+		threads[i].Replies, err = r.getReplies(threads[i].Comment)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+	}
+	return threads, calculateMetadata(totalRecords, filter.Page, filter.PageSize), nil
+}
+
+// getReplies returns the comments posted on the same post after c.
+func (r *SQLPostRepository) getReplies(c Comment) ([]Comment, error) {
+	stmt := `
+		SELECT c.id, c.body, c.user_id, c.post_id, c.created_at, u.name as user_name,
+			(SELECT COUNT(*) FROM favorite_comments f WHERE f.comment_id = c.id) as favorite_count
+		FROM comments c
+		LEFT JOIN users u ON c.user_id = u.id
+		WHERE c.post_id = ? AND c.id > ?
+		ORDER BY c.id ASC
+		LIMIT ?
+	`
+	rows, err := r.db.Query(stmt, c.PostID, c.ID, threadReplies)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var replies []Comment
+	for rows.Next() {
+		var reply Comment
+		err := rows.Scan(&reply.ID, &reply.Body, &reply.UserID, &reply.PostID, &reply.CreatedAt, &reply.UserName,
+			&reply.FavoriteCount)
+		if err != nil {
+			return nil, err
+		}
+		replies = append(replies, reply)
+	}
+	return replies, rows.Err()
+}
+
+// requireRow runs an EXISTS query and returns sql.ErrNoRows if it is false.
+func (r *SQLPostRepository) requireRow(query string, args ...interface{}) error {
+	var exists bool
+	if err := r.db.QueryRow(query, args...).Scan(&exists); err != nil {
+		return err
+	}
+	if !exists {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// GetFavoritePosts returns the user's favorite posts, most recently
+// favorited first.
+func (r *SQLPostRepository) GetFavoritePosts(userID int, filter Filter) ([]Post, Metadata, error) {
+	clauses := " INNER JOIN favorite_posts fav ON fav.post_id = p.id WHERE fav.user_id = ? ORDER BY fav.created_at DESC, p.id DESC"
+	return r.queryPosts(filter, clauses, userID)
+}
+
+// GetFavoriteComments returns the user's favorite comments together with
+// the title of their post, most recently favorited first.
+func (r *SQLPostRepository) GetFavoriteComments(userID int, filter Filter) ([]Comment, Metadata, error) {
+	if err := filter.Validate(); err != nil {
+		return nil, Metadata{}, err
+	}
+	stmt := `
+		SELECT COUNT(*) OVER() as total_records,
+			c.id, c.body, c.user_id, c.post_id, c.created_at, u.name as user_name, p.title,
+			(SELECT COUNT(*) FROM favorite_comments f WHERE f.comment_id = c.id) as favorite_count
+		FROM comments c
+		INNER JOIN favorite_comments fav ON fav.comment_id = c.id
+		INNER JOIN posts p ON p.id = c.post_id
+		LEFT JOIN users u ON c.user_id = u.id
+		WHERE fav.user_id = ?
+		ORDER BY fav.created_at DESC, c.id DESC
+		LIMIT ? OFFSET ?
+	`
+	rows, err := r.db.Query(stmt, userID, filter.PageSize, (filter.Page-1)*filter.PageSize)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	comments := []Comment{}
+	var totalRecords int
+	for rows.Next() {
+		var c Comment
+		err := rows.Scan(&totalRecords, &c.ID, &c.Body, &c.UserID, &c.PostID, &c.CreatedAt, &c.UserName,
+			&c.PostTitle, &c.FavoriteCount)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		comments = append(comments, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+	return comments, calculateMetadata(totalRecords, filter.Page, filter.PageSize), nil
+}
+
 func (p *Post) GetVoteCountsHuman() string {
 	if p.VoteCount > 1 {
 		return fmt.Sprintf("%d votes", p.VoteCount)
@@ -294,10 +684,23 @@ func (p *Post) GetCommentCountsHuman() string {
 	return fmt.Sprintf("%d comment", p.CommentCount)
 }
 
+func (p *Post) GetFavoriteCountsHuman() string {
+	if p.FavoriteCount > 1 {
+		return fmt.Sprintf("%d favorites", p.FavoriteCount)
+	}
+
+	return fmt.Sprintf("%d favorite", p.FavoriteCount)
+}
+
 func (p *Post) CreatedAtHuman() string {
 	return carbon.NewCarbon(p.CreatedAt).DiffForHumans()
 }
 
+// Site is the post's site: the lowercased host of its link without "www.".
+func (p *Post) Site() string {
+	return siteOf(p.URL)
+}
+
 func (p *Post) Host() string {
 	ur, err := url.Parse(p.URL)
 	if err != nil {
@@ -305,3 +708,120 @@ func (p *Post) Host() string {
 	}
 	return ur.Hostname()
 }
+
+// siteOf returns the site a link goes to: its lowercased host without a
+// leading "www.", so that www.example.com and example.com are one site.
+func siteOf(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return normalizeSite(u.Hostname())
+}
+
+// normalizeSite lowercases a host name and drops a leading "www.".
+func normalizeSite(host string) string {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	return strings.TrimPrefix(host, "www.")
+}
+
+const (
+	siteStatusPenalized = "penalized"
+	siteStatusBanned    = "banned"
+)
+
+// siteSanctionMatch matches a sanction ss of the status given as its first
+// parameter for the site given as the next two, or any subdomain of it.
+const siteSanctionMatch = "ss.status = ? AND (? = ss.site OR ? LIKE '%.' || ss.site)"
+
+// postSanctioned is true for posts p from a site with a sanction of the
+// status given as its parameter.
+const postSanctioned = `EXISTS(SELECT 1 FROM site_sanctions ss WHERE ss.status = ?
+	AND (p.site = ss.site OR p.site LIKE '%.' || ss.site))`
+
+// SiteStats sums up the posts linking to a site and its subdomains, with
+// the sanction admins placed on it, if any.
+type SiteStats struct {
+	Site     string
+	Posts    int
+	Points   int
+	Comments int
+	Status   string // siteStatusPenalized, siteStatusBanned or empty
+	Reason   string
+}
+
+// SiteSanction is an admin's penalty or ban on a site and its subdomains.
+type SiteSanction struct {
+	Site      string
+	Status    string
+	Reason    string
+	AdminName string
+	CreatedAt time.Time
+}
+
+// siteCondition matches posts p from site or one of its subdomains.
+func siteCondition(site string) (string, []interface{}) {
+	return `(p.site = ? OR p.site LIKE ? ESCAPE '\')`, []interface{}{site, "%." + escapeLike(site)}
+}
+
+// GetSitePosts returns the posts linking to site or its subdomains, newest
+// first.
+func (r *SQLPostRepository) GetSitePosts(site string, filter Filter) ([]Post, Metadata, error) {
+	cond, args := siteCondition(site)
+	return r.queryPosts(filter, " WHERE "+cond+" ORDER BY p.created_at DESC, p.id DESC", args...)
+}
+
+func (r *SQLPostRepository) GetSiteStats(site string) (*SiteStats, error) {
+	cond, args := siteCondition(site)
+	stats := &SiteStats{Site: site}
+	stmt := `SELECT COUNT(*),
+		COALESCE(SUM((SELECT COUNT(*) FROM votes v WHERE v.post_id = p.id)), 0),
+		COALESCE(SUM((SELECT COUNT(*) FROM comments c WHERE c.post_id = p.id)), 0)
+		FROM posts p WHERE ` + cond
+	if err := r.db.QueryRow(stmt, args...).Scan(&stats.Posts, &stats.Points, &stats.Comments); err != nil {
+		return nil, err
+	}
+
+	// a sanction on a parent domain covers the site too; the closest wins
+	stmt = `SELECT ss.status, ss.reason FROM site_sanctions ss
+		WHERE ? = ss.site OR ? LIKE '%.' || ss.site ORDER BY LENGTH(ss.site) DESC LIMIT 1`
+	err := r.db.QueryRow(stmt, site, site).Scan(&stats.Status, &stats.Reason)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// SetSiteSanction penalizes or bans site and its subdomains, replacing any
+// earlier sanction on it.
+func (r *SQLPostRepository) SetSiteSanction(site, status, reason string, adminID int) error {
+	stmt := `INSERT INTO site_sanctions (site, status, reason, admin_id) VALUES (?, ?, ?, ?)
+		ON CONFLICT (site) DO UPDATE SET status = excluded.status, reason = excluded.reason,
+		admin_id = excluded.admin_id, created_at = CURRENT_TIMESTAMP`
+	_, err := r.db.Exec(stmt, site, status, reason, adminID)
+	return err
+}
+
+func (r *SQLPostRepository) RemoveSiteSanction(site string) error {
+	_, err := r.db.Exec("DELETE FROM site_sanctions WHERE site = ?", site)
+	return err
+}
+
+func (r *SQLPostRepository) GetSiteSanctions() ([]SiteSanction, error) {
+	rows, err := r.db.Query(`SELECT ss.site, ss.status, ss.reason, COALESCE(u.name, ''), ss.created_at
+		FROM site_sanctions ss LEFT JOIN users u ON u.id = ss.admin_id ORDER BY ss.created_at DESC, ss.site`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sanctions []SiteSanction
+	for rows.Next() {
+		var s SiteSanction
+		if err := rows.Scan(&s.Site, &s.Status, &s.Reason, &s.AdminName, &s.CreatedAt); err != nil {
+			return nil, err
+		}
+		sanctions = append(sanctions, s)
+	}
+	return sanctions, rows.Err()
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func postTitles(posts []Post) []string {
+	var titles []string
+	for _, p := range posts {
+		titles = append(titles, p.Title)
+	}
+	return titles
+}
+
+func TestGetAll_Orders(t *testing.T) {
+	defer cleanupTestData(t)
+	u := createTestUser(t, "Poster", "poster@test.com")
+	voters := []*User{
+		createTestUser(t, "Voter One", "voter1@test.com"),
+		createTestUser(t, "Voter Two", "voter2@test.com"),
+		createTestUser(t, "Voter Three", "voter3@test.com"),
+	}
+	old := createPostAt(t, u, "Old favourite", "2024-01-01 10:00:00")
+	flame := createPostAt(t, u, "Flame war", "2024-01-02 10:00:00")
+	quiet := createPostAt(t, u, "Quiet news", "2024-01-03 10:00:00")
+	for _, v := range voters {
+		require.NoError(t, testApp.postRepo.AddVote(v.ID, old))
+	}
+	require.NoError(t, testApp.postRepo.AddVote(voters[0].ID, quiet))
+	for _, body := range []string{"I disagree", "No, you are wrong", "Both wrong"} {
+		_, err := testApp.postRepo.AddComment(u.ID, flame, body)
+		require.NoError(t, err)
+	}
+	c, err := testApp.postRepo.AddComment(u.ID, old, "Still relevant")
+	require.NoError(t, err)
+	_, err = testDB.Exec("UPDATE comments SET created_at = '2999-01-01 00:00:00' WHERE id = ?", c)
+	require.NoError(t, err)
+
+	for order, want := range map[string][]string{
+		"":                 {"Quiet news", "Flame war", "Old favourite"},
+		orderNewest:        {"Quiet news", "Flame war", "Old favourite"},
+		orderPopular:       {"Old favourite", "Quiet news", "Flame war"},
+		orderComments:      {"Flame war", "Old favourite", "Quiet news"},
+		orderActive:        {"Old favourite", "Flame war", "Quiet news"},
+		orderControversial: {"Flame war", "Old favourite", "Quiet news"},
+	} {
+		posts, _, err := testApp.postRepo.GetAll(Filter{Page: 1, PageSize: 10, OrderBy: order})
+		require.NoError(t, err, order)
+		assert.Equal(t, want, postTitles(posts), order)
+	}
+}
+
+func TestGetAll_Window(t *testing.T) {
+	defer cleanupTestData(t)
+	u := createTestUser(t, "Poster", "poster@test.com")
+	now := time.Now().UTC()
+	createPostAt(t, u, "This hour", now.Add(-time.Hour).Format(sqliteTimeLayout))
+	createPostAt(t, u, "Three days ago", now.AddDate(0, 0, -3).Format(sqliteTimeLayout))
+	createPostAt(t, u, "Last year", now.AddDate(-1, -1, 0).Format(sqliteTimeLayout))
+
+	for window, want := range map[string]int{windowDay: 1, windowWeek: 2, windowMonth: 2, windowYear: 2, windowAll: 3, "": 3} {
+		_, meta, err := testApp.postRepo.GetAll(Filter{Page: 1, PageSize: 10, OrderBy: orderPopular, Since: windowSince(window, now)})
+		require.NoError(t, err)
+		assert.Equal(t, want, meta.TotalRecords, window)
+	}
+}
@@ -0,0 +1,37 @@
+// Suggests a title for the link on the submit form by looking the page up
+// through the URL input's data-fetch endpoint. A title the user has typed
+// is never replaced.
+(function () {
+  var input = document.querySelector("input[data-fetch]");
+  var title = document.getElementById("title");
+  var hint = document.getElementById("fetch-hint");
+  if (!input || !title) {
+    return;
+  }
+
+  var suggested = "", latest;
+  input.addEventListener("change", function () {
+    var link = input.value.trim();
+    if (link === "" || (title.value !== "" && title.value !== suggested)) {
+      return;
+    }
+    latest = link;
+    hint.textContent = "Looking up the page…";
+    fetch(input.dataset.fetch + "?url=" + encodeURIComponent(link))
+      .then(function (res) { return res.json(); })
+      .then(function (data) {
+        if (link !== latest) {
+          return;
+        }
+        hint.textContent = data.error || "";
+        if (data.title && (title.value === "" || title.value === suggested)) {
+          suggested = data.title;
+          title.value = data.title;
+        }
+        if (data.canonical_url && data.canonical_url !== link) {
+          hint.textContent = "The page calls itself " + data.canonical_url;
+        }
+      })
+      .catch(function () { hint.textContent = ""; });
+  });
+})();
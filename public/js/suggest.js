@@ -0,0 +1,38 @@
+// Offers completions from /search/suggest under inputs with a
+// data-suggest attribute. Without JavaScript the search box works as a
+// plain text input.
+(function () {
+  document.querySelectorAll("input[data-suggest]").forEach(function (input) {
+    var list = document.createElement("datalist");
+    list.id = input.name + "-suggestions";
+    input.after(list);
+    input.setAttribute("list", list.id);
+
+    var timer, latest;
+    input.addEventListener("input", function () {
+      clearTimeout(timer);
+      var q = input.value.trim();
+      if (q === "") {
+        list.replaceChildren();
+        return;
+      }
+      timer = setTimeout(function () {
+        latest = q;
+        fetch(input.dataset.suggest + "?q=" + encodeURIComponent(q))
+          .then(function (res) { return res.ok ? res.json() : { suggestions: [] }; })
+          .then(function (data) {
+            if (q !== latest) {
+              return;
+            }
+            list.replaceChildren.apply(list, data.suggestions.map(function (s) {
+              var option = document.createElement("option");
+              option.value = s.query;
+              option.label = s.kind + ": " + s.text;
+              return option;
+            }));
+          })
+          .catch(function () {});
+      }, 150);
+    });
+  });
+})();
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	registrationOpen     = "open"
+	registrationClosed   = "closed"
+	registrationDomains  = "domains"
+	registrationApproval = "approval"
+)
+
+var (
+	ErrRegistrationClosed  = errors.New("registration is closed")
+	ErrEmailDomainRejected = errors.New("accounts are restricted to approved email domains")
+	ErrPendingApproval     = errors.New("your account is waiting for approval by an administrator")
+)
+
+// RegistrationPolicy decides who may create an account. In domains mode only
+// addresses in Domains may register or log in; in approval mode new accounts
+// stay pending until an admin approves them. The zero value is open.
+type RegistrationPolicy struct {
+	Mode    string
+	Domains []string
+}
+
+// ParseRegistrationPolicy validates a mode and a comma separated list of
+// email domains as given on the command line.
+func ParseRegistrationPolicy(mode, domains string) (RegistrationPolicy, error) {
+	p := RegistrationPolicy{Mode: mode}
+	for _, d := range strings.Split(domains, ",") {
+		if d = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(d), "@")); d != "" {
+			p.Domains = append(p.Domains, d)
+		}
+	}
+	switch mode {
+	case registrationOpen, registrationClosed, registrationApproval:
+	case registrationDomains:
+		if len(p.Domains) == 0 {
+			return p, errors.New("registration mode domains needs at least one domain")
+		}
+	default:
+		return p, fmt.Errorf("unknown registration mode %q", mode)
+	}
+	return p, nil
+}
+
+// Closed reports whether nobody may sign up.
+func (p RegistrationPolicy) Closed() bool {
+	return p.Mode == registrationClosed
+}
+
+// DomainAllowed reports whether email belongs to an allowed domain.
+func (p RegistrationPolicy) DomainAllowed(email string) bool {
+	if p.Mode != registrationDomains {
+		return true
+	}
+	_, domain, ok := strings.Cut(strings.ToLower(email), "@")
+	if !ok {
+		return false
+	}
+	for _, d := range p.Domains {
+		if domain == d {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckSignup returns why email may not create an account, if anything.
+func (p RegistrationPolicy) CheckSignup(email string) error {
+	if p.Closed() {
+		return ErrRegistrationClosed
+	}
+	if !p.DomainAllowed(email) {
+		return ErrEmailDomainRejected
+	}
+	return nil
+}
+
+// CheckLogin returns why u may not log in, if anything. Admins are exempt
+// from the domain allowlist so that they cannot lock themselves out.
+func (p RegistrationPolicy) CheckLogin(u *User) error {
+	switch u.Status {
+	case userStatusPending:
+		return ErrPendingApproval
+	case userStatusBanned:
+		return ErrAccountBanned
+	case userStatusDeactivated:
+		return ErrAccountInactive
+	}
+	if !u.IsAdmin && !p.DomainAllowed(u.Email) {
+		return ErrEmailDomainRejected
+	}
+	return nil
+}
+
+// InitialStatus is the status of newly created accounts.
+func (p RegistrationPolicy) InitialStatus() string {
+	if p.Mode == registrationApproval {
+		return userStatusPending
+	}
+	return userStatusActive
+}
+
+func (app *application) adminPendingUsers(w http.ResponseWriter, r *http.Request) {
+	users, err := app.userRepo.GetUsersByStatus(userStatusPending)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	app.render(w, r, "admin-pending-users.html", &templateData{Users: users})
+}
+
+// parsePendingUser reads the user_id of a posted approval decision and loads
+// the pending account, or answers the request itself and returns nil.
+func (app *application) parsePendingUser(w http.ResponseWriter, r *http.Request) *User {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return nil
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return nil
+	}
+	id, err := strconv.Atoi(r.PostForm.Get("user_id"))
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return nil
+	}
+	u, err := app.userRepo.GetUserByID(id)
+	if err != nil || u.Status != userStatusPending {
+		app.session.Put(r, "flash", "That account is no longer pending")
+		http.Redirect(w, r, "/admin/users/pending", http.StatusSeeOther)
+		return nil
+	}
+	return u
+}
+
+func (app *application) adminApproveUser(w http.ResponseWriter, r *http.Request) {
+	u := app.parsePendingUser(w, r)
+	if u == nil {
+		return
+	}
+	if err := app.userRepo.SetStatus(u.ID, userStatusActive); err != nil {
+		app.serverError(w, err)
+		return
+	}
+	body := fmt.Sprintf("Hi %s,\n\nyour hnews account has been approved. You can log in at:\n\n%s\n",
+		u.Name, app.absoluteURL("/login"))
+	if err := app.mailer.Send(u.Email, "Your account has been approved", body); err != nil {
+		app.errorLog.Printf("approval email to user %d: %v", u.ID, err)
+	}
+	admin := app.getUserFromContext(r.Context())
+	app.infoLog.Printf("admin %d approved user %d", admin.ID, u.ID)
+	app.session.Put(r, "flash", fmt.Sprintf("Approved %s", u.Email))
+	http.Redirect(w, r, "/admin/users/pending", http.StatusSeeOther)
+}
+
+func (app *application) adminRejectUser(w http.ResponseWriter, r *http.Request) {
+	u := app.parsePendingUser(w, r)
+	if u == nil {
+		return
+	}
+	if err := app.userRepo.DeletePendingUser(u.ID); err != nil {
+		app.serverError(w, err)
+		return
+	}
+	admin := app.getUserFromContext(r.Context())
+	app.infoLog.Printf("admin %d rejected user %d", admin.ID, u.ID)
+	app.session.Put(r, "flash", fmt.Sprintf("Rejected %s", u.Email))
+	http.Redirect(w, r, "/admin/users/pending", http.StatusSeeOther)
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// setRegistrationPolicy applies p to the test app for the rest of the test.
+func setRegistrationPolicy(t *testing.T, p RegistrationPolicy) {
+	previous := testApp.config.registration
+	testApp.config.registration = p
+	t.Cleanup(func() { testApp.config.registration = previous })
+}
+
+func postRegister(form url.Values) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/register", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	testApp.session.Enable(http.HandlerFunc(testApp.register)).ServeHTTP(w, req)
+	return w
+}
+
+func registrationForm(email string) url.Values {
+	return url.Values{
+		"name":     {"New Member"},
+		"email":    {email},
+		"password": {"a long and unusual passphrase 7"},
+	}
+}
+
+func TestParseRegistrationPolicy(t *testing.T) {
+	p, err := ParseRegistrationPolicy(registrationDomains, " Example.com, @corp.example ")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"example.com", "corp.example"}, p.Domains)
+	assert.True(t, p.DomainAllowed("ada@EXAMPLE.com"))
+	assert.False(t, p.DomainAllowed("ada@example.com.evil.test"))
+
+	_, err = ParseRegistrationPolicy(registrationDomains, "")
+	assert.Error(t, err)
+	_, err = ParseRegistrationPolicy("invite", "")
+	assert.Error(t, err)
+}
+
+func TestRegister_Closed(t *testing.T) {
+	defer cleanupTestData(t)
+	setRegistrationPolicy(t, RegistrationPolicy{Mode: registrationClosed})
+
+	w := postRegister(registrationForm("closed@test.com"))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "Registration is closed")
+
+	_, err := testApp.userRepo.GetUserByEmail("closed@test.com")
+	assert.Error(t, err)
+}
+
+func TestRegister_DomainAllowlist(t *testing.T) {
+	defer cleanupTestData(t)
+	setRegistrationPolicy(t, RegistrationPolicy{Mode: registrationDomains, Domains: []string{"corp.example"}})
+
+	w := postRegister(registrationForm("outsider@test.com"))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "Use an email address at corp.example")
+
+	w = postRegister(registrationForm("insider@corp.example"))
+	assert.Equal(t, http.StatusSeeOther, w.Code)
+	_, err := testApp.userRepo.Authenticate("insider@corp.example", "a long and unusual passphrase 7")
+	assert.NoError(t, err)
+}
+
+func TestAuthenticate_RejectsDomainsOutsideAllowlist(t *testing.T) {
+	defer cleanupTestData(t)
+	createTestUser(t, "Old Member", "old@test.com")
+	setRegistrationPolicy(t, RegistrationPolicy{Mode: registrationDomains, Domains: []string{"corp.example"}})
+
+	_, err := testApp.userRepo.Authenticate("old@test.com", "correct horse battery staple")
+	assert.ErrorIs(t, err, ErrEmailDomainRejected)
+}
+
+func TestRegister_ApprovalQueue(t *testing.T) {
+	defer cleanupTestData(t)
+	setRegistrationPolicy(t, RegistrationPolicy{Mode: registrationApproval})
+
+	w := postRegister(registrationForm("queued@test.com"))
+	assert.Equal(t, http.StatusSeeOther, w.Code)
+	assert.Equal(t, "/", w.Header().Get("Location"))
+
+	_, err := testApp.userRepo.Authenticate("queued@test.com", "a long and unusual passphrase 7")
+	assert.ErrorIs(t, err, ErrPendingApproval)
+
+	admin := createTestUser(t, "Admin", "admin@test.com")
+	admin.IsAdmin = true
+	w = serveAs(testApp.adminPendingUsers, admin, http.MethodGet, "/admin/users/pending", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "queued@test.com")
+
+	queued, err := testApp.userRepo.GetUserByEmail("queued@test.com")
+	require.NoError(t, err)
+	w = serveAs(testApp.adminApproveUser, admin, http.MethodPost, "/admin/users/approve",
+		url.Values{"user_id": {strconv.Itoa(queued.ID)}})
+	assert.Equal(t, http.StatusSeeOther, w.Code)
+
+	_, err = testApp.userRepo.Authenticate("queued@test.com", "a long and unusual passphrase 7")
+	assert.NoError(t, err)
+	_, ok := testApp.mailer.(*testMailer).last("queued@test.com")
+	assert.True(t, ok)
+}
+
+func TestAdminRejectUser(t *testing.T) {
+	defer cleanupTestData(t)
+	setRegistrationPolicy(t, RegistrationPolicy{Mode: registrationApproval})
+	pending := createTestUser(t, "Spammer", "spam@test.com")
+	require.Equal(t, userStatusPending, pending.Status)
+
+	admin := &User{ID: 999, IsAdmin: true}
+	w := serveAs(testApp.adminRejectUser, admin, http.MethodPost, "/admin/users/reject",
+		url.Values{"user_id": {strconv.Itoa(pending.ID)}})
+	assert.Equal(t, http.StatusSeeOther, w.Code)
+
+	_, err := testApp.userRepo.GetUserByID(pending.ID)
+	assert.Error(t, err)
+}
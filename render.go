@@ -17,6 +17,19 @@ func (app *application) defaultTemplateData(data *templateData, r *http.Request)
 		data = &templateData{}
 	}
 	data.Flash = app.session.PopString(r, "flash")
+	data.UndoHide = app.session.PopInt(r, undoHideKey)
 	data.IsAuthenticated = app.isAuthenticated(r)
+	data.OIDCEnabled = app.oidc != nil
+	data.ProxyAuth = app.config.authMode == authModeProxy
+	data.CurrentURL = r.URL.RequestURI()
+	data.RegistrationClosed = data.RegistrationClosed || (app.config.registration.Closed() && data.Invitation == nil)
+	if u, ok := r.Context().Value(contextUserKey).(*User); ok {
+		data.IsAdmin = u.IsAdmin
+		n, err := app.searchRepo.CountUnreadAlerts(u.ID)
+		if err != nil {
+			app.errorLog.Printf("counting alerts: %v", err)
+		}
+		data.UnreadAlerts = n
+	}
 	return data
 }
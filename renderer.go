@@ -19,6 +19,8 @@ type TemplateRenderer struct {
 type templateData struct {
 	Form            *Form
 	IsAuthenticated bool
+	OIDCEnabled     bool
+	ProxyAuth       bool
 	Flash           string
 	Posts           []Post
 	Metadata        Metadata
@@ -26,6 +28,59 @@ type templateData struct {
 	Post            *Post
 	NextLink        string
 	PrevLink        string
+	IsAdmin         bool
+	User            *User
+	OAuthClient     *OAuthClient
+	OAuthClients    []OAuthClient
+	OAuthScopes     []string
+	OAuthSecret     string
+	DataExport      *DataExport
+	Users           []*User
+	Member          *User // the user whose profile is shown
+	Inviter         *User
+	InviteTree      []*InviteNode
+	Invitation      *Invitation
+	Invitations     []Invitation
+	CanInvite       bool
+	InviteKarma     int
+	LoginHistory    []LoginEvent
+	LoginEvent      *LoginEvent
+	// CurrentURL is the request URI, for forms that return to the page.
+	CurrentURL    string
+	OwnFavorites  bool
+	FavoritesOpen bool // the favorites are public or the viewer's own
+	ShowComments  bool
+	// UndoHide is the post just hidden, offered for undo in the flash.
+	UndoHide  int
+	Hidden    bool // the posts listed are the viewer's hidden ones
+	Threads   []Thread
+	PageTitle string
+	// Day is the date shown by /front, with the neighbouring days for
+	// navigation; NextDay is empty for today.
+	Day     string
+	Today   string
+	PrevDay string
+	NextDay string
+	// Filter and Window are the listing options of the front page.
+	Filter Filter
+	Window string
+	// UnreadAlerts counts the viewer's unread saved search alerts.
+	UnreadAlerts  int
+	SavedSearches []SavedSearch
+	Alerts        []SearchAlert
+	SiteStats     *SiteStats
+	SiteSanctions []SiteSanction
+	LinkChecks    []LinkCheck
+	Snapshot      *Snapshot
+	// Paragraphs is the text of Snapshot.
+	Paragraphs []string
+	// RegistrationClosed hides the sign-up form and links.
+	RegistrationClosed bool
+	// DeletionGraceDays is how many days a deleted account can be restored.
+	DeletionGraceDays int
+	// HasIdentity is set for users who log in through SSO and may not
+	// know their password.
+	HasIdentity bool
 }
 
 func NewTemplateRenderer(templateDir string, isDev bool) *TemplateRenderer {
@@ -10,19 +10,96 @@ func (app *application) routes() http.Handler {
 	mux := http.NewServeMux()
 
 	defaultMiddleware := alice.New(app.recover, app.logger)
-	secureMiddleware := alice.New(app.session.Enable, app.authenticate)
+	authenticate := app.authenticate
+	if app.config.authMode == authModeProxy {
+		authenticate = app.authenticateProxy
+	}
+	secureMiddleware := alice.New(app.session.Enable, authenticate)
+	adminMiddleware := secureMiddleware.Append(app.requireAuth, app.requireAdmin)
+	apiMiddleware := alice.New(app.authenticateToken)
 
 	mux.Handle("/public/", http.StripPrefix("/public/", http.FileServer(http.Dir(app.publicPath))))
 	mux.Handle("/", secureMiddleware.ThenFunc(app.home))
-	mux.Handle("/login", secureMiddleware.ThenFunc(app.login))
-	mux.Handle("/logout", secureMiddleware.ThenFunc(app.logout))
+	mux.Handle("/front", secureMiddleware.ThenFunc(app.front))
+	mux.Handle("/from", secureMiddleware.ThenFunc(app.from))
 	mux.Handle("/submit", secureMiddleware.Append(app.requireAuth).ThenFunc(app.submit))
+	mux.Handle("/submit/fetch", secureMiddleware.Append(app.requireAuth).ThenFunc(app.submitFetch))
 	mux.Handle("/vote", secureMiddleware.Append(app.requireAuth).ThenFunc(app.vote))
+	mux.Handle("/cached", secureMiddleware.ThenFunc(app.cached))
 	mux.Handle("/comments", secureMiddleware.Append(app.requireAuth).ThenFunc(app.comments))
-	mux.Handle("/register", secureMiddleware.ThenFunc(app.register))
+	mux.Handle("/settings", secureMiddleware.Append(app.requireAuth).ThenFunc(app.settings))
+	mux.Handle("/settings/name", secureMiddleware.Append(app.requireAuth).ThenFunc(app.settingsName))
+	mux.Handle("/settings/email", secureMiddleware.Append(app.requireAuth).ThenFunc(app.settingsEmail))
+	mux.Handle("/settings/email/confirm", secureMiddleware.ThenFunc(app.settingsEmailConfirm))
+	mux.Handle("/settings/password", secureMiddleware.Append(app.requireAuth).ThenFunc(app.settingsPassword))
+	mux.Handle("/settings/avatar", secureMiddleware.Append(app.requireAuth).ThenFunc(app.settingsAvatar))
+	mux.Handle("/settings/export", secureMiddleware.Append(app.requireAuth).ThenFunc(app.settingsExport))
+	mux.Handle("/settings/export/download", secureMiddleware.Append(app.requireAuth).ThenFunc(app.settingsExportDownload))
+	mux.Handle("/settings/delete", secureMiddleware.Append(app.requireAuth).ThenFunc(app.settingsDelete))
+	mux.Handle("/settings/delete/cancel", secureMiddleware.Append(app.requireAuth).ThenFunc(app.settingsDeleteCancel))
+	mux.Handle("/settings/favorites", secureMiddleware.Append(app.requireAuth).ThenFunc(app.settingsFavorites))
+	mux.Handle("/favorite", secureMiddleware.Append(app.requireAuth).ThenFunc(app.favorite))
+	mux.Handle("/favorites", secureMiddleware.ThenFunc(app.favorites))
+	mux.Handle("/hide", secureMiddleware.Append(app.requireAuth).ThenFunc(app.hide))
+	mux.Handle("/hidden", secureMiddleware.Append(app.requireAuth).ThenFunc(app.hidden))
+	mux.HandleFunc("/search/suggest", app.searchSuggest)
+	mux.Handle("/searches", secureMiddleware.Append(app.requireAuth).ThenFunc(app.searches))
+	mux.Handle("/searches/update", secureMiddleware.Append(app.requireAuth).ThenFunc(app.searchUpdate))
+	mux.Handle("/alerts", secureMiddleware.Append(app.requireAuth).ThenFunc(app.alerts))
+	mux.Handle("/user", secureMiddleware.ThenFunc(app.userProfile))
+	mux.Handle("/submitted", secureMiddleware.ThenFunc(app.submitted))
+	mux.Handle("/threads", secureMiddleware.ThenFunc(app.threads))
+	mux.Handle("/upvoted", secureMiddleware.Append(app.requireAuth).ThenFunc(app.upvoted))
+	mux.Handle("/users/tree", secureMiddleware.ThenFunc(app.inviteTree))
+	mux.Handle("/invitations", secureMiddleware.Append(app.requireAuth).ThenFunc(app.invitations))
 	mux.Handle("/about", secureMiddleware.ThenFunc(app.about))
 	mux.Handle("/contact", secureMiddleware.ThenFunc(app.contact))
 
+	mux.Handle("/admin", adminMiddleware.ThenFunc(app.admin))
+	mux.Handle("/admin/users/pending", adminMiddleware.ThenFunc(app.adminPendingUsers))
+	mux.Handle("/admin/users/approve", adminMiddleware.ThenFunc(app.adminApproveUser))
+	mux.Handle("/admin/users/reject", adminMiddleware.ThenFunc(app.adminRejectUser))
+	mux.Handle("/admin/users/ban-branch", adminMiddleware.ThenFunc(app.adminBanBranch))
+	mux.Handle("/admin/oauth/clients", adminMiddleware.ThenFunc(app.adminOAuthClients))
+	mux.Handle("/admin/sites", adminMiddleware.ThenFunc(app.adminSites))
+	mux.Handle("/admin/links", adminMiddleware.ThenFunc(app.adminLinks))
+
+	mux.Handle("/oauth/authorize", secureMiddleware.ThenFunc(app.oauthAuthorize))
+	mux.HandleFunc("/oauth/token", app.oauthToken)
+	mux.HandleFunc("/oauth/introspect", app.oauthIntrospect)
+
+	mux.Handle("/api/me", apiMiddleware.Append(app.requireScope("read")).ThenFunc(app.apiMe))
+	mux.Handle("/api/posts", apiMiddleware.Append(app.requireScope("read")).ThenFunc(app.apiPosts))
+	mux.Handle("/api/vote", apiMiddleware.Append(app.requireScope("write")).ThenFunc(app.apiVote))
+
+	if app.config.authMode == authModeProxy {
+		// Accounts are managed by the proxy; local sign-in must not bypass it.
+		mux.Handle("/login", http.NotFoundHandler())
+		mux.Handle("/logout", http.NotFoundHandler())
+		mux.Handle("/register", http.NotFoundHandler())
+		mux.Handle("/login/not-me", http.NotFoundHandler())
+		mux.Handle("/password/reset", http.NotFoundHandler())
+		mux.Handle("/password/forgot", http.NotFoundHandler())
+	} else {
+		mux.Handle("/login", secureMiddleware.ThenFunc(app.login))
+		mux.Handle("/logout", secureMiddleware.ThenFunc(app.logout))
+		mux.Handle("/register", secureMiddleware.ThenFunc(app.register))
+		mux.Handle("/login/not-me", secureMiddleware.ThenFunc(app.loginNotMe))
+		mux.Handle("/password/reset", secureMiddleware.ThenFunc(app.passwordReset))
+		mux.Handle("/password/forgot", secureMiddleware.ThenFunc(app.passwordForgot))
+	}
+
+	if app.config.scimToken != "" {
+		scimMiddleware := alice.New(app.requireSCIMToken)
+		mux.Handle("/scim/v2/Users", scimMiddleware.ThenFunc(app.scimUsers))
+		mux.Handle("/scim/v2/Users/", scimMiddleware.ThenFunc(app.scimUser))
+	}
+
+	if app.oidc != nil {
+		mux.Handle("/auth/oidc/login", secureMiddleware.ThenFunc(app.oidcLogin))
+		mux.Handle("/auth/oidc/callback", secureMiddleware.ThenFunc(app.oidcCallback))
+	}
+
 	// handler := app.recover(app.logger(app.session.Enable(mux)))
 	return defaultMiddleware.Then(mux)
 }
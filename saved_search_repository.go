@@ -0,0 +1,346 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var (
+	ErrDuplicateSearch = errors.New("you already saved this search")
+	ErrTooManySearches = errors.New("you cannot save any more searches")
+	ErrSearchNotFound  = errors.New("search not found")
+)
+
+// maxSavedSearches is how many searches a user can save.
+const maxSavedSearches = 20
+
+// SavedSearch is a search query a user is alerted about. New posts and
+// comments are matched when their id is past LastPostID and LastCommentID,
+// so items created before the search was saved never alert.
+type SavedSearch struct {
+	ID            int        `json:"-"`
+	UserID        int        `json:"-"`
+	Query         string     `json:"query"`
+	Email         bool       `json:"email"` // a daily email of new alerts
+	LastPostID    int        `json:"-"`
+	LastCommentID int        `json:"-"`
+	EmailedAt     *time.Time `json:"-"`
+	CreatedAt     time.Time  `json:"created_at"`
+}
+
+// SearchAlert is a post, or a comment on it, that matched a saved search.
+// CommentID is 0 for posts.
+type SearchAlert struct {
+	ID          int
+	SearchID    int
+	Query       string
+	PostID      int
+	PostTitle   string
+	CommentID   int
+	CommentBody string
+	Read        bool
+	CreatedAt   time.Time
+}
+
+type SavedSearchRepository interface {
+	SaveSearch(userID int, query string, email bool) (int, error)
+	GetSavedSearches(userID int) ([]SavedSearch, error)
+	SetSearchEmail(userID, searchID int, email bool) error
+	DeleteSavedSearch(userID, searchID int) error
+	MatchSavedSearches() (int, error)
+	GetAlerts(userID, limit int) ([]SearchAlert, error)
+	CountUnreadAlerts(userID int) (int, error)
+	MarkAlertsRead(userID int) error
+	GetSearchesToEmail(emailedBefore time.Time) ([]SavedSearch, error)
+	GetUnemailedAlerts(searchID int) ([]SearchAlert, error)
+	MarkSearchEmailed(searchID int, at time.Time) error
+}
+
+type SQLSavedSearchRepository struct {
+	db *sql.DB
+}
+
+// NewSQLSavedSearchRepository creates a new instance of SQLSavedSearchRepository
+func NewSQLSavedSearchRepository(db *sql.DB) *SQLSavedSearchRepository {
+	return &SQLSavedSearchRepository{db: db}
+}
+
+const savedSearchSelect = `SELECT s.id, s.user_id, s.query, s.email, s.last_post_id, s.last_comment_id,
+	s.emailed_at, s.created_at FROM saved_searches s`
+
+func scanSavedSearch(row rowScanner) (*SavedSearch, error) {
+	var s SavedSearch
+	var emailedAt sql.NullTime
+	err := row.Scan(&s.ID, &s.UserID, &s.Query, &s.Email, &s.LastPostID, &s.LastCommentID, &emailedAt, &s.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if emailedAt.Valid {
+		s.EmailedAt = &emailedAt.Time
+	}
+	return &s, nil
+}
+
+func (r *SQLSavedSearchRepository) querySavedSearches(query string, args ...interface{}) ([]SavedSearch, error) {
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var searches []SavedSearch
+	for rows.Next() {
+		s, err := scanSavedSearch(rows)
+		if err != nil {
+			return nil, err
+		}
+		searches = append(searches, *s)
+	}
+	return searches, rows.Err()
+}
+
+// SaveSearch saves query for the user. Only posts and comments created from
+// now on are matched against it.
+func (r *SQLSavedSearchRepository) SaveSearch(userID int, query string, email bool) (int, error) {
+	var count int
+	if err := r.db.QueryRow("SELECT COUNT(*) FROM saved_searches WHERE user_id = ?", userID).Scan(&count); err != nil {
+		return 0, err
+	}
+	if count >= maxSavedSearches {
+		return 0, ErrTooManySearches
+	}
+
+	stmt := `INSERT INTO saved_searches (user_id, query, email, last_post_id, last_comment_id)
+		VALUES (?, ?, ?, (SELECT COALESCE(MAX(id), 0) FROM posts), (SELECT COALESCE(MAX(id), 0) FROM comments))`
+	res, err := r.db.Exec(stmt, userID, query, email)
+	if err != nil {
+		if strings.Contains(err.Error(), "UNIQUE constraint failed") {
+			return 0, ErrDuplicateSearch
+		}
+		return 0, err
+	}
+	id, err := res.LastInsertId()
+	return int(id), err
+}
+
+func (r *SQLSavedSearchRepository) GetSavedSearches(userID int) ([]SavedSearch, error) {
+	return r.querySavedSearches(savedSearchSelect+" WHERE s.user_id = ? ORDER BY s.created_at, s.id", userID)
+}
+
+// SetSearchEmail turns the daily email of one of the user's searches on or
+// off, or returns ErrSearchNotFound if it is not theirs.
+func (r *SQLSavedSearchRepository) SetSearchEmail(userID, searchID int, email bool) error {
+	res, err := r.db.Exec("UPDATE saved_searches SET email = ? WHERE id = ? AND user_id = ?", email, searchID, userID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrSearchNotFound
+	}
+	return nil
+}
+
+// DeleteSavedSearch deletes one of the user's searches with its alerts, or
+// returns ErrSearchNotFound if it is not theirs.
+func (r *SQLSavedSearchRepository) DeleteSavedSearch(userID, searchID int) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec("DELETE FROM saved_searches WHERE id = ? AND user_id = ?", searchID, userID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrSearchNotFound
+	}
+	if _, err := tx.Exec("DELETE FROM search_alerts WHERE search_id = ?", searchID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// MatchSavedSearches alerts the owners of saved searches to the posts and
+// comments created since the searches were last matched, leaving out their
+// own. Comments are only matched by searches for words or phrases, which
+// are looked for in the comment body. It returns how many alerts it added.
+// A search that fails to match does not hold up the others: its error is
+// returned along with those of any other failed searches.
+func (r *SQLSavedSearchRepository) MatchSavedSearches() (int, error) {
+	var lastPost, lastComment int
+	err := r.db.QueryRow("SELECT (SELECT COALESCE(MAX(id), 0) FROM posts), (SELECT COALESCE(MAX(id), 0) FROM comments)").
+		Scan(&lastPost, &lastComment)
+	if err != nil {
+		return 0, err
+	}
+
+	searches, err := r.querySavedSearches(savedSearchSelect+` INNER JOIN users u ON u.id = s.user_id
+		WHERE u.status = ? AND (s.last_post_id < ? OR s.last_comment_id < ?)`, userStatusActive, lastPost, lastComment)
+	if err != nil {
+		return 0, err
+	}
+
+	var added int
+	var errs []error
+	for _, s := range searches {
+		n, err := r.matchSavedSearch(s, lastPost, lastComment)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("saved search %d: %w", s.ID, err))
+			continue
+		}
+		added += n
+	}
+	return added, errors.Join(errs...)
+}
+
+// matchSavedSearch matches s against the posts and comments up to lastPost
+// and lastComment and moves its cursors there.
+func (r *SQLSavedSearchRepository) matchSavedSearch(s SavedSearch, lastPost, lastComment int) (int, error) {
+	query, err := ParseSearch(s.Query)
+	if err != nil {
+		// Searches are parsed when saved, so this one was saved by an older
+		// version of the parser. It is moved past the new items so that it
+		// is reported once for them rather than on every run.
+		stmt := "UPDATE saved_searches SET last_post_id = ?, last_comment_id = ? WHERE id = ?"
+		if _, uerr := r.db.Exec(stmt, lastPost, lastComment, s.ID); uerr != nil {
+			return 0, uerr
+		}
+		return 0, err
+	}
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	where, args := query.where(postSearch)
+	stmt := `INSERT INTO search_alerts (search_id, user_id, post_id)
+		SELECT ?, ?, p.id FROM posts p LEFT JOIN users u ON u.id = p.user_id
+		WHERE p.id > ? AND p.id <= ? AND p.user_id != ?`
+	for _, cond := range where {
+		stmt += " AND " + cond
+	}
+	args = append([]interface{}{s.ID, s.UserID, s.LastPostID, lastPost, s.UserID}, args...)
+	res, err := tx.Exec(stmt, args...)
+	if err != nil {
+		return 0, err
+	}
+	added, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	if query.HasText() {
+		where, args := query.where(commentSearch)
+		stmt := `INSERT INTO search_alerts (search_id, user_id, post_id, comment_id)
+			SELECT ?, ?, cm.post_id, cm.id FROM comments cm
+			INNER JOIN posts p ON p.id = cm.post_id
+			LEFT JOIN users cu ON cu.id = cm.user_id
+			WHERE cm.id > ? AND cm.id <= ? AND cm.user_id != ?`
+		for _, cond := range where {
+			stmt += " AND " + cond
+		}
+		args = append([]interface{}{s.ID, s.UserID, s.LastCommentID, lastComment, s.UserID}, args...)
+		res, err := tx.Exec(stmt, args...)
+		if err != nil {
+			return 0, err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return 0, err
+		}
+		added += n
+	}
+
+	stmt = "UPDATE saved_searches SET last_post_id = ?, last_comment_id = ? WHERE id = ?"
+	if _, err := tx.Exec(stmt, lastPost, lastComment, s.ID); err != nil {
+		return 0, err
+	}
+	return int(added), tx.Commit()
+}
+
+const searchAlertSelect = `SELECT a.id, a.search_id, s.query, a.post_id, p.title,
+	COALESCE(a.comment_id, 0), COALESCE(cm.body, ''), a.read_at IS NOT NULL, a.created_at
+	FROM search_alerts a
+	INNER JOIN saved_searches s ON s.id = a.search_id
+	INNER JOIN posts p ON p.id = a.post_id
+	LEFT JOIN comments cm ON cm.id = a.comment_id`
+
+func (r *SQLSavedSearchRepository) queryAlerts(query string, args ...interface{}) ([]SearchAlert, error) {
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var alerts []SearchAlert
+	for rows.Next() {
+		var a SearchAlert
+		err := rows.Scan(&a.ID, &a.SearchID, &a.Query, &a.PostID, &a.PostTitle,
+			&a.CommentID, &a.CommentBody, &a.Read, &a.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		alerts = append(alerts, a)
+	}
+	return alerts, rows.Err()
+}
+
+// GetAlerts returns the user's latest alerts, newest first.
+func (r *SQLSavedSearchRepository) GetAlerts(userID, limit int) ([]SearchAlert, error) {
+	return r.queryAlerts(searchAlertSelect+" WHERE a.user_id = ? ORDER BY a.id DESC LIMIT ?", userID, limit)
+}
+
+func (r *SQLSavedSearchRepository) CountUnreadAlerts(userID int) (int, error) {
+	var n int
+	err := r.db.QueryRow("SELECT COUNT(*) FROM search_alerts WHERE user_id = ? AND read_at IS NULL", userID).Scan(&n)
+	return n, err
+}
+
+func (r *SQLSavedSearchRepository) MarkAlertsRead(userID int) error {
+	_, err := r.db.Exec("UPDATE search_alerts SET read_at = CURRENT_TIMESTAMP WHERE user_id = ? AND read_at IS NULL", userID)
+	return err
+}
+
+// GetSearchesToEmail returns the searches of active users that want email,
+// were last emailed before emailedBefore (or never) and have alerts that
+// were not emailed yet.
+func (r *SQLSavedSearchRepository) GetSearchesToEmail(emailedBefore time.Time) ([]SavedSearch, error) {
+	return r.querySavedSearches(savedSearchSelect+` INNER JOIN users u ON u.id = s.user_id
+		WHERE s.email = 1 AND u.status = ? AND (s.emailed_at IS NULL OR s.emailed_at < ?)
+		AND EXISTS(SELECT 1 FROM search_alerts a WHERE a.search_id = s.id AND a.emailed_at IS NULL)
+		ORDER BY s.id`, userStatusActive, emailedBefore)
+}
+
+// GetUnemailedAlerts returns the alerts of a search that were not emailed
+// yet, oldest first.
+func (r *SQLSavedSearchRepository) GetUnemailedAlerts(searchID int) ([]SearchAlert, error) {
+	return r.queryAlerts(searchAlertSelect+" WHERE a.search_id = ? AND a.emailed_at IS NULL ORDER BY a.id", searchID)
+}
+
+// MarkSearchEmailed records that the search's alerts were emailed at at.
+func (r *SQLSavedSearchRepository) MarkSearchEmailed(searchID int, at time.Time) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("UPDATE search_alerts SET emailed_at = ? WHERE search_id = ? AND emailed_at IS NULL", at, searchID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE saved_searches SET emailed_at = ? WHERE id = ?", at, searchID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
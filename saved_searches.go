@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// savedSearchInterval is how often new posts and comments are matched
+	// against saved searches.
+	savedSearchInterval = 5 * time.Minute
+	// alertEmailInterval is how often a saved search emails its new alerts.
+	alertEmailInterval = 24 * time.Hour
+	// alertsShown is how many alerts /alerts lists.
+	alertsShown = 50
+)
+
+// searches lists the user's saved searches and saves a new one on POST. The
+// save button of the search form posts here too.
+func (app *application) searches(w http.ResponseWriter, r *http.Request) {
+	u := app.getUserFromContext(r.Context())
+	form := NewForm(nil)
+
+	if r.Method == http.MethodPost {
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+		form = NewForm(r.PostForm)
+		form.Required("query").MaxLength("query", 255)
+		query := strings.TrimSpace(form.Get("query"))
+		if form.Valid() {
+			if _, err := ParseSearch(query); err != nil {
+				form.Errors.Add("query", err.Error())
+			}
+		}
+
+		if form.Valid() {
+			_, err := app.searchRepo.SaveSearch(u.ID, query, form.Get("email") != "")
+			if errors.Is(err, ErrDuplicateSearch) || errors.Is(err, ErrTooManySearches) {
+				form.Errors.Add("query", err.Error())
+			} else if err != nil {
+				app.serverError(w, err)
+				return
+			} else {
+				app.session.Put(r, "flash", fmt.Sprintf("We will alert you to new posts and comments matching %q", query))
+				http.Redirect(w, r, "/searches", http.StatusSeeOther)
+				return
+			}
+		}
+		w.WriteHeader(http.StatusBadRequest)
+	}
+
+	searches, err := app.searchRepo.GetSavedSearches(u.ID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	app.render(w, r, "searches.html", &templateData{Form: form, SavedSearches: searches})
+}
+
+// searchUpdate changes one of the user's saved searches: it deletes it
+// when delete is set, or else turns its daily email on or off.
+func (app *application) searchUpdate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	id, err := strconv.Atoi(r.PostForm.Get("id"))
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	u := app.getUserFromContext(r.Context())
+
+	var flash string
+	if r.PostForm.Get("delete") != "" {
+		err = app.searchRepo.DeleteSavedSearch(u.ID, id)
+		flash = "The search was deleted"
+	} else {
+		email := r.PostForm.Get("email") != ""
+		err = app.searchRepo.SetSearchEmail(u.ID, id, email)
+		flash = "You will no longer get email for this search"
+		if email {
+			flash = "You will get a daily email of new matches"
+		}
+	}
+	if errors.Is(err, ErrSearchNotFound) {
+		flash = err.Error()
+	} else if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	app.session.Put(r, "flash", flash)
+	http.Redirect(w, r, "/searches", http.StatusSeeOther)
+}
+
+// alerts lists the posts and comments that matched the user's saved
+// searches and marks them read.
+func (app *application) alerts(w http.ResponseWriter, r *http.Request) {
+	u := app.getUserFromContext(r.Context())
+	alerts, err := app.searchRepo.GetAlerts(u.ID, alertsShown)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	if err := app.searchRepo.MarkAlertsRead(u.ID); err != nil {
+		app.serverError(w, err)
+		return
+	}
+	app.render(w, r, "alerts.html", &templateData{Alerts: alerts})
+}
+
+// matchSavedSearches alerts users to new posts and comments matching their
+// saved searches.
+func (app *application) matchSavedSearches(ctx context.Context) error {
+	n, err := app.searchRepo.MatchSavedSearches()
+	if n > 0 {
+		app.infoLog.Printf("added %d search alerts", n)
+	}
+	return err
+}
+
+// emailSearchAlerts emails each saved search's new alerts to its owner, at
+// most once every alertEmailInterval. A failed email is logged and retried on
+// the next run; it does not hold up the others.
+func (app *application) emailSearchAlerts(ctx context.Context) error {
+	now := time.Now().UTC()
+	searches, err := app.searchRepo.GetSearchesToEmail(now.Add(-alertEmailInterval))
+	if err != nil {
+		return err
+	}
+	failed := 0
+	for _, s := range searches {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err := app.emailSearchAlert(s, now); err != nil {
+			app.errorLog.Printf("emailing alerts of saved search %d: %v", s.ID, err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d alert emails failed", failed, len(searches))
+	}
+	return nil
+}
+
+// emailSearchAlert emails the new alerts of s to its owner.
+func (app *application) emailSearchAlert(s SavedSearch, now time.Time) error {
+	u, err := app.userRepo.GetUserByID(s.UserID)
+	if err != nil {
+		return err
+	}
+	alerts, err := app.searchRepo.GetUnemailedAlerts(s.ID)
+	if err != nil {
+		return err
+	}
+
+	var body strings.Builder
+	fmt.Fprintf(&body, "Hi %s,\n\nthere are new matches for your saved search %q:\n\n", u.Name, s.Query)
+	for _, a := range alerts {
+		link := app.absoluteURL(fmt.Sprintf("/comments?post_id=%d", a.PostID))
+		if a.CommentID != 0 {
+			fmt.Fprintf(&body, "- a comment on %s\n  %s\n", a.PostTitle, link)
+		} else {
+			fmt.Fprintf(&body, "- %s\n  %s\n", a.PostTitle, link)
+		}
+	}
+	fmt.Fprintf(&body, "\nTo stop these emails, change the search at %s\n", app.absoluteURL("/searches"))
+	if err := app.mailer.Send(u.Email, fmt.Sprintf("New matches for %q", s.Query), body.String()); err != nil {
+		return err
+	}
+	return app.searchRepo.MarkSearchEmailed(s.ID, now)
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMatchSavedSearches(t *testing.T) {
+	defer cleanupTestData(t)
+	watcher := createTestUser(t, "Watcher", "watcher@test.com")
+	poster := createTestUser(t, "Poster", "poster@test.com")
+	before, err := testApp.postRepo.CreatePost("Acme before saving", "https://acme.com/old", poster.ID)
+	require.NoError(t, err)
+
+	_, err = testApp.searchRepo.SaveSearch(watcher.ID, "acme", false)
+	require.NoError(t, err)
+	_, err = testApp.searchRepo.SaveSearch(watcher.ID, "site:acme.com", false)
+	require.NoError(t, err)
+	_, err = testApp.searchRepo.SaveSearch(watcher.ID, "acme", true)
+	assert.ErrorIs(t, err, ErrDuplicateSearch)
+
+	post, err := testApp.postRepo.CreatePost("Acme launches", "https://news.com/acme", poster.ID)
+	require.NoError(t, err)
+	_, err = testApp.postRepo.CreatePost("Acme by the watcher", "https://acme.com/mine", watcher.ID)
+	require.NoError(t, err)
+	_, err = testApp.postRepo.CreatePost("Unrelated", "https://other.com", poster.ID)
+	require.NoError(t, err)
+	_, err = testApp.postRepo.AddComment(poster.ID, before, "I prefer Acme")
+	require.NoError(t, err)
+	_, err = testApp.postRepo.AddComment(poster.ID, before, "Nothing to see")
+	require.NoError(t, err)
+
+	n, err := testApp.searchRepo.MatchSavedSearches()
+	require.NoError(t, err)
+	// "acme" matches the post and a comment; site:acme.com only matches
+	// posts, and the only new one is the watcher's own.
+	assert.Equal(t, 2, n)
+
+	alerts, err := testApp.searchRepo.GetAlerts(watcher.ID, 10)
+	require.NoError(t, err)
+	require.Len(t, alerts, 2)
+	assert.Equal(t, "I prefer Acme", alerts[0].CommentBody)
+	assert.Equal(t, before, alerts[0].PostID)
+	assert.Equal(t, post, alerts[1].PostID)
+	assert.Zero(t, alerts[1].CommentID)
+
+	n, err = testApp.searchRepo.MatchSavedSearches()
+	require.NoError(t, err)
+	assert.Zero(t, n, "items are only matched once")
+
+	unread, err := testApp.searchRepo.CountUnreadAlerts(watcher.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 2, unread)
+	w := serveAs(testApp.alerts, watcher, http.MethodGet, "/alerts", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "Acme launches")
+	unread, err = testApp.searchRepo.CountUnreadAlerts(watcher.ID)
+	require.NoError(t, err)
+	assert.Zero(t, unread)
+}
+
+func TestEmailSearchAlerts(t *testing.T) {
+	defer cleanupTestData(t)
+	watcher := createTestUser(t, "Watcher", "mailwatcher@test.com")
+	poster := createTestUser(t, "Poster", "poster@test.com")
+	quiet, err := testApp.searchRepo.SaveSearch(watcher.ID, "widgets", false)
+	require.NoError(t, err)
+	_, err = testApp.searchRepo.SaveSearch(watcher.ID, "gadgets", true)
+	require.NoError(t, err)
+
+	_, err = testApp.postRepo.CreatePost("New gadgets and widgets", "https://gadgets.com", poster.ID)
+	require.NoError(t, err)
+	_, err = testApp.searchRepo.MatchSavedSearches()
+	require.NoError(t, err)
+
+	mailer := testApp.mailer.(*testMailer)
+	sent := len(mailer.messages)
+	require.NoError(t, testApp.emailSearchAlerts(context.Background()))
+	mail, ok := mailer.last("mailwatcher@test.com")
+	require.True(t, ok)
+	assert.Equal(t, `New matches for "gadgets"`, mail.Subject)
+	assert.Contains(t, mail.Body, "New gadgets and widgets")
+	assert.Equal(t, sent+1, len(mailer.messages), "searches without email are not sent")
+
+	_, err = testApp.postRepo.CreatePost("More gadgets", "https://gadgets.com/more", poster.ID)
+	require.NoError(t, err)
+	_, err = testApp.searchRepo.MatchSavedSearches()
+	require.NoError(t, err)
+	require.NoError(t, testApp.emailSearchAlerts(context.Background()))
+	assert.Equal(t, sent+1, len(mailer.messages), "at most one email a day")
+
+	assert.ErrorIs(t, testApp.searchRepo.SetSearchEmail(poster.ID, quiet, true), ErrSearchNotFound)
+}
+
+func TestSearchesHandlers(t *testing.T) {
+	defer cleanupTestData(t)
+	u := createTestUser(t, "Searcher", "searcher@test.com")
+	other := createTestUser(t, "Other", "other@test.com")
+
+	w := serveAs(testApp.searches, u, http.MethodPost, "/searches", url.Values{"query": {`"unclosed`}})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "missing closing quote")
+
+	w = serveAs(testApp.searches, u, http.MethodPost, "/searches", url.Values{"query": {"golang"}, "email": {"1"}})
+	assert.Equal(t, http.StatusSeeOther, w.Code)
+	searches, err := testApp.searchRepo.GetSavedSearches(u.ID)
+	require.NoError(t, err)
+	require.Len(t, searches, 1)
+	assert.Equal(t, "golang", searches[0].Query)
+	assert.True(t, searches[0].Email)
+
+	id := searches[0].ID
+	serveAs(testApp.searchUpdate, other, http.MethodPost, "/searches/update", url.Values{"id": {strconv.Itoa(id)}, "delete": {"1"}})
+	searches, err = testApp.searchRepo.GetSavedSearches(u.ID)
+	require.NoError(t, err)
+	assert.Len(t, searches, 1, "other users cannot delete the search")
+
+	serveAs(testApp.searchUpdate, u, http.MethodPost, "/searches/update", url.Values{"id": {strconv.Itoa(id)}})
+	searches, err = testApp.searchRepo.GetSavedSearches(u.ID)
+	require.NoError(t, err)
+	assert.False(t, searches[0].Email)
+
+	serveAs(testApp.searchUpdate, u, http.MethodPost, "/searches/update", url.Values{"id": {strconv.Itoa(id)}, "delete": {"1"}})
+	searches, err = testApp.searchRepo.GetSavedSearches(u.ID)
+	require.NoError(t, err)
+	assert.Empty(t, searches)
+}
+
+func TestMatchSavedSearches_SkipsUnparsableSearch(t *testing.T) {
+	defer cleanupTestData(t)
+	watcher := createTestUser(t, "Watcher", "watcher@test.com")
+	poster := createTestUser(t, "Poster", "poster@test.com")
+	bad, err := testApp.searchRepo.SaveSearch(watcher.ID, "acme", false)
+	require.NoError(t, err)
+	_, err = testDB.Exec("UPDATE saved_searches SET query = 'site:' WHERE id = ?", bad)
+	require.NoError(t, err)
+	_, err = testApp.searchRepo.SaveSearch(watcher.ID, "widgets", false)
+	require.NoError(t, err)
+
+	_, err = testApp.postRepo.CreatePost("New widgets", "https://widgets.com", poster.ID)
+	require.NoError(t, err)
+	n, err := testApp.searchRepo.MatchSavedSearches()
+	assert.ErrorIs(t, err, ErrInvalidSearch)
+	assert.Equal(t, 1, n, "the other search still matches")
+
+	n, err = testApp.searchRepo.MatchSavedSearches()
+	assert.NoError(t, err, "the bad search is not retried for the same items")
+	assert.Zero(t, n)
+}
+
+// failingMailer fails to deliver to one address.
+type failingMailer struct {
+	Mailer
+	failTo string
+}
+
+func (m failingMailer) Send(to, subject, body string) error {
+	if to == m.failTo {
+		return errors.New("mailbox unavailable")
+	}
+	return m.Mailer.Send(to, subject, body)
+}
+
+func TestEmailSearchAlerts_ContinuesAfterFailure(t *testing.T) {
+	defer cleanupTestData(t)
+	bounced := createTestUser(t, "Bounced", "bounced@test.com")
+	reader := createTestUser(t, "Reader", "reader@test.com")
+	poster := createTestUser(t, "Poster", "poster@test.com")
+	for _, u := range []*User{bounced, reader} {
+		_, err := testApp.searchRepo.SaveSearch(u.ID, "gadgets", true)
+		require.NoError(t, err)
+	}
+	_, err := testApp.postRepo.CreatePost("New gadgets", "https://gadgets.com", poster.ID)
+	require.NoError(t, err)
+	_, err = testApp.searchRepo.MatchSavedSearches()
+	require.NoError(t, err)
+
+	mailer := testApp.mailer
+	testApp.mailer = failingMailer{Mailer: mailer, failTo: bounced.Email}
+	defer func() { testApp.mailer = mailer }()
+
+	assert.Error(t, testApp.emailSearchAlerts(context.Background()))
+	_, ok := mailer.(*testMailer).last(reader.Email)
+	assert.True(t, ok)
+}
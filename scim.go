@@ -0,0 +1,433 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	scimUserSchema  = "urn:ietf:params:scim:schemas:core:2.0:User"
+	scimListSchema  = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	scimPatchSchema = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+	scimErrorSchema = "urn:ietf:params:scim:api:messages:2.0:Error"
+
+	// scimMaxResults caps the page size of user listings.
+	scimMaxResults = 200
+	scimMaxBody    = 1 << 20
+)
+
+// scimUser is the SCIM 2.0 representation of an account. userName is the
+// email address; the password is only ever read.
+type scimUser struct {
+	Schemas     []string    `json:"schemas"`
+	ID          string      `json:"id,omitempty"`
+	UserName    string      `json:"userName"`
+	Name        *scimName   `json:"name,omitempty"`
+	DisplayName string      `json:"displayName,omitempty"`
+	Emails      []scimEmail `json:"emails,omitempty"`
+	Active      *bool       `json:"active,omitempty"`
+	Password    string      `json:"password,omitempty"`
+	Meta        *scimMeta   `json:"meta,omitempty"`
+}
+
+type scimName struct {
+	Formatted  string `json:"formatted,omitempty"`
+	GivenName  string `json:"givenName,omitempty"`
+	FamilyName string `json:"familyName,omitempty"`
+}
+
+type scimEmail struct {
+	Value   string `json:"value"`
+	Primary bool   `json:"primary,omitempty"`
+}
+
+type scimMeta struct {
+	ResourceType string    `json:"resourceType"`
+	Created      time.Time `json:"created"`
+	Location     string    `json:"location"`
+}
+
+type scimPatch struct {
+	Schemas    []string `json:"schemas"`
+	Operations []struct {
+		Op    string          `json:"op"`
+		Path  string          `json:"path"`
+		Value json.RawMessage `json:"value"`
+	} `json:"Operations"`
+}
+
+func (app *application) toSCIMUser(u *User) scimUser {
+	active := u.Status == userStatusActive
+	return scimUser{
+		Schemas:     []string{scimUserSchema},
+		ID:          strconv.Itoa(u.ID),
+		UserName:    u.Email,
+		Name:        &scimName{Formatted: u.Name},
+		DisplayName: u.Name,
+		Emails:      []scimEmail{{Value: u.Email, Primary: true}},
+		Active:      &active,
+		Meta: &scimMeta{
+			ResourceType: "User",
+			Created:      u.CreatedAt,
+			Location:     app.absoluteURL("/scim/v2/Users/" + strconv.Itoa(u.ID)),
+		},
+	}
+}
+
+// email returns the address of a provisioned user: the primary email if
+// there is one, otherwise the userName.
+func (s scimUser) email() string {
+	for _, e := range s.Emails {
+		if e.Primary {
+			return strings.TrimSpace(e.Value)
+		}
+	}
+	if strings.Contains(s.UserName, "@") || len(s.Emails) == 0 {
+		return strings.TrimSpace(s.UserName)
+	}
+	return strings.TrimSpace(s.Emails[0].Value)
+}
+
+// displayName returns the name to show for a provisioned user.
+func (s scimUser) displayName() string {
+	if s.DisplayName != "" {
+		return strings.TrimSpace(s.DisplayName)
+	}
+	if s.Name != nil {
+		if s.Name.Formatted != "" {
+			return strings.TrimSpace(s.Name.Formatted)
+		}
+		if name := strings.TrimSpace(s.Name.GivenName + " " + s.Name.FamilyName); name != "" {
+			return name
+		}
+	}
+	name, _, _ := strings.Cut(s.email(), "@")
+	return name
+}
+
+// scimFilterRX matches the filters directories use to look up accounts:
+// a single attribute compared with eq.
+var scimFilterRX = regexp.MustCompile(`(?i)^\s*([a-z.]+)\s+eq\s+(?:"((?:[^"\\]|\\.)*)"|(true|false))\s*$`)
+
+// parseSCIMFilter turns a filter on userName, emails.value or active into a
+// user query.
+func parseSCIMFilter(filter string) (UserQuery, error) {
+	var q UserQuery
+	if strings.TrimSpace(filter) == "" {
+		return q, nil
+	}
+	m := scimFilterRX.FindStringSubmatch(filter)
+	if m == nil {
+		return q, fmt.Errorf("unsupported filter %q", filter)
+	}
+	attr, value, literal := strings.ToLower(m[1]), m[2], strings.ToLower(m[3])
+	switch {
+	case (attr == "username" || attr == "emails.value" || attr == "emails") && literal == "":
+		q.Email = strings.NewReplacer(`\"`, `"`, `\\`, `\`).Replace(value)
+	case attr == "active" && literal != "":
+		active := literal == "true"
+		q.Active = &active
+	default:
+		return q, fmt.Errorf("unsupported filter %q", filter)
+	}
+	return q, nil
+}
+
+// requireSCIMToken rejects requests that do not carry the SCIM bearer token
+// configured by the administrator.
+func (app *application) requireSCIMToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		scheme, token, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+		want := sha256.Sum256([]byte(app.config.scimToken))
+		got := sha256.Sum256([]byte(strings.TrimSpace(token)))
+		if !strings.EqualFold(scheme, "Bearer") || subtle.ConstantTimeCompare(want[:], got[:]) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="hnews-scim"`)
+			app.scimError(w, http.StatusUnauthorized, "", "a valid bearer token is required")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (app *application) scimJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/scim+json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		app.errorLog.Printf("error encoding json: %s", err)
+	}
+}
+
+func (app *application) scimError(w http.ResponseWriter, status int, scimType, detail string) {
+	body := map[string]any{
+		"schemas": []string{scimErrorSchema},
+		"status":  strconv.Itoa(status),
+		"detail":  detail,
+	}
+	if scimType != "" {
+		body["scimType"] = scimType
+	}
+	app.scimJSON(w, status, body)
+}
+
+// scimServerError logs err like serverError but answers with a SCIM error
+// body, which directory clients expect for every failure.
+func (app *application) scimServerError(w http.ResponseWriter, err error) {
+	app.errorLog.Output(2, fmt.Sprintf("%s\n%s", err.Error(), debug.Stack()))
+	app.scimError(w, http.StatusInternalServerError, "", http.StatusText(http.StatusInternalServerError))
+}
+
+// scimUsers serves the /scim/v2/Users collection: listing and creation.
+func (app *application) scimUsers(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		app.scimListUsers(w, r)
+	case http.MethodPost:
+		app.scimCreateUser(w, r)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		app.scimError(w, http.StatusMethodNotAllowed, "", "use GET or POST")
+	}
+}
+
+// scimUser serves a single /scim/v2/Users/{id} resource.
+func (app *application) scimUser(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/scim/v2/Users/"))
+	if err != nil {
+		app.scimError(w, http.StatusNotFound, "", "user not found")
+		return
+	}
+	u, err := app.userRepo.GetUserByID(id)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && u.Status == userStatusDeleted) {
+		app.scimError(w, http.StatusNotFound, "", "user not found")
+		return
+	} else if err != nil {
+		app.scimServerError(w, err)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		app.scimJSON(w, http.StatusOK, app.toSCIMUser(u))
+	case http.MethodPatch:
+		app.scimPatchUser(w, r, u)
+	case http.MethodDelete:
+		if err := app.userRepo.DeleteUser(u.ID); err != nil {
+			app.scimServerError(w, err)
+			return
+		}
+		app.infoLog.Printf("SCIM deleted user %d", u.ID)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", "GET, PATCH, DELETE")
+		app.scimError(w, http.StatusMethodNotAllowed, "", "use GET, PATCH or DELETE")
+	}
+}
+
+func (app *application) scimListUsers(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	q, err := parseSCIMFilter(query.Get("filter"))
+	if err != nil {
+		app.scimError(w, http.StatusBadRequest, "invalidFilter", err.Error())
+		return
+	}
+	// startIndex is 1-based; out of range values are clamped as RFC 7644
+	// asks.
+	startIndex, _ := strconv.Atoi(query.Get("startIndex"))
+	if startIndex < 1 {
+		startIndex = 1
+	}
+	count, err := strconv.Atoi(query.Get("count"))
+	if err != nil || count > scimMaxResults {
+		count = scimMaxResults
+	} else if count < 0 {
+		count = 0
+	}
+	q.Offset, q.Limit = startIndex-1, count
+
+	users, total, err := app.userRepo.ListUsers(q)
+	if err != nil {
+		app.scimServerError(w, err)
+		return
+	}
+	resources := make([]scimUser, 0, len(users))
+	for _, u := range users {
+		resources = append(resources, app.toSCIMUser(u))
+	}
+	app.scimJSON(w, http.StatusOK, map[string]any{
+		"schemas":      []string{scimListSchema},
+		"totalResults": total,
+		"startIndex":   startIndex,
+		"itemsPerPage": len(resources),
+		"Resources":    resources,
+	})
+}
+
+func (app *application) scimCreateUser(w http.ResponseWriter, r *http.Request) {
+	var in scimUser
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, scimMaxBody)).Decode(&in); err != nil {
+		app.scimError(w, http.StatusBadRequest, "invalidSyntax", "malformed JSON body")
+		return
+	}
+	email, name := in.email(), in.displayName()
+	if !EmailRX.MatchString(email) || len(email) > 255 {
+		app.scimError(w, http.StatusBadRequest, "invalidValue", "userName must be an email address")
+		return
+	}
+	if name == "" || len(name) > 255 {
+		app.scimError(w, http.StatusBadRequest, "invalidValue", "a display name of at most 255 characters is required")
+		return
+	}
+
+	// Provisioned accounts usually sign in through single sign-on, so they
+	// get an unguessable password unless the directory sets one.
+	password := randomToken(32)
+	if in.Password != "" {
+		problems, err := app.passwords.Check(in.Password)
+		if err != nil {
+			app.scimServerError(w, err)
+			return
+		}
+		if len(problems) > 0 {
+			app.scimError(w, http.StatusBadRequest, "invalidValue", "password: "+strings.Join(problems, "; "))
+			return
+		}
+		password = in.Password
+	}
+
+	// The directory decides who may log in, so the registration policy's
+	// approval queue does not apply.
+	status := userStatusActive
+	if in.Active != nil && !*in.Active {
+		status = userStatusDeactivated
+	}
+	id, err := app.userRepo.CreateUserWithStatus(name, email, password, status)
+	if errors.Is(err, ErrDuplicateEmail) {
+		app.scimError(w, http.StatusConflict, "uniqueness", "userName is already in use")
+		return
+	} else if err != nil {
+		app.scimServerError(w, err)
+		return
+	}
+	u, err := app.userRepo.GetUserByID(id)
+	if err != nil {
+		app.scimServerError(w, err)
+		return
+	}
+	app.infoLog.Printf("SCIM provisioned user %d (%s)", u.ID, u.Email)
+
+	out := app.toSCIMUser(u)
+	w.Header().Set("Location", out.Meta.Location)
+	app.scimJSON(w, http.StatusCreated, out)
+}
+
+// scimPatchUser applies a PatchOp request. Only the active flag can be
+// changed, either as {"path": "active", "value": false} or as
+// {"value": {"active": false}}.
+func (app *application) scimPatchUser(w http.ResponseWriter, r *http.Request, u *User) {
+	var patch scimPatch
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, scimMaxBody)).Decode(&patch); err != nil {
+		app.scimError(w, http.StatusBadRequest, "invalidSyntax", "malformed JSON body")
+		return
+	}
+
+	var active *bool
+	for _, op := range patch.Operations {
+		if kind := strings.ToLower(op.Op); kind != "replace" && kind != "add" {
+			app.scimError(w, http.StatusBadRequest, "invalidValue", fmt.Sprintf("unsupported operation %q", op.Op))
+			return
+		}
+		value := op.Value
+		switch strings.ToLower(op.Path) {
+		case "active":
+		case "":
+			var attrs map[string]json.RawMessage
+			if err := json.Unmarshal(op.Value, &attrs); err != nil {
+				app.scimError(w, http.StatusBadRequest, "invalidValue", "value must be an object")
+				return
+			}
+			for k, v := range attrs {
+				if !strings.EqualFold(k, "active") {
+					app.scimError(w, http.StatusBadRequest, "invalidPath", fmt.Sprintf("attribute %q cannot be changed", k))
+					return
+				}
+				value = v
+			}
+		default:
+			app.scimError(w, http.StatusBadRequest, "invalidPath", fmt.Sprintf("attribute %q cannot be changed", op.Path))
+			return
+		}
+		b, err := parseSCIMBool(value)
+		if err != nil {
+			app.scimError(w, http.StatusBadRequest, "invalidValue", "active must be true or false")
+			return
+		}
+		active = &b
+	}
+
+	if active != nil {
+		if err := app.setActive(u, *active); errors.Is(err, ErrAccountBanned) {
+			app.scimError(w, http.StatusBadRequest, "mutability", "banned accounts cannot be reactivated")
+			return
+		} else if err != nil {
+			app.scimServerError(w, err)
+			return
+		}
+	}
+	u, err := app.userRepo.GetUserByID(u.ID)
+	if err != nil {
+		app.scimServerError(w, err)
+		return
+	}
+	app.scimJSON(w, http.StatusOK, app.toSCIMUser(u))
+}
+
+// parseSCIMBool accepts true and false as JSON booleans or, as some
+// directories send them, as strings.
+func parseSCIMBool(raw json.RawMessage) (bool, error) {
+	var b bool
+	if err := json.Unmarshal(raw, &b); err == nil {
+		return b, nil
+	}
+	var s string
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return false, err
+	}
+	return strconv.ParseBool(s)
+}
+
+// setActive deactivates or reactivates a provisioned account. Deactivation
+// ends every session and revokes every API token straight away.
+func (app *application) setActive(u *User, active bool) error {
+	if active {
+		switch u.Status {
+		case userStatusBanned:
+			return ErrAccountBanned
+		case userStatusActive:
+			return nil
+		}
+		app.infoLog.Printf("SCIM reactivated user %d", u.ID)
+		return app.userRepo.SetStatus(u.ID, userStatusActive)
+	}
+
+	if u.Status == userStatusDeactivated || u.Status == userStatusBanned {
+		return nil
+	}
+	if err := app.userRepo.SetStatus(u.ID, userStatusDeactivated); err != nil {
+		return err
+	}
+	if err := app.userRepo.RevokeSessions(u.ID); err != nil {
+		return err
+	}
+	app.infoLog.Printf("SCIM deactivated user %d", u.ID)
+	return app.oauthRepo.RevokeUserTokens(u.ID)
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testSCIMToken = "scim-test-token"
+
+// scimRequest sends a SCIM request through the router with the test token.
+func scimRequest(t *testing.T, method, target, body string) *httptest.ResponseRecorder {
+	testApp.config.scimToken = testSCIMToken
+	t.Cleanup(func() { testApp.config.scimToken = "" })
+
+	var r io.Reader
+	if body != "" {
+		r = strings.NewReader(body)
+	}
+	req := httptest.NewRequest(method, target, r)
+	req.Header.Set("Authorization", "Bearer "+testSCIMToken)
+	req.Header.Set("Content-Type", "application/scim+json")
+	w := httptest.NewRecorder()
+	testApp.routes().ServeHTTP(w, req)
+	return w
+}
+
+func decodeSCIM(t *testing.T, w *httptest.ResponseRecorder) map[string]any {
+	var v map[string]any
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &v), w.Body.String())
+	return v
+}
+
+func TestParseSCIMFilter(t *testing.T) {
+	q, err := parseSCIMFilter(`userName eq "ada@test.com"`)
+	require.NoError(t, err)
+	assert.Equal(t, "ada@test.com", q.Email)
+
+	q, err = parseSCIMFilter(`emails.value Eq "ada@test.com"`)
+	require.NoError(t, err)
+	assert.Equal(t, "ada@test.com", q.Email)
+
+	q, err = parseSCIMFilter("active eq false")
+	require.NoError(t, err)
+	require.NotNil(t, q.Active)
+	assert.False(t, *q.Active)
+
+	for _, f := range []string{`userName co "ada"`, `name.givenName eq "Ada"`, `active eq "yes"`, `userName eq "a" and active eq true`} {
+		_, err := parseSCIMFilter(f)
+		assert.Error(t, err, f)
+	}
+}
+
+func TestSCIM_RequiresToken(t *testing.T) {
+	testApp.config.scimToken = testSCIMToken
+	defer func() { testApp.config.scimToken = "" }()
+
+	for _, header := range []string{"", "Bearer wrong", "Basic " + testSCIMToken} {
+		req := httptest.NewRequest(http.MethodGet, "/scim/v2/Users", nil)
+		if header != "" {
+			req.Header.Set("Authorization", header)
+		}
+		w := httptest.NewRecorder()
+		testApp.routes().ServeHTTP(w, req)
+		assert.Equal(t, http.StatusUnauthorized, w.Code, header)
+	}
+}
+
+func TestSCIM_CreateGetAndList(t *testing.T) {
+	defer cleanupTestData(t)
+	setRegistrationPolicy(t, RegistrationPolicy{Mode: registrationApproval})
+
+	w := scimRequest(t, http.MethodPost, "/scim/v2/Users", `{
+		"schemas": ["urn:ietf:params:scim:schemas:core:2.0:User"],
+		"userName": "ada@test.com",
+		"name": {"givenName": "Ada", "familyName": "Lovelace"},
+		"active": true
+	}`)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	created := decodeSCIM(t, w)
+	assert.Equal(t, "ada@test.com", created["userName"])
+	assert.Equal(t, "Ada Lovelace", created["displayName"])
+	assert.Equal(t, true, created["active"], "provisioned accounts skip the approval queue")
+	assert.NotContains(t, created, "password")
+	assert.Equal(t, w.Header().Get("Location"), created["meta"].(map[string]any)["location"])
+
+	w = scimRequest(t, http.MethodPost, "/scim/v2/Users", `{"userName": "ada@test.com"}`)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Equal(t, "uniqueness", decodeSCIM(t, w)["scimType"])
+
+	w = scimRequest(t, http.MethodGet, "/scim/v2/Users/"+created["id"].(string), "")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "ada@test.com", decodeSCIM(t, w)["userName"])
+
+	createTestUser(t, "Other", "other@test.com")
+	w = scimRequest(t, http.MethodGet, "/scim/v2/Users?filter="+url.QueryEscape(`userName eq "ada@test.com"`), "")
+	require.Equal(t, http.StatusOK, w.Code)
+	list := decodeSCIM(t, w)
+	assert.Equal(t, float64(1), list["totalResults"])
+	assert.Len(t, list["Resources"], 1)
+
+	w = scimRequest(t, http.MethodGet, "/scim/v2/Users?startIndex=2&count=1", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	list = decodeSCIM(t, w)
+	assert.Equal(t, float64(2), list["totalResults"])
+	assert.Equal(t, float64(2), list["startIndex"])
+	require.Len(t, list["Resources"], 1)
+	assert.Equal(t, "other@test.com", list["Resources"].([]any)[0].(map[string]any)["userName"])
+
+	w = scimRequest(t, http.MethodGet, "/scim/v2/Users?filter="+url.QueryEscape(`userName sw "ada"`), "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, "invalidFilter", decodeSCIM(t, w)["scimType"])
+
+	w = scimRequest(t, http.MethodGet, "/scim/v2/Users/99999", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = scimRequest(t, http.MethodPost, "/scim/v2/Users", `{"userName": "grace@test.com", "displayName": "Grace", "active": false}`)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	assert.Equal(t, false, decodeSCIM(t, w)["active"])
+	grace, err := testApp.userRepo.GetUserByEmail("grace@test.com")
+	require.NoError(t, err)
+	assert.Equal(t, userStatusDeactivated, grace.Status)
+}
+
+func TestSCIM_DeactivateBlocksSessionsAndLogin(t *testing.T) {
+	defer cleanupTestData(t)
+	u := createTestUser(t, "Leaver", "leaver@test.com")
+	path := "/scim/v2/Users/" + strconv.Itoa(u.ID)
+
+	w := scimRequest(t, http.MethodPatch, path, `{
+		"schemas": ["urn:ietf:params:scim:api:messages:2.0:PatchOp"],
+		"Operations": [{"op": "replace", "path": "active", "value": false}]
+	}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, false, decodeSCIM(t, w)["active"])
+
+	after, err := testApp.userRepo.GetUserByID(u.ID)
+	require.NoError(t, err)
+	assert.Equal(t, userStatusDeactivated, after.Status)
+	assert.NotEqual(t, u.SessionEpoch, after.SessionEpoch)
+	_, err = testApp.userRepo.Authenticate(u.Email, "correct horse battery staple")
+	assert.ErrorIs(t, err, ErrAccountInactive)
+
+	// A session started now would still be refused by authenticate.
+	setupHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		testApp.startSession(r, after)
+	})
+	w1 := httptest.NewRecorder()
+	testApp.session.Enable(setupHandler).ServeHTTP(w1, httptest.NewRequest(http.MethodGet, "/setup", nil))
+	req := httptest.NewRequest(http.MethodGet, "/test", nil)
+	for _, cookie := range w1.Result().Cookies() {
+		req.AddCookie(cookie)
+	}
+	testApp.session.Enable(testApp.authenticate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.False(t, testApp.isAuthenticated(r))
+	}))).ServeHTTP(httptest.NewRecorder(), req)
+
+	// Azure AD style: the attribute in the value and the flag as a string.
+	w = scimRequest(t, http.MethodPatch, path, `{
+		"schemas": ["urn:ietf:params:scim:api:messages:2.0:PatchOp"],
+		"Operations": [{"op": "Replace", "value": {"active": "True"}}]
+	}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, true, decodeSCIM(t, w)["active"])
+	_, err = testApp.userRepo.Authenticate(u.Email, "correct horse battery staple")
+	assert.NoError(t, err)
+
+	w = scimRequest(t, http.MethodPatch, path, `{
+		"Operations": [{"op": "replace", "path": "userName", "value": "new@test.com"}]
+	}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, "invalidPath", decodeSCIM(t, w)["scimType"])
+}
+
+func TestSCIM_Delete(t *testing.T) {
+	defer cleanupTestData(t)
+	u := createTestUser(t, "Removed", "removed@test.com")
+	path := "/scim/v2/Users/" + strconv.Itoa(u.ID)
+
+	w := scimRequest(t, http.MethodDelete, path, "")
+	assert.Equal(t, http.StatusNoContent, w.Code)
+
+	after, err := testApp.userRepo.GetUserByID(u.ID)
+	require.NoError(t, err)
+	assert.Equal(t, userStatusDeleted, after.Status)
+
+	w = scimRequest(t, http.MethodGet, path, "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
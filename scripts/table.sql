@@ -3,6 +3,13 @@ CREATE TABLE users (
    name TEXT NOT NULL,
    email TEXT NOT NULL UNIQUE,
    hashed_password TEXT NOT NULL,
+   is_admin BOOLEAN NOT NULL DEFAULT 0,
+   status TEXT NOT NULL DEFAULT 'active',
+   karma INTEGER NOT NULL DEFAULT 0,
+   session_epoch INTEGER NOT NULL DEFAULT 0,
+   deletion_scheduled_at DATETIME,
+   invited_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+   favorites_public BOOLEAN NOT NULL DEFAULT 1,
    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
 );
 
@@ -16,7 +23,8 @@ CREATE TABLE profile (
 CREATE TABLE posts (
    id INTEGER PRIMARY KEY AUTOINCREMENT,
    url TEXT NOT NULL,
-   title TEXT NOT NULL UNIQUE,
+   title TEXT NOT NULL,
+   site TEXT NOT NULL DEFAULT '',
    user_id INTEGER REFERENCES users(user_id) ON DELETE CASCADE,
    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
 );
@@ -34,4 +42,181 @@ CREATE TABLE votes (
    post_id INTEGER REFERENCES posts(post_id) ON DELETE CASCADE,
    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
    PRIMARY KEY (user_id, post_id)
-);
\ No newline at end of file
+);
+
+CREATE TABLE user_identities (
+   provider TEXT NOT NULL,
+   subject TEXT NOT NULL,
+   user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+   created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+   PRIMARY KEY (provider, subject)
+);
+
+CREATE TABLE oauth_clients (
+   id TEXT PRIMARY KEY,
+   name TEXT NOT NULL,
+   redirect_uri TEXT NOT NULL,
+   secret_hash TEXT NOT NULL,
+   created_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+   created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE oauth_codes (
+   code_hash TEXT PRIMARY KEY,
+   client_id TEXT NOT NULL REFERENCES oauth_clients(id) ON DELETE CASCADE,
+   user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+   redirect_uri TEXT NOT NULL,
+   scope TEXT NOT NULL,
+   code_challenge TEXT NOT NULL,
+   expires_at DATETIME NOT NULL
+);
+
+CREATE TABLE oauth_tokens (
+   token_hash TEXT PRIMARY KEY,
+   client_id TEXT NOT NULL REFERENCES oauth_clients(id) ON DELETE CASCADE,
+   user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+   kind TEXT NOT NULL,
+   scope TEXT NOT NULL,
+   expires_at DATETIME NOT NULL,
+   revoked_at DATETIME,
+   created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE email_changes (
+   token_hash TEXT PRIMARY KEY,
+   user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+   new_email TEXT NOT NULL,
+   expires_at DATETIME NOT NULL,
+   created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE data_exports (
+   id INTEGER PRIMARY KEY AUTOINCREMENT,
+   user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+   token_hash TEXT NOT NULL UNIQUE,
+   status TEXT NOT NULL DEFAULT 'pending',
+   path TEXT NOT NULL DEFAULT '',
+   expires_at DATETIME NOT NULL,
+   created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE invitations (
+   id INTEGER PRIMARY KEY AUTOINCREMENT,
+   token_hash TEXT NOT NULL UNIQUE,
+   inviter_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+   email TEXT NOT NULL,
+   note TEXT NOT NULL DEFAULT '',
+   invitee_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+   used_at DATETIME,
+   created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE login_events (
+   id INTEGER PRIMARY KEY AUTOINCREMENT,
+   user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+   email TEXT NOT NULL,
+   success BOOLEAN NOT NULL,
+   ip TEXT NOT NULL,
+   user_agent TEXT NOT NULL,
+   device TEXT NOT NULL,
+   network TEXT NOT NULL,
+   alert_token_hash TEXT UNIQUE,
+   created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX login_events_user_id ON login_events (user_id, created_at);
+
+CREATE TABLE password_resets (
+   token_hash TEXT PRIMARY KEY,
+   user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+   expires_at DATETIME NOT NULL,
+   created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE favorite_posts (
+   user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+   post_id INTEGER NOT NULL REFERENCES posts(id) ON DELETE CASCADE,
+   created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+   PRIMARY KEY (user_id, post_id)
+);
+
+CREATE TABLE favorite_comments (
+   user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+   comment_id INTEGER NOT NULL REFERENCES comments(id) ON DELETE CASCADE,
+   created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+   PRIMARY KEY (user_id, comment_id)
+);
+
+CREATE TABLE hidden_posts (
+   user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+   post_id INTEGER NOT NULL REFERENCES posts(id) ON DELETE CASCADE,
+   created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+   PRIMARY KEY (user_id, post_id)
+);
+
+CREATE TABLE saved_searches (
+   id INTEGER PRIMARY KEY AUTOINCREMENT,
+   user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+   query TEXT NOT NULL,
+   email BOOLEAN NOT NULL DEFAULT 0,
+   last_post_id INTEGER NOT NULL DEFAULT 0,
+   last_comment_id INTEGER NOT NULL DEFAULT 0,
+   emailed_at DATETIME,
+   created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+   UNIQUE (user_id, query)
+);
+
+CREATE TABLE search_alerts (
+   id INTEGER PRIMARY KEY AUTOINCREMENT,
+   search_id INTEGER NOT NULL REFERENCES saved_searches(id) ON DELETE CASCADE,
+   user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+   post_id INTEGER NOT NULL REFERENCES posts(id) ON DELETE CASCADE,
+   comment_id INTEGER REFERENCES comments(id) ON DELETE CASCADE,
+   read_at DATETIME,
+   emailed_at DATETIME,
+   created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE site_sanctions (
+   site TEXT PRIMARY KEY,
+   status TEXT NOT NULL,
+   reason TEXT NOT NULL DEFAULT '',
+   admin_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+   created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE link_checks (
+   post_id INTEGER PRIMARY KEY REFERENCES posts(id) ON DELETE CASCADE,
+   status INTEGER NOT NULL DEFAULT 0,
+   error TEXT NOT NULL DEFAULT '',
+   failures INTEGER NOT NULL DEFAULT 0,
+   dead BOOLEAN NOT NULL DEFAULT 0,
+   checked_at DATETIME NOT NULL,
+   next_check_at DATETIME NOT NULL
+);
+
+CREATE TABLE snapshots (
+   post_id INTEGER PRIMARY KEY REFERENCES posts(id) ON DELETE CASCADE,
+   blob_key TEXT NOT NULL,
+   url TEXT NOT NULL,
+   title TEXT NOT NULL DEFAULT '',
+   size INTEGER NOT NULL DEFAULT 0,
+   created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX posts_created_at ON posts (created_at);
+CREATE INDEX posts_user_id ON posts (user_id, created_at);
+CREATE INDEX posts_site ON posts (site);
+CREATE INDEX posts_url ON posts (url);
+CREATE INDEX comments_post_id ON comments (post_id, created_at);
+CREATE INDEX comments_user_id ON comments (user_id, created_at);
+CREATE INDEX votes_post_id ON votes (post_id);
+CREATE INDEX favorite_posts_post_id ON favorite_posts (post_id);
+CREATE INDEX favorite_comments_comment_id ON favorite_comments (comment_id);
+CREATE INDEX search_alerts_user_id ON search_alerts (user_id, read_at);
+CREATE INDEX search_alerts_search_id ON search_alerts (search_id, emailed_at);
+CREATE INDEX link_checks_next_check_at ON link_checks (next_check_at);
+CREATE INDEX snapshots_created_at ON snapshots (created_at);
+
+-- The number of entries in migrations (migrations.go) this schema is up to.
+PRAGMA user_version = 16;
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrInvalidSearch wraps every error ParseSearch returns, so callers can
+// tell a malformed query from a failed database call.
+var ErrInvalidSearch = errors.New("invalid search")
+
+const (
+	searchAuthor   = "author"
+	searchSite     = "site"
+	searchPoints   = "points"
+	searchComments = "comments"
+	searchBefore   = "before"
+	searchAfter    = "after"
+)
+
+// searchOps are the comparisons points and comments accept, longest first
+// so that ">=" is not read as ">".
+var searchOps = []string{">=", "<=", ">", "<", "=", ":"}
+
+var siteRX = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]*[a-z0-9])?(\.[a-z0-9]([a-z0-9-]*[a-z0-9])?)*$`)
+
+// SearchTerm is one condition of a search query. Field is empty for words
+// and phrases matched against the title.
+type SearchTerm struct {
+	Field  string
+	Op     string // for points and comments
+	Value  string
+	Number int       // for points and comments
+	Day    time.Time // for before and after
+	Negate bool
+}
+
+// SearchQuery is a parsed search box query; a post matches when it meets
+// every term.
+type SearchQuery struct {
+	Terms []SearchTerm
+}
+
+// ParseSearch parses the search box syntax: words and "quoted phrases"
+// found in the title, author:name, site:example.com, points and comments
+// compared with >, >=, <, <= or =, and before: and after: with a
+// YYYY-MM-DD day. A leading - negates any term. Words that look like an
+// unknown field, such as "Show HN:", are searched for as they are.
+func ParseSearch(q string) (SearchQuery, error) {
+	var query SearchQuery
+	s := strings.TrimSpace(q)
+	for s != "" {
+		var term SearchTerm
+		if s[0] == '-' {
+			term.Negate = true
+			s = s[1:]
+			if s == "" || s[0] == ' ' {
+				return SearchQuery{}, fmt.Errorf("%w: \"-\" must come right before what it excludes", ErrInvalidSearch)
+			}
+		}
+
+		var err error
+		if s[0] == '"' {
+			term.Value, s, err = readPhrase(s)
+			if err != nil {
+				return SearchQuery{}, err
+			}
+		} else {
+			var word string
+			word, s = readWord(s)
+			parseField(&term, word)
+			if term.Field != "" && term.Value == "" && strings.HasPrefix(s, `"`) {
+				// author:"Jane Doe" and site:"example.com"
+				term.Value, s, err = readPhrase(s)
+				if err != nil {
+					return SearchQuery{}, err
+				}
+			}
+			if err := checkField(&term); err != nil {
+				return SearchQuery{}, err
+			}
+		}
+		if term.Field == "" && term.Value == "" {
+			return SearchQuery{}, fmt.Errorf("%w: empty phrase", ErrInvalidSearch)
+		}
+
+		query.Terms = append(query.Terms, term)
+		s = strings.TrimLeft(s, " ")
+	}
+	return query, nil
+}
+
+// readPhrase reads the quoted phrase s starts with and returns it with
+// the rest of s.
+func readPhrase(s string) (string, string, error) {
+	end := strings.IndexByte(s[1:], '"')
+	if end < 0 {
+		return "", "", fmt.Errorf("%w: missing closing quote after %s", ErrInvalidSearch, s)
+	}
+	return s[1 : end+1], s[end+2:], nil
+}
+
+// readWord reads up to the next space or, after a field name, the quote
+// that starts its value.
+func readWord(s string) (string, string) {
+	for i := 0; i < len(s); i++ {
+		if s[i] == ' ' || (s[i] == '"' && i > 0 && s[i-1] == ':') {
+			return s[:i], s[i:]
+		}
+	}
+	return s, ""
+}
+
+// parseField splits word into a known field, its comparison and value, or
+// keeps it whole as a title word.
+func parseField(term *SearchTerm, word string) {
+	name, rest, found := strings.Cut(word, ":")
+	switch strings.ToLower(name) {
+	case searchAuthor, searchSite, searchBefore, searchAfter:
+		if found {
+			term.Field, term.Value = strings.ToLower(name), rest
+			return
+		}
+	}
+	for _, field := range []string{searchPoints, searchComments} {
+		if len(word) <= len(field) || !strings.EqualFold(word[:len(field)], field) {
+			continue
+		}
+		for _, op := range searchOps {
+			if strings.HasPrefix(word[len(field):], op) {
+				term.Field, term.Value = field, word[len(field)+len(op):]
+				term.Op = op
+				if op == ":" {
+					term.Op = "="
+				}
+				return
+			}
+		}
+	}
+	term.Value = word
+}
+
+// checkField validates the value of a field term.
+func checkField(term *SearchTerm) error {
+	if term.Field != "" && term.Value == "" {
+		return fmt.Errorf("%w: %s needs a value", ErrInvalidSearch, term.Field)
+	}
+	switch term.Field {
+	case searchSite:
+		term.Value = normalizeSite(term.Value)
+		if !siteRX.MatchString(term.Value) {
+			return fmt.Errorf("%w: %q is not a site name like example.com", ErrInvalidSearch, term.Value)
+		}
+	case searchPoints, searchComments:
+		n, err := strconv.Atoi(term.Value)
+		if err != nil || n < 0 {
+			return fmt.Errorf("%w: %s must be compared with a whole number, not %q", ErrInvalidSearch, term.Field, term.Value)
+		}
+		term.Number = n
+	case searchBefore, searchAfter:
+		day, err := time.Parse(dayLayout, term.Value)
+		if err != nil {
+			return fmt.Errorf("%w: %s takes a day like 2024-01-31, not %q", ErrInvalidSearch, term.Field, term.Value)
+		}
+		term.Day = day
+	}
+	return nil
+}
+
+// searchColumns names what the title words, author and day terms of a
+// query are matched against. Site, points and comments are always about
+// the post p.
+type searchColumns struct {
+	text, author, createdAt string
+}
+
+var (
+	postSearch    = searchColumns{text: "p.title", author: "u.name", createdAt: "p.created_at"}
+	commentSearch = searchColumns{text: "cm.body", author: "cu.name", createdAt: "cm.created_at"}
+)
+
+// HasText reports whether the query looks for any word or phrase, rather
+// than only selecting posts by their fields.
+func (q SearchQuery) HasText() bool {
+	for _, t := range q.Terms {
+		if t.Field == "" && !t.Negate {
+			return true
+		}
+	}
+	return false
+}
+
+// where compiles the query into conditions on cols and their arguments,
+// to be joined with AND.
+func (q SearchQuery) where(cols searchColumns) ([]string, []interface{}) {
+	var where []string
+	var args []interface{}
+	for _, t := range q.Terms {
+		var cond string
+		switch t.Field {
+		case "":
+			cond = "LOWER(" + cols.text + `) LIKE ? ESCAPE '\'`
+			args = append(args, "%"+escapeLike(strings.ToLower(t.Value))+"%")
+		case searchAuthor:
+			cond = "LOWER(" + cols.author + ") = ?"
+			args = append(args, strings.ToLower(t.Value))
+		case searchSite:
+			var siteArgs []interface{}
+			cond, siteArgs = siteCondition(t.Value)
+			args = append(args, siteArgs...)
+		case searchPoints:
+			cond = "(SELECT COUNT(*) FROM votes v WHERE v.post_id = p.id) " + t.Op + " ?"
+			args = append(args, t.Number)
+		case searchComments:
+			cond = "(SELECT COUNT(*) FROM comments c WHERE c.post_id = p.id) " + t.Op + " ?"
+			args = append(args, t.Number)
+		case searchBefore:
+			cond = cols.createdAt + " < ?"
+			args = append(args, t.Day.Format(sqliteTimeLayout))
+		case searchAfter:
+			// after a day means from the start of the next one
+			cond = cols.createdAt + " >= ?"
+			args = append(args, t.Day.AddDate(0, 0, 1).Format(sqliteTimeLayout))
+		}
+		if t.Negate {
+			cond = "NOT " + cond
+			if t.Field == searchAuthor {
+				// the author is NULL when the user row is missing, which
+				// NOT LIKE would otherwise exclude
+				cond = "(" + cols.author + " IS NULL OR " + cond + ")"
+			}
+		}
+		where = append(where, cond)
+	}
+	return where, args
+}
+
+// escapeLike escapes the LIKE wildcards in s for ESCAPE '\'.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
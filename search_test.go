@@ -0,0 +1,95 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSearch(t *testing.T) {
+	q, err := ParseSearch(`go -"web framework" author:"Jane Doe" site:Example.com points>=10 comments:3 after:2024-01-31 Show HN:`)
+	require.NoError(t, err)
+	assert.Equal(t, []SearchTerm{
+		{Value: "go"},
+		{Value: "web framework", Negate: true},
+		{Field: searchAuthor, Value: "Jane Doe"},
+		{Field: searchSite, Value: "example.com"},
+		{Field: searchPoints, Op: ">=", Value: "10", Number: 10},
+		{Field: searchComments, Op: "=", Value: "3", Number: 3},
+		{Field: searchAfter, Value: "2024-01-31", Day: time.Date(2024, 1, 31, 0, 0, 0, 0, time.UTC)},
+		{Value: "Show"},
+		{Value: "HN:"},
+	}, q.Terms)
+
+	q, err = ParseSearch("   ")
+	require.NoError(t, err)
+	assert.Empty(t, q.Terms)
+}
+
+func TestParseSearch_Errors(t *testing.T) {
+	for _, q := range []string{
+		`"unclosed phrase`,
+		`author:"unclosed`,
+		`go - rust`,
+		`""`,
+		`author:`,
+		`site:exa_mple.com`,
+		`points>many`,
+		`comments<-1`,
+		`before:yesterday`,
+	} {
+		_, err := ParseSearch(q)
+		assert.ErrorIs(t, err, ErrInvalidSearch, q)
+	}
+}
+
+func TestGetAll_Search(t *testing.T) {
+	defer cleanupTestData(t)
+	jane := createTestUser(t, "Jane Doe", "jane@test.com")
+	bob := createTestUser(t, "Bob", "bob@test.com")
+	newPost := func(u *User, title, url, createdAt string) int {
+		id, err := testApp.postRepo.CreatePost(title, url, u.ID)
+		require.NoError(t, err)
+		_, err = testDB.Exec("UPDATE posts SET created_at = ? WHERE id = ?", createdAt, id)
+		require.NoError(t, err)
+		return id
+	}
+	tour := newPost(jane, "A tour of Go", "https://go.dev/tour", "2024-01-10 12:00:00")
+	newPost(jane, "Rust web frameworks", "https://blog.example.com/rust?x=1", "2024-02-10 12:00:00")
+	news := newPost(bob, "Go web framework news", "http://example.com:8080/news", "2024-03-10 12:00:00")
+	newPost(bob, "100% pure_go", "https://notexample.com/", "2024-04-10 12:00:00")
+	require.NoError(t, testApp.postRepo.AddVote(bob.ID, tour))
+	require.NoError(t, testApp.postRepo.AddVote(jane.ID, tour))
+	_, err := testApp.postRepo.AddComment(jane.ID, news, "Nice")
+	require.NoError(t, err)
+
+	for query, want := range map[string][]string{
+		"go":                   {"100% pure_go", "Go web framework news", "A tour of Go"},
+		`"web framework"`:      {"Go web framework news", "Rust web frameworks"},
+		`go -"web framework"`:  {"100% pure_go", "A tour of Go"},
+		`author:"jane doe"`:    {"Rust web frameworks", "A tour of Go"},
+		"-author:Bob web":      {"Rust web frameworks"},
+		"site:example.com":     {"Go web framework news", "Rust web frameworks"},
+		"-site:example.com go": {"100% pure_go", "A tour of Go"},
+		"points>1":             {"A tour of Go"},
+		"points=0 comments>0":  {"Go web framework news"},
+		"after:2024-02-10":     {"100% pure_go", "Go web framework news"},
+		"before:2024-02-10":    {"A tour of Go"},
+		"100%":                 {"100% pure_go"},
+		"_":                    {"100% pure_go"},
+		"after:2024-01-01 before:2024-03-01 -rust": {"A tour of Go"},
+	} {
+		posts, _, err := testApp.postRepo.GetAll(Filter{Page: 1, PageSize: 10, Query: query})
+		require.NoError(t, err, query)
+		assert.Equal(t, want, postTitles(posts), query)
+	}
+}
+
+func TestHome_InvalidSearch(t *testing.T) {
+	w := serveAs(testApp.home, nil, http.MethodGet, "/?q=points%3Emany", nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "points must be compared with a whole number")
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+func (app *application) settings(w http.ResponseWriter, r *http.Request) {
+	app.renderSettings(w, r, NewForm(nil))
+}
+
+func (app *application) renderSettings(w http.ResponseWriter, r *http.Request, form *Form) {
+	u := app.getUserFromContext(r.Context())
+	export, err := app.exportRepo.GetLatestExport(u.ID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	logins, err := app.loginRepo.GetLoginHistory(u.ID, loginHistoryLength)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	hasIdentity, err := app.userRepo.HasIdentity(u.ID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	app.render(w, r, "settings.html", &templateData{
+		HasIdentity:       hasIdentity,
+		Form:              form,
+		User:              u,
+		DataExport:        export,
+		LoginHistory:      logins,
+		DeletionGraceDays: int(app.config.deletionGrace.Hours() / 24),
+	})
+}
+
+// parseSettingsForm returns the posted form, or nil after redirecting back
+// to the settings page for anything but a valid POST.
+func (app *application) parseSettingsForm(w http.ResponseWriter, r *http.Request) *Form {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/settings", http.StatusSeeOther)
+		return nil
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return nil
+	}
+	return NewForm(r.PostForm)
+}
+
+func (app *application) settingsName(w http.ResponseWriter, r *http.Request) {
+	form := app.parseSettingsForm(w, r)
+	if form == nil {
+		return
+	}
+	form.Required("name").
+		MinLength("name", 3).
+		MaxLength("name", 255)
+	if !form.Valid() {
+		app.renderSettings(w, r, form)
+		return
+	}
+
+	u := app.getUserFromContext(r.Context())
+	if err := app.userRepo.UpdateName(u.ID, form.Get("name")); err != nil {
+		app.serverError(w, err)
+		return
+	}
+	app.session.Put(r, "flash", "Your name was updated")
+	http.Redirect(w, r, "/settings", http.StatusSeeOther)
+}
+
+func (app *application) settingsEmail(w http.ResponseWriter, r *http.Request) {
+	form := app.parseSettingsForm(w, r)
+	if form == nil {
+		return
+	}
+	if app.config.authMode == authModeProxy {
+		app.session.Put(r, "flash", "Your email address is managed by your organization")
+		http.Redirect(w, r, "/settings", http.StatusSeeOther)
+		return
+	}
+	form.Required("email").
+		MaxLength("email", 255).
+		MinLength("email", 3).
+		IsEmail("email")
+
+	u := app.getUserFromContext(r.Context())
+	if form.Get("email") == u.Email {
+		form.Errors.Add("email", "This is already your email address")
+	}
+	// Members outside the allowlist could not log in after the change.
+	if form.Get("email") != "" && !u.IsAdmin && !app.config.registration.DomainAllowed(form.Get("email")) {
+		form.Errors.Add("email", fmt.Sprintf("Use an email address at %s",
+			strings.Join(app.config.registration.Domains, ", ")))
+	}
+	if !form.Valid() {
+		app.renderSettings(w, r, form)
+		return
+	}
+
+	newEmail := form.Get("email")
+	token, err := app.userRepo.CreateEmailChange(u.ID, newEmail)
+	if errors.Is(err, ErrDuplicateEmail) {
+		form.Errors.Add("email", err.Error())
+		app.renderSettings(w, r, form)
+		return
+	} else if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	link := app.absoluteURL("/settings/email/confirm?token=" + url.QueryEscape(token))
+	body := fmt.Sprintf("Hi %s,\n\nconfirm that you want to use this address for your hnews account:\n\n%s\n\n"+
+		"The link expires in 24 hours. If you did not ask for this, ignore this email.\n", u.Name, link)
+	if err := app.mailer.Send(newEmail, "Confirm your new email address", body); err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.session.Put(r, "flash", fmt.Sprintf("We sent a confirmation link to %s", newEmail))
+	http.Redirect(w, r, "/settings", http.StatusSeeOther)
+}
+
+func (app *application) settingsEmailConfirm(w http.ResponseWriter, r *http.Request) {
+	updated, err := app.userRepo.ConfirmEmailChange(r.URL.Query().Get("token"))
+	if errors.Is(err, ErrInvalidToken) || errors.Is(err, ErrDuplicateEmail) || errors.Is(err, ErrEmailDomainRejected) {
+		app.session.Put(r, "flash", fmt.Sprintf("Email change failed: %s", err))
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	} else if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	// The session identifies users by email, so it has to follow the change.
+	if u, ok := r.Context().Value(contextUserKey).(*User); ok && u.ID == updated.ID {
+		app.session.Put(r, loggedInUserKey, updated.Email)
+	}
+	app.session.Put(r, "flash", fmt.Sprintf("Your email address is now %s", updated.Email))
+	http.Redirect(w, r, "/settings", http.StatusSeeOther)
+}
+
+func (app *application) settingsPassword(w http.ResponseWriter, r *http.Request) {
+	form := app.parseSettingsForm(w, r)
+	if form == nil {
+		return
+	}
+	if app.config.authMode == authModeProxy {
+		app.session.Put(r, "flash", "Your password is managed by your organization")
+		http.Redirect(w, r, "/settings", http.StatusSeeOther)
+		return
+	}
+	form.Required("current_password", "new_password").
+		MaxLength("new_password", 255)
+
+	u := app.getUserFromContext(r.Context())
+	if form.Get("new_password") != "" {
+		problems, err := app.passwords.Check(form.Get("new_password"), u.Name, u.Email)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+		for _, problem := range problems {
+			form.Errors.Add("new_password", problem)
+		}
+	}
+	if form.Get("current_password") != "" {
+		_, err := app.userRepo.Authenticate(u.Email, form.Get("current_password"))
+		if errors.Is(err, ErrInvalidCredential) {
+			form.Errors.Add("current_password", "Your current password is not correct")
+		} else if err != nil {
+			app.serverError(w, err)
+			return
+		}
+	}
+	if !form.Valid() {
+		app.renderSettings(w, r, form)
+		return
+	}
+
+	if err := app.userRepo.UpdatePassword(u.ID, form.Get("new_password")); err != nil {
+		app.serverError(w, err)
+		return
+	}
+	// Whoever knew the old password is logged out everywhere; this browser
+	// stays logged in with the new session epoch.
+	if err := app.userRepo.RevokeSessions(u.ID); err != nil {
+		app.serverError(w, err)
+		return
+	}
+	if err := app.oauthRepo.RevokeUserTokens(u.ID); err != nil {
+		app.serverError(w, err)
+		return
+	}
+	u, err := app.userRepo.GetUserByID(u.ID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	app.startSession(r, u)
+	app.session.Put(r, "flash", "Your password was changed")
+	http.Redirect(w, r, "/settings", http.StatusSeeOther)
+}
+
+func (app *application) settingsAvatar(w http.ResponseWriter, r *http.Request) {
+	form := app.parseSettingsForm(w, r)
+	if form == nil {
+		return
+	}
+	form.MaxLength("avatar", 255).
+		IsURL("avatar")
+	if !form.Valid() {
+		app.renderSettings(w, r, form)
+		return
+	}
+
+	u := app.getUserFromContext(r.Context())
+	if err := app.userRepo.UpdateAvatar(u.ID, form.Get("avatar")); err != nil {
+		app.serverError(w, err)
+		return
+	}
+	app.session.Put(r, "flash", "Your avatar was updated")
+	http.Redirect(w, r, "/settings", http.StatusSeeOther)
+}
+
+func (app *application) settingsDelete(w http.ResponseWriter, r *http.Request) {
+	form := app.parseSettingsForm(w, r)
+	if form == nil {
+		return
+	}
+	if app.config.authMode == authModeProxy {
+		app.session.Put(r, "flash", "Your account is managed by your organization")
+		http.Redirect(w, r, "/settings", http.StatusSeeOther)
+		return
+	}
+
+	// Deletion is confirmed with the password. Accounts created through SSO
+	// have a random password nobody knows, so users who can log in through
+	// an identity provider may type their email address instead.
+	u := app.getUserFromContext(r.Context())
+	hasIdentity, err := app.userRepo.HasIdentity(u.ID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	if hasIdentity && form.Get("password") == "" {
+		form.Required("confirm_email")
+		if form.Get("confirm_email") != "" && !strings.EqualFold(strings.TrimSpace(form.Get("confirm_email")), u.Email) {
+			form.Errors.Add("confirm_email", "This is not your email address")
+		}
+	} else {
+		form.Required("password")
+	}
+	if form.Get("password") != "" {
+		_, err := app.userRepo.Authenticate(u.Email, form.Get("password"))
+		if errors.Is(err, ErrInvalidCredential) || errors.Is(err, ErrPasswordReset) {
+			form.Errors.Add("password", "Your password is not correct")
+		} else if err != nil {
+			app.serverError(w, err)
+			return
+		}
+	}
+	if !form.Valid() {
+		app.renderSettings(w, r, form)
+		return
+	}
+
+	at := time.Now().Add(app.config.deletionGrace)
+	if err := app.userRepo.ScheduleDeletion(u.ID, at); err != nil {
+		app.serverError(w, err)
+		return
+	}
+	if err := app.userRepo.RevokeSessions(u.ID); err != nil {
+		app.serverError(w, err)
+		return
+	}
+	if err := app.oauthRepo.RevokeUserTokens(u.ID); err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.session.Remove(r, loggedInUserKey)
+	app.session.Remove(r, sessionEpochKey)
+	app.session.Put(r, "flash", fmt.Sprintf("Your account will be deleted on %s. To keep it, log in before then and cancel the deletion in settings.",
+		at.Format("January 2, 2006")))
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+func (app *application) settingsDeleteCancel(w http.ResponseWriter, r *http.Request) {
+	if form := app.parseSettingsForm(w, r); form == nil {
+		return
+	}
+	u := app.getUserFromContext(r.Context())
+	if err := app.userRepo.CancelDeletion(u.ID); err != nil {
+		app.serverError(w, err)
+		return
+	}
+	app.session.Put(r, "flash", "Your account will not be deleted")
+	http.Redirect(w, r, "/settings", http.StatusSeeOther)
+}
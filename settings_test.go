@@ -0,0 +1,187 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func createTestUser(t *testing.T, name, email string) *User {
+	id, err := testApp.userRepo.CreateUser(name, email, "correct horse battery staple", "https://example.com/a.png")
+	require.NoError(t, err)
+	u, err := testApp.userRepo.GetUserByID(id)
+	require.NoError(t, err)
+	return u
+}
+
+func TestSettings_GET(t *testing.T) {
+	defer cleanupTestData(t)
+	u := createTestUser(t, "Settings User", "settings@test.com")
+
+	w := serveAs(testApp.settings, u, http.MethodGet, "/settings", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "settings@test.com")
+	assert.Contains(t, w.Body.String(), `value="Settings User"`)
+}
+
+func TestSettingsName_POST(t *testing.T) {
+	defer cleanupTestData(t)
+	u := createTestUser(t, "Settings User", "settings@test.com")
+
+	w := serveAs(testApp.settingsName, u, http.MethodPost, "/settings/name", url.Values{"name": {"Renamed"}})
+	assert.Equal(t, http.StatusSeeOther, w.Code)
+	assert.Equal(t, "/settings", w.Header().Get("Location"))
+
+	updated, err := testApp.userRepo.GetUserByID(u.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "Renamed", updated.Name)
+
+	w = serveAs(testApp.settingsName, u, http.MethodPost, "/settings/name", url.Values{"name": {"x"}})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "too short")
+}
+
+func TestSettingsPassword_POST(t *testing.T) {
+	defer cleanupTestData(t)
+	u := createTestUser(t, "Settings User", "settings@test.com")
+
+	w := serveAs(testApp.settingsPassword, u, http.MethodPost, "/settings/password", url.Values{
+		"current_password": {"wrong password"},
+		"new_password":     {"a much better passphrase 42"},
+	})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "Your current password is not correct")
+
+	w = serveAs(testApp.settingsPassword, u, http.MethodPost, "/settings/password", url.Values{
+		"current_password": {"correct horse battery staple"},
+		"new_password":     {"a much better passphrase 42"},
+	})
+	assert.Equal(t, http.StatusSeeOther, w.Code)
+
+	_, err := testApp.userRepo.Authenticate("settings@test.com", "a much better passphrase 42")
+	assert.NoError(t, err)
+}
+
+func TestSettingsPassword_RevokesOtherSessions(t *testing.T) {
+	defer cleanupTestData(t)
+	u := createTestUser(t, "Settings User", "settings@test.com")
+	client, _, err := testApp.oauthRepo.CreateClient("Tool", "https://tool.test/cb", u.ID)
+	require.NoError(t, err)
+	access, _, err := testApp.oauthRepo.IssueTokens(client.ID, u.ID, "read", "")
+	require.NoError(t, err)
+
+	w := serveAs(testApp.settingsPassword, u, http.MethodPost, "/settings/password", url.Values{
+		"current_password": {"correct horse battery staple"},
+		"new_password":     {"a much better passphrase 42"},
+	})
+	require.Equal(t, http.StatusSeeOther, w.Code)
+
+	after, err := testApp.userRepo.GetUserByID(u.ID)
+	require.NoError(t, err)
+	assert.Equal(t, u.SessionEpoch+1, after.SessionEpoch)
+	_, err = testApp.oauthRepo.GetToken(access)
+	assert.ErrorIs(t, err, ErrInvalidGrant)
+}
+
+func TestSettingsEmail_RequiresConfirmation(t *testing.T) {
+	defer cleanupTestData(t)
+	u := createTestUser(t, "Settings User", "settings@test.com")
+
+	w := serveAs(testApp.settingsEmail, u, http.MethodPost, "/settings/email", url.Values{"email": {"new@test.com"}})
+	assert.Equal(t, http.StatusSeeOther, w.Code)
+
+	unchanged, err := testApp.userRepo.GetUserByID(u.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "settings@test.com", unchanged.Email)
+
+	mail, ok := testApp.mailer.(*testMailer).last("new@test.com")
+	require.True(t, ok)
+	link := regexp.MustCompile(`/settings/email/confirm\?token=\S+`).FindString(mail.Body)
+	require.NotEmpty(t, link)
+
+	w = serveAs(testApp.settingsEmailConfirm, u, http.MethodGet, link, nil)
+	assert.Equal(t, http.StatusSeeOther, w.Code)
+	assert.Equal(t, "/settings", w.Header().Get("Location"))
+
+	changed, err := testApp.userRepo.GetUserByID(u.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "new@test.com", changed.Email)
+
+	// Links are single use.
+	w = serveAs(testApp.settingsEmailConfirm, u, http.MethodGet, link, nil)
+	assert.Equal(t, "/", w.Header().Get("Location"))
+}
+
+func TestSettingsEmail_RejectsTakenAddress(t *testing.T) {
+	defer cleanupTestData(t)
+	u := createTestUser(t, "Settings User", "settings@test.com")
+	createTestUser(t, "Other User", "other@test.com")
+
+	w := serveAs(testApp.settingsEmail, u, http.MethodPost, "/settings/email", url.Values{"email": {"other@test.com"}})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), ErrDuplicateEmail.Error())
+}
+
+func TestSettingsEmail_RejectsDomainsOutsideAllowlist(t *testing.T) {
+	defer cleanupTestData(t)
+	u := createTestUser(t, "Settings User", "settings@corp.example")
+	setRegistrationPolicy(t, RegistrationPolicy{Mode: registrationDomains, Domains: []string{"corp.example"}})
+
+	w := serveAs(testApp.settingsEmail, u, http.MethodPost, "/settings/email", url.Values{"email": {"me@gmail.test"}})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "Use an email address at corp.example")
+
+	// A link sent before the allowlist changed no longer works either.
+	token, err := testApp.userRepo.CreateEmailChange(u.ID, "me@other.example")
+	require.NoError(t, err)
+	_, err = testApp.userRepo.ConfirmEmailChange(token)
+	assert.ErrorIs(t, err, ErrEmailDomainRejected)
+}
+
+func TestSettingsDelete_POST(t *testing.T) {
+	defer cleanupTestData(t)
+	u := createTestUser(t, "Settings User", "settings@test.com")
+
+	w := serveAs(testApp.settingsDelete, u, http.MethodPost, "/settings/delete", url.Values{"password": {"wrong password"}})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "Your password is not correct")
+
+	w = serveAs(testApp.settingsDelete, u, http.MethodPost, "/settings/delete", url.Values{"password": {"correct horse battery staple"}})
+	assert.Equal(t, http.StatusSeeOther, w.Code)
+	assert.Equal(t, "/", w.Header().Get("Location"))
+
+	scheduled, err := testApp.userRepo.GetUserByID(u.ID)
+	require.NoError(t, err)
+	require.NotNil(t, scheduled.DeletionScheduledAt)
+	assert.WithinDuration(t, time.Now().Add(14*24*time.Hour), *scheduled.DeletionScheduledAt, time.Minute)
+	assert.Equal(t, u.SessionEpoch+1, scheduled.SessionEpoch)
+
+	w = serveAs(testApp.settingsDeleteCancel, scheduled, http.MethodPost, "/settings/delete/cancel", nil)
+	assert.Equal(t, http.StatusSeeOther, w.Code)
+
+	kept, err := testApp.userRepo.GetUserByID(u.ID)
+	require.NoError(t, err)
+	assert.Nil(t, kept.DeletionScheduledAt)
+}
+
+func TestSettingsDelete_POST_SSOAccountConfirmsWithEmail(t *testing.T) {
+	defer cleanupTestData(t)
+	u := createTestUser(t, "SSO User", "sso@test.com")
+	require.NoError(t, testApp.userRepo.LinkIdentity(u.ID, "https://idp.test", "sub-1"))
+
+	w := serveAs(testApp.settingsDelete, u, http.MethodPost, "/settings/delete", url.Values{"confirm_email": {"other@test.com"}})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "This is not your email address")
+
+	w = serveAs(testApp.settingsDelete, u, http.MethodPost, "/settings/delete", url.Values{"confirm_email": {"SSO@test.com"}})
+	assert.Equal(t, http.StatusSeeOther, w.Code)
+
+	scheduled, err := testApp.userRepo.GetUserByID(u.ID)
+	require.NoError(t, err)
+	assert.NotNil(t, scheduled.DeletionScheduledAt)
+}
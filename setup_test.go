@@ -2,9 +2,16 @@ package main
 
 import (
 	"database/sql"
+	"fmt"
 	"io"
 	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -37,18 +44,80 @@ func setupApp(db *sql.DB) *application {
 	sess := sessions.New([]byte("super-secret-session-key-very-long-32-bytes"))
 	sess.Lifetime = 24 * time.Hour
 	app := &application{
-		errorLog:    log.New(io.Discard, "", 0),
-		infoLog:     log.New(io.Discard, "", 0),
-		userRepo:    NewSQLUserRepository(db),
-		postRepo:    NewSQLPostRepository(db),
-		templateDir: "./templates",
-		publicPath:  "./public",
-		session:     sess,
+		config:         config{authMode: authModeLocal, deletionGrace: 14 * 24 * time.Hour, exportDir: filepath.Join(os.TempDir(), "hnews-test-exports"), inviteKarma: 10},
+		errorLog:       log.New(io.Discard, "", 0),
+		infoLog:        log.New(io.Discard, "", 0),
+		userRepo:       NewSQLUserRepository(db),
+		postRepo:       NewSQLPostRepository(db),
+		oauthRepo:      NewSQLOAuthRepository(db),
+		exportRepo:     NewSQLExportRepository(db),
+		invitationRepo: NewSQLInvitationRepository(db),
+		loginRepo:      NewSQLLoginRepository(db),
+		searchRepo:     NewSQLSavedSearchRepository(db),
+		linkRepo:       NewSQLLinkCheckRepository(db),
+		snapshotRepo:   NewSQLSnapshotRepository(db),
+		templateDir:    "./templates",
+		publicPath:     "./public",
+		session:        sess,
+		passwords:      &PasswordPolicy{MinLength: 10, MinScore: 2},
+		mailer:         &testMailer{},
+		suggester:      NewSuggester(),
+		fetcher:        NewLinkFetcher(),
 	}
+	app.fetcher.AllowPrivate = true // tests fetch from httptest servers
 	app.tp = NewTemplateRenderer(app.templateDir, false)
+	app.userRepo.(*SQLUserRepository).registration = &app.config.registration
 	return app
 }
 
+// testMailer records sent messages instead of delivering them.
+type testMailer struct {
+	mutex    sync.Mutex
+	messages []testMail
+}
+
+type testMail struct {
+	To, Subject, Body string
+}
+
+func (m *testMailer) Send(to, subject, body string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.messages = append(m.messages, testMail{to, subject, body})
+	return nil
+}
+
+// last returns the most recent message sent to the address.
+func (m *testMailer) last(to string) (testMail, bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for i := len(m.messages) - 1; i >= 0; i-- {
+		if m.messages[i].To == to {
+			return m.messages[i], true
+		}
+	}
+	return testMail{}, false
+}
+
+// serveAs runs handler within a session for a request made by the logged-in
+// user u (nil for an anonymous request). Form values are sent as a POST body.
+func serveAs(handler http.HandlerFunc, u *User, method, target string, form url.Values) *httptest.ResponseRecorder {
+	var body io.Reader
+	if form != nil {
+		body = strings.NewReader(form.Encode())
+	}
+	req := httptest.NewRequest(method, target, body)
+	if form != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	if u != nil {
+		req = req.WithContext(contextWithUser(req.Context(), u))
+	}
+	w := httptest.NewRecorder()
+	testApp.session.Enable(handler).ServeHTTP(w, req)
+	return w
+}
+
 func setupTestSchema(db *sql.DB) error {
 	schema := `
 		CREATE TABLE users (
@@ -56,6 +125,13 @@ func setupTestSchema(db *sql.DB) error {
    name TEXT NOT NULL,
    email TEXT NOT NULL UNIQUE,
    hashed_password TEXT NOT NULL,
+   is_admin BOOLEAN NOT NULL DEFAULT 0,
+   status TEXT NOT NULL DEFAULT 'active',
+   karma INTEGER NOT NULL DEFAULT 0,
+   session_epoch INTEGER NOT NULL DEFAULT 0,
+   deletion_scheduled_at DATETIME,
+   invited_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+   favorites_public BOOLEAN NOT NULL DEFAULT 1,
    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
 );
 
@@ -70,7 +146,8 @@ CREATE TABLE profiles (
 CREATE TABLE posts (
    id INTEGER PRIMARY KEY AUTOINCREMENT,
    url TEXT NOT NULL,
-   title TEXT NOT NULL UNIQUE,
+   title TEXT NOT NULL,
+   site TEXT NOT NULL DEFAULT '',
    user_id INTEGER REFERENCES users(user_id) ON DELETE CASCADE,
    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
 );
@@ -90,13 +167,207 @@ CREATE TABLE votes (
    PRIMARY KEY (user_id, post_id)
 );
 
+CREATE TABLE user_identities (
+   provider TEXT NOT NULL,
+   subject TEXT NOT NULL,
+   user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+   created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+   PRIMARY KEY (provider, subject)
+);
+
+CREATE TABLE oauth_clients (
+   id TEXT PRIMARY KEY,
+   name TEXT NOT NULL,
+   redirect_uri TEXT NOT NULL,
+   secret_hash TEXT NOT NULL,
+   created_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+   created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE oauth_codes (
+   code_hash TEXT PRIMARY KEY,
+   client_id TEXT NOT NULL REFERENCES oauth_clients(id) ON DELETE CASCADE,
+   user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+   redirect_uri TEXT NOT NULL,
+   scope TEXT NOT NULL,
+   code_challenge TEXT NOT NULL,
+   expires_at DATETIME NOT NULL
+);
+
+CREATE TABLE oauth_tokens (
+   token_hash TEXT PRIMARY KEY,
+   client_id TEXT NOT NULL REFERENCES oauth_clients(id) ON DELETE CASCADE,
+   user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+   kind TEXT NOT NULL,
+   scope TEXT NOT NULL,
+   expires_at DATETIME NOT NULL,
+   revoked_at DATETIME,
+   created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE email_changes (
+   token_hash TEXT PRIMARY KEY,
+   user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+   new_email TEXT NOT NULL,
+   expires_at DATETIME NOT NULL,
+   created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE data_exports (
+   id INTEGER PRIMARY KEY AUTOINCREMENT,
+   user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+   token_hash TEXT NOT NULL UNIQUE,
+   status TEXT NOT NULL DEFAULT 'pending',
+   path TEXT NOT NULL DEFAULT '',
+   expires_at DATETIME NOT NULL,
+   created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE invitations (
+   id INTEGER PRIMARY KEY AUTOINCREMENT,
+   token_hash TEXT NOT NULL UNIQUE,
+   inviter_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+   email TEXT NOT NULL,
+   note TEXT NOT NULL DEFAULT '',
+   invitee_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+   used_at DATETIME,
+   created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE login_events (
+   id INTEGER PRIMARY KEY AUTOINCREMENT,
+   user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+   email TEXT NOT NULL,
+   success BOOLEAN NOT NULL,
+   ip TEXT NOT NULL,
+   user_agent TEXT NOT NULL,
+   device TEXT NOT NULL,
+   network TEXT NOT NULL,
+   alert_token_hash TEXT UNIQUE,
+   created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX login_events_user_id ON login_events (user_id, created_at);
+
+CREATE TABLE password_resets (
+   token_hash TEXT PRIMARY KEY,
+   user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+   expires_at DATETIME NOT NULL,
+   created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE favorite_posts (
+   user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+   post_id INTEGER NOT NULL REFERENCES posts(id) ON DELETE CASCADE,
+   created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+   PRIMARY KEY (user_id, post_id)
+);
+
+CREATE TABLE favorite_comments (
+   user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+   comment_id INTEGER NOT NULL REFERENCES comments(id) ON DELETE CASCADE,
+   created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+   PRIMARY KEY (user_id, comment_id)
+);
+
+CREATE TABLE hidden_posts (
+   user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+   post_id INTEGER NOT NULL REFERENCES posts(id) ON DELETE CASCADE,
+   created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+   PRIMARY KEY (user_id, post_id)
+);
+
+CREATE TABLE saved_searches (
+   id INTEGER PRIMARY KEY AUTOINCREMENT,
+   user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+   query TEXT NOT NULL,
+   email BOOLEAN NOT NULL DEFAULT 0,
+   last_post_id INTEGER NOT NULL DEFAULT 0,
+   last_comment_id INTEGER NOT NULL DEFAULT 0,
+   emailed_at DATETIME,
+   created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+   UNIQUE (user_id, query)
+);
+
+CREATE TABLE search_alerts (
+   id INTEGER PRIMARY KEY AUTOINCREMENT,
+   search_id INTEGER NOT NULL REFERENCES saved_searches(id) ON DELETE CASCADE,
+   user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+   post_id INTEGER NOT NULL REFERENCES posts(id) ON DELETE CASCADE,
+   comment_id INTEGER REFERENCES comments(id) ON DELETE CASCADE,
+   read_at DATETIME,
+   emailed_at DATETIME,
+   created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE site_sanctions (
+   site TEXT PRIMARY KEY,
+   status TEXT NOT NULL,
+   reason TEXT NOT NULL DEFAULT '',
+   admin_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+   created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE link_checks (
+   post_id INTEGER PRIMARY KEY REFERENCES posts(id) ON DELETE CASCADE,
+   status INTEGER NOT NULL DEFAULT 0,
+   error TEXT NOT NULL DEFAULT '',
+   failures INTEGER NOT NULL DEFAULT 0,
+   dead BOOLEAN NOT NULL DEFAULT 0,
+   checked_at DATETIME NOT NULL,
+   next_check_at DATETIME NOT NULL
+);
+
+CREATE TABLE snapshots (
+   post_id INTEGER PRIMARY KEY REFERENCES posts(id) ON DELETE CASCADE,
+   blob_key TEXT NOT NULL,
+   url TEXT NOT NULL,
+   title TEXT NOT NULL DEFAULT '',
+   size INTEGER NOT NULL DEFAULT 0,
+   created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX posts_created_at ON posts (created_at);
+CREATE INDEX posts_user_id ON posts (user_id, created_at);
+CREATE INDEX posts_site ON posts (site);
+CREATE INDEX posts_url ON posts (url);
+CREATE INDEX comments_post_id ON comments (post_id, created_at);
+CREATE INDEX comments_user_id ON comments (user_id, created_at);
+CREATE INDEX votes_post_id ON votes (post_id);
+CREATE INDEX favorite_posts_post_id ON favorite_posts (post_id);
+CREATE INDEX favorite_comments_comment_id ON favorite_comments (comment_id);
+CREATE INDEX search_alerts_user_id ON search_alerts (user_id, read_at);
+CREATE INDEX search_alerts_search_id ON search_alerts (search_id, emailed_at);
+CREATE INDEX link_checks_next_check_at ON link_checks (next_check_at);
+CREATE INDEX snapshots_created_at ON snapshots (created_at);
+
 	`
-	_, err := db.Exec(schema)
+	if _, err := db.Exec(schema); err != nil {
+		return err
+	}
+	_, err := db.Exec(fmt.Sprintf("PRAGMA user_version = %d", len(migrations)))
 	return err
 }
 
 func cleanupTestData(t *testing.T) {
 	tables := []string{
+		"snapshots",
+		"link_checks",
+		"site_sanctions",
+		"search_alerts",
+		"saved_searches",
+		"hidden_posts",
+		"favorite_comments",
+		"favorite_posts",
+		"password_resets",
+		"login_events",
+		"invitations",
+		"data_exports",
+		"email_changes",
+		"oauth_tokens",
+		"oauth_codes",
+		"oauth_clients",
+		"user_identities",
 		"profiles",
 		"votes",
 		"comments",
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// from lists the posts linking to a site, /from?site=example.com, with the
+// site's totals. Subdomains are included and "www." is ignored.
+func (app *application) from(w http.ResponseWriter, r *http.Request) {
+	site := normalizeSite(strings.TrimSpace(r.URL.Query().Get("site")))
+	if !siteRX.MatchString(site) {
+		app.session.Put(r, "flash", "That is not a site name like example.com")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	filter := Filter{
+		Page:     app.readIntWithDefault(r, "page", 1),
+		PageSize: app.readIntWithDefault(r, "page_size", 30),
+	}
+	if u, ok := r.Context().Value(contextUserKey).(*User); ok {
+		filter.ViewerID = u.ID
+	}
+	posts, metadata, err := app.postRepo.GetSitePosts(site, filter)
+	if errors.Is(err, ErrInvalidPageSize) {
+		app.session.Put(r, "flash", err.Error())
+		http.Redirect(w, r, "/from?site="+url.QueryEscape(site), http.StatusSeeOther)
+		return
+	} else if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	stats, err := app.postRepo.GetSiteStats(site)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.render(w, r, "from.html", &templateData{
+		Posts:     posts,
+		Metadata:  metadata,
+		SiteStats: stats,
+		NextLink:  fmt.Sprintf("/from?site=%s&page=%d&page_size=%d", url.QueryEscape(site), metadata.NextPage, filter.PageSize),
+		PrevLink:  fmt.Sprintf("/from?site=%s&page=%d&page_size=%d", url.QueryEscape(site), metadata.PrevPage, filter.PageSize),
+	})
+}
+
+// adminSites lists the penalized and banned sites. On POST it penalizes,
+// bans or clears the posted site depending on action.
+func (app *application) adminSites(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		sanctions, err := app.postRepo.GetSiteSanctions()
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+		app.render(w, r, "admin-sites.html", &templateData{SiteSanctions: sanctions})
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	back := safeRedirect(r.PostForm.Get("redirectTo"), "/admin/sites")
+	site := normalizeSite(strings.TrimSpace(r.PostForm.Get("site")))
+	reason := strings.TrimSpace(r.PostForm.Get("reason"))
+	if !siteRX.MatchString(site) {
+		app.session.Put(r, "flash", "That is not a site name like example.com")
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+	if len(reason) > 255 {
+		app.session.Put(r, "flash", "The reason is too long (maximum is 255 characters)")
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+
+	admin := app.getUserFromContext(r.Context())
+	var err error
+	var flash string
+	switch r.PostForm.Get("action") {
+	case "penalize":
+		err = app.postRepo.SetSiteSanction(site, siteStatusPenalized, reason, admin.ID)
+		flash = fmt.Sprintf("Posts from %s now rank below the others", site)
+	case "ban":
+		err = app.postRepo.SetSiteSanction(site, siteStatusBanned, reason, admin.ID)
+		flash = fmt.Sprintf("%s is banned", site)
+	case "clear":
+		err = app.postRepo.RemoveSiteSanction(site)
+		flash = fmt.Sprintf("%s is no longer penalized or banned", site)
+	default:
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	app.infoLog.Printf("admin %d: %s %s", admin.ID, r.PostForm.Get("action"), site)
+	app.session.Put(r, "flash", flash)
+	http.Redirect(w, r, back, http.StatusSeeOther)
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSiteOf(t *testing.T) {
+	for rawURL, want := range map[string]string{
+		"https://example.com/a":          "example.com",
+		"https://WWW.Example.com:8080/a": "example.com",
+		"http://blog.example.com":        "blog.example.com",
+		"https://www.example.com.":       "example.com",
+		"not a url %":                    "",
+	} {
+		assert.Equal(t, want, siteOf(rawURL), rawURL)
+	}
+}
+
+func TestSitePostsAndSanctions(t *testing.T) {
+	defer cleanupTestData(t)
+	u := createTestUser(t, "Poster", "poster@test.com")
+	voter := createTestUser(t, "Voter", "voter@test.com")
+	top, err := testApp.postRepo.CreatePost("Top story", "https://www.spam.com/top", u.ID)
+	require.NoError(t, err)
+	_, err = testApp.postRepo.CreatePost("Blog post", "https://blog.spam.com/post", u.ID)
+	require.NoError(t, err)
+	_, err = testApp.postRepo.CreatePost("Elsewhere", "https://notspam.com/", u.ID)
+	require.NoError(t, err)
+	require.NoError(t, testApp.postRepo.AddVote(voter.ID, top))
+	_, err = testApp.postRepo.AddComment(voter.ID, top, "First")
+	require.NoError(t, err)
+
+	posts, _, err := testApp.postRepo.GetSitePosts("spam.com", Filter{Page: 1, PageSize: 10})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Blog post", "Top story"}, postTitles(posts))
+	stats, err := testApp.postRepo.GetSiteStats("spam.com")
+	require.NoError(t, err)
+	assert.Equal(t, SiteStats{Site: "spam.com", Posts: 2, Points: 1, Comments: 1}, *stats)
+
+	require.NoError(t, testApp.postRepo.SetSiteSanction("spam.com", siteStatusPenalized, "low quality", voter.ID))
+	posts, _, err = testApp.postRepo.GetAll(Filter{Page: 1, PageSize: 10, OrderBy: orderPopular})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Elsewhere", "Top story", "Blog post"}, postTitles(posts), "penalized sites rank last")
+	posts, _, err = testApp.postRepo.GetAll(Filter{Page: 1, PageSize: 10})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Elsewhere", "Blog post", "Top story"}, postTitles(posts), "newest is not penalized")
+
+	require.NoError(t, testApp.postRepo.SetSiteSanction("blog.spam.com", siteStatusBanned, "", voter.ID))
+	_, err = testApp.postRepo.CreatePost("Another", "https://www.blog.spam.com/x", u.ID)
+	assert.ErrorIs(t, err, ErrSiteBanned)
+	_, err = testApp.postRepo.CreatePost("Allowed", "https://spam.com/ok", u.ID)
+	assert.NoError(t, err, "a ban on a subdomain leaves the parent alone")
+	posts, _, err = testApp.postRepo.GetAll(Filter{Page: 1, PageSize: 10})
+	require.NoError(t, err)
+	assert.NotContains(t, postTitles(posts), "Blog post")
+	stats, err = testApp.postRepo.GetSiteStats("blog.spam.com")
+	require.NoError(t, err)
+	assert.Equal(t, siteStatusBanned, stats.Status, "the closest sanction wins")
+
+	require.NoError(t, testApp.postRepo.RemoveSiteSanction("blog.spam.com"))
+	sanctions, err := testApp.postRepo.GetSiteSanctions()
+	require.NoError(t, err)
+	require.Len(t, sanctions, 1)
+	assert.Equal(t, "Voter", sanctions[0].AdminName)
+}
+
+func TestFromAndAdminSites(t *testing.T) {
+	defer cleanupTestData(t)
+	u := createTestUser(t, "Poster", "poster@test.com")
+	admin := createTestUser(t, "Admin", "admin@test.com")
+	_, err := testApp.postRepo.CreatePost("A post", "https://www.example.org/a", u.ID)
+	require.NoError(t, err)
+
+	w := serveAs(testApp.from, nil, http.MethodGet, "/from?site=WWW.example.org", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "Posts from example.org")
+	assert.Contains(t, w.Body.String(), "A post")
+
+	w = serveAs(testApp.from, nil, http.MethodGet, "/from?site=bad_site", nil)
+	assert.Equal(t, http.StatusSeeOther, w.Code)
+
+	form := url.Values{"site": {"www.example.org"}, "action": {"ban"}, "reason": {"spam"}, "redirectTo": {"/from?site=example.org"}}
+	w = serveAs(testApp.adminSites, admin, http.MethodPost, "/admin/sites", form)
+	assert.Equal(t, http.StatusSeeOther, w.Code)
+	assert.Equal(t, "/from?site=example.org", w.Header().Get("Location"))
+	stats, err := testApp.postRepo.GetSiteStats("example.org")
+	require.NoError(t, err)
+	assert.Equal(t, siteStatusBanned, stats.Status)
+	assert.Equal(t, "spam", stats.Reason)
+
+	w = serveAs(testApp.submit, u, http.MethodPost, "/submit", url.Values{"title": {"Again"}, "url": {"https://example.org/b"}})
+	assert.Contains(t, w.Body.String(), ErrSiteBanned.Error())
+
+	form = url.Values{"site": {"example.org"}, "action": {"clear"}}
+	serveAs(testApp.adminSites, admin, http.MethodPost, "/admin/sites", form)
+	stats, err = testApp.postRepo.GetSiteStats("example.org")
+	require.NoError(t, err)
+	assert.Empty(t, stats.Status)
+}
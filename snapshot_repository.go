@@ -0,0 +1,85 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+var ErrSnapshotNotFound = errors.New("snapshot not found")
+
+// Snapshot is a readable copy of a post's page, kept in a BlobStore under
+// Key. URL is where the link ended up after redirects.
+type Snapshot struct {
+	PostID    int
+	Key       string
+	URL       string
+	Title     string
+	Size      int
+	CreatedAt time.Time
+}
+
+type SnapshotRepository interface {
+	SaveSnapshot(s Snapshot) error
+	GetSnapshot(postID int) (*Snapshot, error)
+	GetSnapshotsBefore(before time.Time, limit int) ([]Snapshot, error)
+	DeleteSnapshot(postID int) error
+}
+
+type SQLSnapshotRepository struct {
+	db *sql.DB
+}
+
+// NewSQLSnapshotRepository creates a new instance of SQLSnapshotRepository
+func NewSQLSnapshotRepository(db *sql.DB) *SQLSnapshotRepository {
+	return &SQLSnapshotRepository{db: db}
+}
+
+const snapshotSelect = "SELECT post_id, blob_key, url, title, size, created_at FROM snapshots"
+
+func (r *SQLSnapshotRepository) SaveSnapshot(s Snapshot) error {
+	stmt := `INSERT INTO snapshots (post_id, blob_key, url, title, size) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (post_id) DO UPDATE SET blob_key = excluded.blob_key, url = excluded.url,
+		title = excluded.title, size = excluded.size, created_at = CURRENT_TIMESTAMP`
+	_, err := r.db.Exec(stmt, s.PostID, s.Key, s.URL, s.Title, s.Size)
+	return err
+}
+
+func (r *SQLSnapshotRepository) GetSnapshot(postID int) (*Snapshot, error) {
+	var s Snapshot
+	err := r.db.QueryRow(snapshotSelect+" WHERE post_id = ?", postID).
+		Scan(&s.PostID, &s.Key, &s.URL, &s.Title, &s.Size, &s.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrSnapshotNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// GetSnapshotsBefore returns up to limit snapshots taken before before,
+// oldest first.
+func (r *SQLSnapshotRepository) GetSnapshotsBefore(before time.Time, limit int) ([]Snapshot, error) {
+	rows, err := r.db.Query(snapshotSelect+" WHERE created_at < ? ORDER BY created_at LIMIT ?",
+		before.UTC().Format(sqliteTimeLayout), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var snapshots []Snapshot
+	for rows.Next() {
+		var s Snapshot
+		if err := rows.Scan(&s.PostID, &s.Key, &s.URL, &s.Title, &s.Size, &s.CreatedAt); err != nil {
+			return nil, err
+		}
+		snapshots = append(snapshots, s)
+	}
+	return snapshots, rows.Err()
+}
+
+func (r *SQLSnapshotRepository) DeleteSnapshot(postID int) error {
+	_, err := r.db.Exec("DELETE FROM snapshots WHERE post_id = ?", postID)
+	return err
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// snapshotExpireBatch is how many snapshots one run of the retention job
+// removes.
+const snapshotExpireBatch = 500
+
+// Snapshotter keeps a readable copy of each submitted page so that the
+// discussion keeps its context when the link dies. Only the text of HTML
+// and plain text pages is kept, at most MaxBytes of it; markup, scripts and
+// images are dropped.
+type Snapshotter struct {
+	Store    BlobStore
+	Fetcher  *LinkFetcher
+	MaxBytes int
+}
+
+func NewSnapshotter(store BlobStore, fetcher *LinkFetcher) *Snapshotter {
+	return &Snapshotter{Store: store, Fetcher: fetcher, MaxBytes: 256 << 10}
+}
+
+var (
+	// snapshotDropRX matches the parts of a page that are not its text.
+	snapshotDropRX = func() *regexp.Regexp {
+		alternatives := []string{`<!--.*?-->`}
+		for _, tag := range []string{"head", "title", "script", "style", "noscript", "template", "svg", "iframe", "object", "nav", "form"} {
+			alternatives = append(alternatives, `<`+tag+`\b.*?</`+tag+`\s*>`)
+		}
+		return regexp.MustCompile(`(?is)` + strings.Join(alternatives, "|"))
+	}()
+	// snapshotBlockRX matches the tags that start or end a paragraph.
+	snapshotBlockRX = regexp.MustCompile(`(?i)</?(p|div|br|hr|h[1-6]|li|dt|dd|tr|table|blockquote|pre|section|article|header|footer|main|aside|figure|figcaption)\b[^>]*>`)
+	anyTagRX        = regexp.MustCompile(`(?s)<[^>]*>`)
+	paragraphRX     = regexp.MustCompile(`\n\s*\n`)
+)
+
+// snapshotParagraphs returns the readable text of a page as paragraphs.
+func snapshotParagraphs(p *fetchedPage) []string {
+	text := p.text()
+	if p.isHTML() {
+		text = snapshotDropRX.ReplaceAllString(text, "")
+		text = snapshotBlockRX.ReplaceAllString(text, "\n\n")
+		text = anyTagRX.ReplaceAllString(text, "")
+	}
+	var paragraphs []string
+	for _, para := range paragraphRX.Split(strings.ReplaceAll(text, "\r\n", "\n"), -1) {
+		if p.isHTML() {
+			para = cleanText(para)
+		} else {
+			para = strings.TrimSpace(para)
+		}
+		if para != "" {
+			paragraphs = append(paragraphs, para)
+		}
+	}
+	return paragraphs
+}
+
+// limitParagraphs keeps whole paragraphs until the text would exceed max
+// bytes. A paragraph that is too long on its own is cut at a rune boundary.
+func limitParagraphs(paragraphs []string, max int) []string {
+	size := 0
+	for i, para := range paragraphs {
+		if size+len(para) > max {
+			if i > 0 {
+				return paragraphs[:i]
+			}
+			cut := max
+			for cut > 0 && !utf8.RuneStart(para[cut]) {
+				cut--
+			}
+			return []string{para[:cut]}
+		}
+		size += len(para) + 2 // the blank line between paragraphs
+	}
+	return paragraphs
+}
+
+// takeSnapshot fetches a post's link and stores a copy of its text. Pages
+// that are neither HTML nor plain text, or have no text, are skipped.
+func (app *application) takeSnapshot(ctx context.Context, postID int, link string) error {
+	s := app.snapshots
+	p, err := s.Fetcher.fetchPage(ctx, link)
+	if err != nil {
+		return err
+	}
+	if !p.isHTML() && p.contentType != "text/plain" {
+		return nil
+	}
+	paragraphs := limitParagraphs(snapshotParagraphs(p), s.MaxBytes)
+	if len(paragraphs) == 0 {
+		return nil
+	}
+
+	snapshot := Snapshot{
+		PostID: postID,
+		Key:    fmt.Sprintf("snapshots/%d.txt", postID),
+		URL:    p.url.String(),
+	}
+	if p.isHTML() {
+		var meta LinkMetadata
+		parseHTMLMetadata(&meta, p.text(), p.url)
+		snapshot.Title = meta.Title
+	}
+	data := []byte(strings.Join(paragraphs, "\n\n"))
+	snapshot.Size = len(data)
+	if err := s.Store.Put(snapshot.Key, data); err != nil {
+		return err
+	}
+	if err := app.snapshotRepo.SaveSnapshot(snapshot); err != nil {
+		s.Store.Delete(snapshot.Key)
+		return err
+	}
+	return nil
+}
+
+// snapshotPost takes the snapshot of a new post in the background, if
+// snapshots are enabled.
+func (app *application) snapshotPost(postID int, link string) {
+	if app.snapshots == nil {
+		return
+	}
+	app.background(func() {
+		if err := app.takeSnapshot(context.Background(), postID, link); err != nil {
+			app.infoLog.Printf("snapshot of post %d: %v", postID, err)
+		}
+	})
+}
+
+// expireSnapshots removes the snapshots that are older than the retention
+// period.
+func (app *application) expireSnapshots(ctx context.Context) error {
+	snapshots, err := app.snapshotRepo.GetSnapshotsBefore(time.Now().Add(-app.config.snapshotRetention), snapshotExpireBatch)
+	if err != nil {
+		return err
+	}
+	for _, s := range snapshots {
+		if err := app.snapshots.Store.Delete(s.Key); err != nil && !errors.Is(err, ErrBlobNotFound) {
+			return err
+		}
+		if err := app.snapshotRepo.DeleteSnapshot(s.PostID); err != nil {
+			return err
+		}
+	}
+	if len(snapshots) > 0 {
+		app.infoLog.Printf("removed %d expired snapshots", len(snapshots))
+	}
+	return nil
+}
+
+// cached shows the stored copy of a post's page: /cached?post_id=.
+func (app *application) cached(w http.ResponseWriter, r *http.Request) {
+	postID := app.readIntWithDefault(r, "post_id", 0)
+	post, err := app.postRepo.GetByID(postID)
+	if err != nil {
+		app.session.Put(r, "flash", "post not found")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	var snapshot *Snapshot
+	var data []byte
+	err = ErrSnapshotNotFound
+	if app.snapshots != nil {
+		snapshot, err = app.snapshotRepo.GetSnapshot(postID)
+		if err == nil {
+			data, err = app.snapshots.Store.Get(snapshot.Key)
+		}
+	}
+	if errors.Is(err, ErrSnapshotNotFound) || errors.Is(err, ErrBlobNotFound) {
+		app.session.Put(r, "flash", "There is no cached copy of this page")
+		http.Redirect(w, r, fmt.Sprintf("/comments?post_id=%d", postID), http.StatusSeeOther)
+		return
+	} else if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.render(w, r, "cached.html", &templateData{
+		Post:       post,
+		Snapshot:   snapshot,
+		Paragraphs: strings.Split(string(data), "\n\n"),
+	})
+}
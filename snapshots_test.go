@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileBlobStore(t *testing.T) {
+	store := NewFileBlobStore(t.TempDir())
+	require.NoError(t, store.Put("snapshots/1.txt", []byte("first")))
+	require.NoError(t, store.Put("snapshots/1.txt", []byte("second")))
+	data, err := store.Get("snapshots/1.txt")
+	require.NoError(t, err)
+	assert.Equal(t, "second", string(data))
+
+	require.NoError(t, store.Delete("snapshots/1.txt"))
+	_, err = store.Get("snapshots/1.txt")
+	assert.ErrorIs(t, err, ErrBlobNotFound)
+	assert.ErrorIs(t, store.Delete("snapshots/1.txt"), ErrBlobNotFound)
+
+	for _, key := range []string{"", "../outside", "a/../../b", "/abs", ".hidden", "a//b", "Upper"} {
+		assert.ErrorIs(t, store.Put(key, nil), ErrInvalidKey, key)
+	}
+}
+
+func TestSnapshotParagraphs(t *testing.T) {
+	page := &fetchedPage{contentType: "text/html", body: []byte(`<html><head><title>T</title>
+<style>p { color: red }</style></head>
+<body><nav><a href="/">Home</a></nav>
+<h1>The   headline</h1>
+<script>alert("x")</script>
+<p>First <b>bold</b> &amp; <a href="/x">linked</a>
+paragraph.</p><p>Second<br>line</p>
+<img src="x.png" onerror="alert(1)">
+<!-- a comment -->
+</body></html>`)}
+	assert.Equal(t, []string{"The headline", "First bold & linked paragraph.", "Second", "line"}, snapshotParagraphs(page))
+
+	page = &fetchedPage{contentType: "text/plain", body: []byte("One\n  indented\r\n\r\nTwo <b>not markup</b>\n")}
+	assert.Equal(t, []string{"One\n  indented", "Two <b>not markup</b>"}, snapshotParagraphs(page))
+
+	assert.Equal(t, []string{"aaaa", "bbbb"}, limitParagraphs([]string{"aaaa", "bbbb", "cccc"}, 12))
+	assert.Equal(t, []string{"ab"}, limitParagraphs([]string{"abé"}, 3), "long paragraphs are cut between runes")
+}
+
+func TestSnapshots(t *testing.T) {
+	defer cleanupTestData(t)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/article":
+			w.Write([]byte(`<title>Article</title><p>Worth <i>keeping</i>.</p><script>evil()</script>`))
+		case "/image":
+			w.Header().Set("Content-Type", "image/png")
+			w.Write([]byte("\x89PNG"))
+		}
+	}))
+	defer srv.Close()
+	testApp.snapshots = NewSnapshotter(NewFileBlobStore(t.TempDir()), testApp.fetcher)
+	defer func() { testApp.snapshots, testApp.config.snapshotRetention = nil, 0 }()
+	u := createTestUser(t, "Poster", "poster@test.com")
+
+	serveAs(testApp.submit, u, http.MethodPost, "/submit", url.Values{"title": {"Article"}, "url": {srv.URL + "/article"}})
+	serveAs(testApp.submit, u, http.MethodPost, "/submit", url.Values{"title": {"Image"}, "url": {srv.URL + "/image"}})
+	testApp.wg.Wait()
+	posts, _, err := testApp.postRepo.GetAll(Filter{Page: 1, PageSize: 10})
+	require.NoError(t, err)
+	require.Len(t, posts, 2)
+	image, article := posts[0].ID, posts[1].ID
+
+	snapshot, err := testApp.snapshotRepo.GetSnapshot(article)
+	require.NoError(t, err)
+	assert.Equal(t, "Article", snapshot.Title)
+	assert.Equal(t, len("Worth keeping."), snapshot.Size)
+	_, err = testApp.snapshotRepo.GetSnapshot(image)
+	assert.ErrorIs(t, err, ErrSnapshotNotFound, "only text is kept")
+
+	w := serveAs(testApp.comments, u, http.MethodGet, "/comments?post_id="+strconv.Itoa(article), nil)
+	assert.Contains(t, w.Body.String(), "/cached?post_id="+strconv.Itoa(article))
+	w = serveAs(testApp.cached, nil, http.MethodGet, "/cached?post_id="+strconv.Itoa(article), nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "<p>Worth keeping.</p>")
+	assert.False(t, strings.Contains(w.Body.String(), "evil()"))
+	w = serveAs(testApp.cached, nil, http.MethodGet, "/cached?post_id="+strconv.Itoa(image), nil)
+	assert.Equal(t, http.StatusSeeOther, w.Code)
+
+	testApp.config.snapshotRetention = 24 * time.Hour
+	require.NoError(t, testApp.expireSnapshots(context.Background()))
+	_, err = testApp.snapshotRepo.GetSnapshot(article)
+	require.NoError(t, err, "new snapshots are kept")
+	_, err = testDB.Exec("UPDATE snapshots SET created_at = '2000-01-01 00:00:00'")
+	require.NoError(t, err)
+	require.NoError(t, testApp.expireSnapshots(context.Background()))
+	_, err = testApp.snapshotRepo.GetSnapshot(article)
+	assert.ErrorIs(t, err, ErrSnapshotNotFound)
+	_, err = testApp.snapshots.Store.Get(snapshot.Key)
+	assert.ErrorIs(t, err, ErrBlobNotFound)
+}
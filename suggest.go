@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	suggestTitle  = "title"
+	suggestUser   = "user"
+	suggestDomain = "domain"
+)
+
+const (
+	// suggestPerKind is how many titles, users and domains a prefix
+	// suggests at most.
+	suggestPerKind = 5
+	// suggestKeyLength is how many bytes of a key the trie indexes; longer
+	// prefixes are looked up by their start and then filtered.
+	suggestKeyLength = 16
+	// suggestPosts is how many of the most voted posts suggest titles.
+	suggestPosts = 5000
+)
+
+// Suggestion is a completion offered for the search box. Query is what the
+// search box is set to when it is chosen and URL the page it leads to.
+type Suggestion struct {
+	Kind  string `json:"kind"`
+	Text  string `json:"text"`
+	Query string `json:"query"`
+	URL   string `json:"url"`
+	// weight ranks suggestions of the same kind: votes for titles, karma
+	// for users and the number of posts for domains.
+	weight int
+}
+
+// suggestTrie maps the lowercased prefixes of its keys to the suggestions
+// with the highest weight. Every node keeps its best suggestions, so a
+// lookup only walks the prefix.
+type suggestTrie struct {
+	suggestions []Suggestion
+	root        trieNode
+}
+
+type trieNode struct {
+	label    byte
+	children []*trieNode
+	top      []int // indexes into suggestions, best first
+}
+
+func (n *trieNode) child(b byte, create bool) *trieNode {
+	for _, c := range n.children {
+		if c.label == b {
+			return c
+		}
+	}
+	if !create {
+		return nil
+	}
+	c := &trieNode{label: b}
+	n.children = append(n.children, c)
+	return c
+}
+
+// newSuggestTrie indexes suggestions under the keys keys returns for each
+// of them.
+func newSuggestTrie(suggestions []Suggestion, keys func(Suggestion) []string) *suggestTrie {
+	sort.SliceStable(suggestions, func(i, j int) bool { return suggestions[i].weight > suggestions[j].weight })
+	t := &suggestTrie{suggestions: suggestions}
+	for i, s := range suggestions {
+		for _, key := range keys(s) {
+			if len(key) > suggestKeyLength {
+				key = key[:suggestKeyLength]
+			}
+			n := &t.root
+			for j := 0; j < len(key); j++ {
+				n = n.child(key[j], true)
+				// suggestions are added best first, so the first ones
+				// to reach a node are its best
+				if len(n.top) < suggestPerKind*2 && (len(n.top) == 0 || n.top[len(n.top)-1] != i) {
+					n.top = append(n.top, i)
+				}
+			}
+		}
+	}
+	return t
+}
+
+// lookup returns the best suggestions with a key starting with prefix,
+// which must be lowercase.
+func (t *suggestTrie) lookup(prefix string, keys func(Suggestion) []string) []Suggestion {
+	n := &t.root
+	for j := 0; j < len(prefix) && j < suggestKeyLength; j++ {
+		if n = n.child(prefix[j], false); n == nil {
+			return nil
+		}
+	}
+	var found []Suggestion
+	for _, i := range n.top {
+		s := t.suggestions[i]
+		if len(prefix) > suggestKeyLength && !hasKeyPrefix(keys(s), prefix) {
+			continue
+		}
+		if found = append(found, s); len(found) == suggestPerKind {
+			break
+		}
+	}
+	return found
+}
+
+func hasKeyPrefix(keys []string, prefix string) bool {
+	for _, key := range keys {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// titleKeys indexes a title from the start of each of its words, so that
+// "go" suggests "Learning Go".
+func titleKeys(s Suggestion) []string {
+	title := strings.ToLower(s.Text)
+	var keys []string
+	prev := ' '
+	for i, r := range title {
+		word := unicode.IsLetter(r) || unicode.IsDigit(r)
+		if word && !(unicode.IsLetter(prev) || unicode.IsDigit(prev)) {
+			keys = append(keys, title[i:])
+		}
+		prev = r
+	}
+	return keys
+}
+
+// nameKeys indexes a user or domain by its whole lowercased name.
+func nameKeys(s Suggestion) []string {
+	return []string{strings.ToLower(s.Text)}
+}
+
+// searchPhrase quotes s as a search phrase. Phrases cannot hold quotes, so
+// those are dropped.
+func searchPhrase(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, "") + `"`
+}
+
+// Suggester answers prefix lookups for the search box from tries that are
+// rebuilt in the background.
+type Suggester struct {
+	mutex   sync.RWMutex
+	titles  *suggestTrie
+	users   *suggestTrie
+	domains *suggestTrie
+}
+
+func NewSuggester() *Suggester {
+	return &Suggester{
+		titles:  newSuggestTrie(nil, titleKeys),
+		users:   newSuggestTrie(nil, nameKeys),
+		domains: newSuggestTrie(nil, nameKeys),
+	}
+}
+
+// Rebuild replaces the suggestions with those from posts and users.
+func (s *Suggester) Rebuild(posts []Post, users []*User) {
+	var titles, names, domains []Suggestion
+	postsBySite := map[string]int{}
+	for _, p := range posts {
+		titles = append(titles, Suggestion{Kind: suggestTitle, Text: p.Title, Query: searchPhrase(p.Title),
+			URL: fmt.Sprintf("/comments?post_id=%d", p.ID), weight: p.VoteCount})
+		if site := p.Site(); siteRX.MatchString(site) {
+			postsBySite[site]++
+		}
+	}
+	for site, n := range postsBySite {
+		domains = append(domains, Suggestion{Kind: suggestDomain, Text: site, Query: "site:" + site,
+			URL: "/from?site=" + site, weight: n})
+	}
+	// map order would make ties between domains random
+	sort.Slice(domains, func(i, j int) bool { return domains[i].Text < domains[j].Text })
+	for _, u := range users {
+		query := "author:" + u.Name
+		if strings.ContainsAny(u.Name, ` "`) {
+			query = "author:" + searchPhrase(u.Name)
+		}
+		names = append(names, Suggestion{Kind: suggestUser, Text: u.Name, Query: query,
+			URL: fmt.Sprintf("/user?id=%d", u.ID), weight: u.Karma})
+	}
+
+	t, n, d := newSuggestTrie(titles, titleKeys), newSuggestTrie(names, nameKeys), newSuggestTrie(domains, nameKeys)
+	s.mutex.Lock()
+	s.titles, s.users, s.domains = t, n, d
+	s.mutex.Unlock()
+}
+
+// Suggest returns the titles, users and domains starting with prefix.
+func (s *Suggester) Suggest(prefix string) []Suggestion {
+	prefix = strings.ToLower(strings.TrimSpace(prefix))
+	if prefix == "" {
+		return nil
+	}
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	var found []Suggestion
+	found = append(found, s.titles.lookup(prefix, titleKeys)...)
+	found = append(found, s.users.lookup(prefix, nameKeys)...)
+	found = append(found, s.domains.lookup(prefix, nameKeys)...)
+	return found
+}
+
+// rebuildSuggestions reloads the search suggestions from the database.
+func (app *application) rebuildSuggestions(ctx context.Context) error {
+	posts, err := app.postRepo.GetTopPosts(suggestPosts)
+	if err != nil {
+		return err
+	}
+	users, err := app.userRepo.GetUsersByStatus(userStatusActive)
+	if err != nil {
+		return err
+	}
+	app.suggester.Rebuild(posts, users)
+	return nil
+}
+
+// searchSuggest returns suggestions for the search box as JSON:
+// /search/suggest?q=prefix.
+func (app *application) searchSuggest(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query().Get("q")
+	if utf8.RuneCountInString(q) > 100 {
+		app.writeJSON(w, http.StatusBadRequest, map[string]string{"error": "q is too long"})
+		return
+	}
+	suggestions := app.suggester.Suggest(q)
+	if suggestions == nil {
+		suggestions = []Suggestion{}
+	}
+	app.writeJSON(w, http.StatusOK, map[string]any{"suggestions": suggestions})
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func suggestionTexts(suggestions []Suggestion, kind string) []string {
+	var texts []string
+	for _, s := range suggestions {
+		if s.Kind == kind {
+			texts = append(texts, s.Text)
+		}
+	}
+	return texts
+}
+
+func TestSuggester(t *testing.T) {
+	s := NewSuggester()
+	assert.Empty(t, s.Suggest("go"))
+
+	s.Rebuild([]Post{
+		{ID: 1, Title: "Learning Go", URL: "https://www.golang.org/doc", VoteCount: 3},
+		{ID: 2, Title: "Go generics explained", URL: "https://blog.example.com/generics", VoteCount: 9},
+		{ID: 3, Title: `The "Gopher" mascot`, URL: "https://golang.org/gopher", VoteCount: 1},
+		{ID: 4, Title: "Rust in 2024", URL: "https://rust-lang.org", VoteCount: 5},
+	}, []*User{
+		{ID: 7, Name: "Gordon", Karma: 2},
+		{ID: 8, Name: "Goldie Hawn", Karma: 20},
+	})
+
+	found := s.Suggest(" GO")
+	assert.Equal(t, []string{"Go generics explained", "Learning Go", `The "Gopher" mascot`}, suggestionTexts(found, suggestTitle))
+	assert.Equal(t, []string{"Goldie Hawn", "Gordon"}, suggestionTexts(found, suggestUser))
+	assert.Equal(t, []string{"golang.org"}, suggestionTexts(found, suggestDomain), "www. is ignored")
+	assert.Equal(t, []string{`The "Gopher" mascot`}, suggestionTexts(s.Suggest("goph"), suggestTitle))
+
+	byText := map[string]Suggestion{}
+	for _, f := range found {
+		byText[f.Text] = f
+	}
+	assert.Equal(t, `"Learning Go"`, byText["Learning Go"].Query)
+	assert.Equal(t, "/comments?post_id=1", byText["Learning Go"].URL)
+	assert.Equal(t, `author:"Goldie Hawn"`, byText["Goldie Hawn"].Query)
+	assert.Equal(t, "author:Gordon", byText["Gordon"].Query)
+	assert.Equal(t, "site:golang.org", byText["golang.org"].Query)
+	assert.Equal(t, "/from?site=golang.org", byText["golang.org"].URL)
+
+	// prefixes longer than the trie's keys are filtered after the lookup
+	assert.Equal(t, []string{"Go generics explained"}, suggestionTexts(s.Suggest("go generics explained"), suggestTitle))
+	assert.Empty(t, s.Suggest("go generics explored"))
+	assert.Empty(t, s.Suggest("python"))
+}
+
+func TestSuggester_LimitsPerKind(t *testing.T) {
+	var posts []Post
+	for i := 0; i < 20; i++ {
+		posts = append(posts, Post{ID: i, Title: "Post " + strings.Repeat("x", i), URL: "https://example.com", VoteCount: i})
+	}
+	s := NewSuggester()
+	s.Rebuild(posts, nil)
+	found := s.Suggest("post")
+	require.Len(t, found, suggestPerKind)
+	assert.Equal(t, "Post "+strings.Repeat("x", 19), found[0].Text, "most voted first")
+}
+
+func TestSearchSuggest(t *testing.T) {
+	defer cleanupTestData(t)
+	u := createTestUser(t, "Suggested", "suggested@test.com")
+	_, err := testApp.postRepo.CreatePost("Suggestions in practice", "https://sugar.example.com/x", u.ID)
+	require.NoError(t, err)
+	require.NoError(t, testApp.rebuildSuggestions(context.Background()))
+
+	w := serveAs(testApp.searchSuggest, nil, http.MethodGet, "/search/suggest?q=sug", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var body struct {
+		Suggestions []Suggestion `json:"suggestions"`
+	}
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&body))
+	assert.Equal(t, []string{"Suggestions in practice"}, suggestionTexts(body.Suggestions, suggestTitle))
+	assert.Equal(t, []string{"Suggested"}, suggestionTexts(body.Suggestions, suggestUser))
+
+	w = serveAs(testApp.searchSuggest, nil, http.MethodGet, "/search/suggest?q=", nil)
+	assert.JSONEq(t, `{"suggestions": []}`, w.Body.String())
+	w = serveAs(testApp.searchSuggest, nil, http.MethodGet, "/search/suggest?q="+strings.Repeat("a", 101), nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestRebuildSuggestions_SkipsBannedSites(t *testing.T) {
+	defer cleanupTestData(t)
+	u := createTestUser(t, "Banner", "banner@test.com")
+	_, err := testApp.postRepo.CreatePost("Sugar rush", "https://sugar.example.com/x", u.ID)
+	require.NoError(t, err)
+	_, err = testApp.postRepo.CreatePost("Sugar scam", "https://scam.example.com/x", u.ID)
+	require.NoError(t, err)
+	require.NoError(t, testApp.postRepo.SetSiteSanction("scam.example.com", siteStatusBanned, "spam", u.ID))
+	require.NoError(t, testApp.rebuildSuggestions(context.Background()))
+
+	found := testApp.suggester.Suggest("sugar")
+	assert.Equal(t, []string{"Sugar rush"}, suggestionTexts(found, suggestTitle))
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// memberFromQuery loads the user named by the id query parameter, falling
+// back to the logged-in user, or redirects home and returns nil.
+func (app *application) memberFromQuery(w http.ResponseWriter, r *http.Request) *User {
+	id := app.readIntWithDefault(r, "id", 0)
+	if viewer, ok := r.Context().Value(contextUserKey).(*User); ok && id == 0 {
+		id = viewer.ID
+	}
+	member, err := app.userRepo.GetUserByID(id)
+	if err != nil || member.Status == userStatusDeleted {
+		app.session.Put(r, "flash", "user not found")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return nil
+	}
+	return member
+}
+
+// userFilter reads the pagination of a per-user listing.
+func (app *application) userFilter(r *http.Request) Filter {
+	filter := Filter{
+		Page:     app.readIntWithDefault(r, "page", 1),
+		PageSize: app.readIntWithDefault(r, "page_size", 10),
+	}
+	if viewer, ok := r.Context().Value(contextUserKey).(*User); ok {
+		filter.ViewerID = viewer.ID
+	}
+	return filter
+}
+
+// pageLinks sets the previous and next page links of a per-user listing.
+func pageLinks(data *templateData, path string, memberID int, filter Filter) {
+	data.NextLink = fmt.Sprintf("%s?id=%d&page=%d&page_size=%d", path, memberID, data.Metadata.NextPage, filter.PageSize)
+	data.PrevLink = fmt.Sprintf("%s?id=%d&page=%d&page_size=%d", path, memberID, data.Metadata.PrevPage, filter.PageSize)
+}
+
+// submitted lists the posts a user submitted.
+func (app *application) submitted(w http.ResponseWriter, r *http.Request) {
+	member := app.memberFromQuery(w, r)
+	if member == nil {
+		return
+	}
+	filter := app.userFilter(r)
+	posts, metadata, err := app.postRepo.GetPostsByUser(member.ID, filter)
+	if errors.Is(err, ErrInvalidPageSize) {
+		app.session.Put(r, "flash", err.Error())
+		http.Redirect(w, r, fmt.Sprintf("/user?id=%d", member.ID), http.StatusSeeOther)
+		return
+	} else if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	data := &templateData{
+		Member:    member,
+		PageTitle: fmt.Sprintf("%s's submissions", member.Name),
+		Posts:     posts,
+		Metadata:  metadata,
+	}
+	pageLinks(data, "/submitted", member.ID, filter)
+	app.render(w, r, "user-posts.html", data)
+}
+
+// upvoted lists the posts the logged-in user voted for. Votes are private,
+// so nobody can see anyone else's.
+func (app *application) upvoted(w http.ResponseWriter, r *http.Request) {
+	u := app.getUserFromContext(r.Context())
+	if id := app.readIntWithDefault(r, "id", u.ID); id != u.ID {
+		app.session.Put(r, "flash", "You can only see your own upvoted posts")
+		http.Redirect(w, r, fmt.Sprintf("/upvoted?id=%d", u.ID), http.StatusSeeOther)
+		return
+	}
+	filter := app.userFilter(r)
+	posts, metadata, err := app.postRepo.GetUpvotedPosts(u.ID, filter)
+	if errors.Is(err, ErrInvalidPageSize) {
+		app.session.Put(r, "flash", err.Error())
+		http.Redirect(w, r, fmt.Sprintf("/user?id=%d", u.ID), http.StatusSeeOther)
+		return
+	} else if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	data := &templateData{
+		Member:    u,
+		PageTitle: "Your upvoted posts",
+		Posts:     posts,
+		Metadata:  metadata,
+	}
+	pageLinks(data, "/upvoted", u.ID, filter)
+	app.render(w, r, "user-posts.html", data)
+}
+
+// threads lists a user's comments, each with the comments that followed it.
+func (app *application) threads(w http.ResponseWriter, r *http.Request) {
+	member := app.memberFromQuery(w, r)
+	if member == nil {
+		return
+	}
+	filter := app.userFilter(r)
+	threads, metadata, err := app.postRepo.GetThreads(member.ID, filter)
+	if errors.Is(err, ErrInvalidPageSize) {
+		app.session.Put(r, "flash", err.Error())
+		http.Redirect(w, r, fmt.Sprintf("/user?id=%d", member.ID), http.StatusSeeOther)
+		return
+	} else if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	data := &templateData{
+		Member:   member,
+		Threads:  threads,
+		Metadata: metadata,
+	}
+	pageLinks(data, "/threads", member.ID, filter)
+	app.render(w, r, "threads.html", data)
+}
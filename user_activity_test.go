@@ -0,0 +1,80 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSubmitted_ListsUsersPostsPaginated(t *testing.T) {
+	defer cleanupTestData(t)
+	author := createTestUser(t, "Prolific", "prolific@test.com")
+	other := createTestUser(t, "Other", "other@test.com")
+	for _, title := range []string{"Post one", "Post two", "Post three"} {
+		_, err := testApp.postRepo.CreatePost(title, "https://example.com/"+title, author.ID)
+		require.NoError(t, err)
+	}
+	_, err := testApp.postRepo.CreatePost("Not theirs", "https://example.org", other.ID)
+	require.NoError(t, err)
+
+	posts, meta, err := testApp.postRepo.GetPostsByUser(author.ID, Filter{Page: 1, PageSize: 2})
+	require.NoError(t, err)
+	assert.Len(t, posts, 2)
+	assert.Equal(t, 3, meta.TotalRecords)
+	assert.Equal(t, 2, meta.LastPage)
+
+	w := serveAs(testApp.submitted, nil, http.MethodGet, "/submitted?id="+strconv.Itoa(author.ID)+"&page=2&page_size=2", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "Prolific&#39;s submissions")
+	assert.Contains(t, w.Body.String(), "Post one")
+	assert.NotContains(t, w.Body.String(), "Post three")
+	assert.NotContains(t, w.Body.String(), "Not theirs")
+}
+
+func TestThreads_ShowRepliesInContext(t *testing.T) {
+	defer cleanupTestData(t)
+	talker := createTestUser(t, "Talker", "talker@test.com")
+	replier := createTestUser(t, "Replier", "replier@test.com")
+	postID, err := testApp.postRepo.CreatePost("Discussion", "https://example.com", replier.ID)
+	require.NoError(t, err)
+	_, err = testApp.postRepo.AddComment(replier.ID, postID, "Before the talker")
+	require.NoError(t, err)
+	_, err = testApp.postRepo.AddComment(talker.ID, postID, "The talker speaks")
+	require.NoError(t, err)
+	_, err = testApp.postRepo.AddComment(replier.ID, postID, "A reply to the talker")
+	require.NoError(t, err)
+
+	threads, meta, err := testApp.postRepo.GetThreads(talker.ID, Filter{Page: 1, PageSize: 10})
+	require.NoError(t, err)
+	require.Len(t, threads, 1)
+	assert.Equal(t, 1, meta.TotalRecords)
+	assert.Equal(t, "Discussion", threads[0].PostTitle)
+	require.Len(t, threads[0].Replies, 1)
+	assert.Equal(t, "A reply to the talker", threads[0].Replies[0].Body)
+
+	w := serveAs(testApp.threads, nil, http.MethodGet, "/threads?id="+strconv.Itoa(talker.ID), nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "The talker speaks")
+	assert.Contains(t, w.Body.String(), "A reply to the talker")
+	assert.NotContains(t, w.Body.String(), "Before the talker")
+}
+
+func TestUpvoted_OnlyOwn(t *testing.T) {
+	defer cleanupTestData(t)
+	voter := createTestUser(t, "Voter", "voter@test.com")
+	other := createTestUser(t, "Other", "other@test.com")
+	postID, err := testApp.postRepo.CreatePost("Upvoted story", "https://example.com", other.ID)
+	require.NoError(t, err)
+	require.NoError(t, testApp.postRepo.AddVote(voter.ID, postID))
+
+	w := serveAs(testApp.upvoted, voter, http.MethodGet, "/upvoted", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "Upvoted story")
+
+	w = serveAs(testApp.upvoted, other, http.MethodGet, "/upvoted?id="+strconv.Itoa(voter.ID), nil)
+	assert.Equal(t, http.StatusSeeOther, w.Code)
+	assert.Equal(t, "/upvoted?id="+strconv.Itoa(other.ID), w.Header().Get("Location"))
+}
@@ -4,69 +4,177 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"io"
+	"log"
+	"strings"
+	"time"
 
 	_ "github.com/mattn/go-sqlite3"
-	"golang.org/x/crypto/bcrypt"
 )
 
-var ErrInvalidCredential = errors.New("invalid credentials")
+var (
+	ErrInvalidCredential = errors.New("invalid credentials")
+	ErrDuplicateEmail    = errors.New("email address is already in use")
+	ErrInvalidToken      = errors.New("invalid or expired link")
+	ErrAccountBanned     = errors.New("this account has been banned")
+	ErrAccountInactive   = errors.New("this account has been deactivated")
+	ErrPasswordReset     = errors.New("your password was reset for your security; use the link we emailed you to choose a new one, or ask for a new link below")
+)
+
+const (
+	userStatusActive      = "active"
+	userStatusPending     = "pending" // waiting for admin approval
+	userStatusDeleted     = "deleted"
+	userStatusBanned      = "banned"
+	userStatusDeactivated = "deactivated" // switched off by the directory through SCIM
+
+	// deletedUserName is shown in place of the author of anything written by
+	// a deleted account.
+	deletedUserName = "[deleted]"
+)
+
+// emailChangeTTL is how long an email change confirmation link stays valid.
+const emailChangeTTL = 24 * time.Hour
+
+// passwordResetTTL is how long a password reset link stays valid.
+const passwordResetTTL = time.Hour
+
+// disabledPassword replaces the hash of a password that may no longer be
+// used; no password verifies against it.
+const disabledPassword = "!"
 
 type UserRepository interface {
 	CreateUser(name, email, plainPassword, avatar string) (int, error)
+	CreateInvitedUser(name, email, plainPassword, avatar, inviteToken string) (int, error)
+	CreateUserWithStatus(name, email, plainPassword, status string) (int, error)
 	GetUserByEmailWithProfile(email string) (*User, error)
 	GetUsers() ([]*User, error)
+	ListUsers(q UserQuery) ([]*User, int, error)
 	GetUserByEmail(email string) (*User, error)
+	GetUserByID(id int) (*User, error)
 	Authenticate(email, password string) (int, error)
+	GetUserByIdentity(provider, subject string) (*User, error)
+	LinkIdentity(userID int, provider, subject string) error
+	HasIdentity(userID int) (bool, error)
+	UpdateName(userID int, name string) error
+	UpdatePassword(userID int, plainPassword string) error
+	UpdateAvatar(userID int, avatar string) error
+	UpdateFavoritesPublic(userID int, public bool) error
+	CreateEmailChange(userID int, newEmail string) (string, error)
+	ConfirmEmailChange(token string) (*User, error)
+	RevokeSessions(userID int) error
+	ScheduleDeletion(userID int, at time.Time) error
+	CancelDeletion(userID int) error
+	PurgeScheduledDeletions(now time.Time) (int, error)
+	GetUsersByStatus(status string) ([]*User, error)
+	SetStatus(userID int, status string) error
+	DeleteUser(userID int) error
+	DeletePendingUser(userID int) error
+	GetInviteTree() ([]*InviteNode, error)
+	BanBranch(userID int) ([]int, error)
+	RequirePasswordReset(userID int) (string, error)
+	CreatePasswordReset(userID int) (string, error)
+	ResetPassword(token, plainPassword string) (*User, error)
+}
+
+// userSelect is the column list shared by every query that loads a single
+// user together with its avatar; rows are read back with scanUser.
+const userSelect = `SELECT u.id, u.name, u.email, u.hashed_password, u.is_admin, u.status, u.karma,
+	u.session_epoch, u.deletion_scheduled_at, COALESCE(u.invited_by, 0), u.favorites_public, u.created_at, p.avatar
+	FROM users u INNER JOIN profiles p ON u.id = p.user_id`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanUser(row rowScanner) (*User, error) {
+	var user User
+	var deletionAt sql.NullTime
+	err := row.Scan(&user.ID, &user.Name, &user.Email, &user.HashedPassword, &user.IsAdmin, &user.Status, &user.Karma,
+		&user.SessionEpoch, &deletionAt, &user.InvitedBy, &user.FavoritesPublic, &user.CreatedAt, &user.Profile.Avatar)
+	if err != nil {
+		return nil, err
+	}
+	if deletionAt.Valid {
+		user.DeletionScheduledAt = &deletionAt.Time
+	}
+	user.Profile.UserID = user.ID
+	return &user, nil
 }
 
 type SQLUserRepository struct {
-	db *sql.DB
+	db     *sql.DB
+	hasher PasswordHasher
+	// registration points at the application's registration policy, which
+	// sets the status of new accounts and the email domains allowed to log
+	// in.
+	registration *RegistrationPolicy
+	// errorLog records failures that do not fail the operation, such as
+	// upgrading a password hash after a successful login.
+	errorLog *log.Logger
 }
 
 // NewSQLUserRepository creates a new instance of SQLUserRepository
 func NewSQLUserRepository(db *sql.DB) *SQLUserRepository {
-	return &SQLUserRepository{db: db}
+	return &SQLUserRepository{
+		db:           db,
+		hasher:       NewArgon2idHasher(),
+		registration: &RegistrationPolicy{Mode: registrationOpen},
+		errorLog:     log.New(io.Discard, "", 0),
+	}
 }
 
 func (r *SQLUserRepository) CreateUser(name, email, plainPassword, avatar string) (int, error) {
-	tx, err := r.db.Begin()
+	return r.createUser(name, email, plainPassword, avatar, r.registration.InitialStatus(), "")
+}
+
+// CreateInvitedUser creates an account and redeems the invitation with
+// inviteToken for it in the same transaction. If the invitation was used in
+// the meantime no account is created and ErrInvalidToken is returned.
+func (r *SQLUserRepository) CreateInvitedUser(name, email, plainPassword, avatar, inviteToken string) (int, error) {
+	return r.createUser(name, email, plainPassword, avatar, r.registration.InitialStatus(), inviteToken)
+}
+
+// CreateUserWithStatus creates an account with the given status instead of
+// the one the registration policy assigns, for accounts provisioned by an
+// identity provider.
+func (r *SQLUserRepository) CreateUserWithStatus(name, email, plainPassword, status string) (int, error) {
+	return r.createUser(name, email, plainPassword, "", status, "")
+}
+
+func (r *SQLUserRepository) createUser(name, email, plainPassword, avatar, status, inviteToken string) (int, error) {
+	hp, err := r.hasher.Hash(plainPassword)
 	if err != nil {
 		return 0, err
 	}
-	defer func() {
-		if p := recover(); p != nil {
-			tx.Rollback()
-			panic(p)
-		} else if err != nil {
-			tx.Rollback()
-		} else {
-			err = tx.Commit()
-		}
-	}()
 
-	userStmt, err := tx.Prepare("INSERT INTO users (name, email, hashed_password) VALUES (?, ?, ?)")
+	tx, err := r.db.Begin()
 	if err != nil {
 		return 0, err
 	}
-	defer userStmt.Close()
+	defer tx.Rollback()
 
-	hp, err := bcrypt.GenerateFromPassword([]byte(plainPassword), bcrypt.DefaultCost)
-	res, err := userStmt.Exec(name, email, hp)
+	res, err := tx.Exec("INSERT INTO users (name, email, hashed_password, status) VALUES (?, ?, ?, ?)",
+		name, email, hp, status)
 	if err != nil {
+		if strings.Contains(err.Error(), "UNIQUE constraint failed: users.email") {
+			err = ErrDuplicateEmail
+		}
 		return 0, err
 	}
 	userID, err := res.LastInsertId()
 	if err != nil {
 		return 0, err
 	}
-	profileStmt, err := tx.Prepare("INSERT INTO profiles (user_id, avatar) VALUES (?, ?)")
-	if err != nil {
+	if _, err := tx.Exec("INSERT INTO profiles (user_id, avatar) VALUES (?, ?)", userID, avatar); err != nil {
 		return 0, err
 	}
-	defer profileStmt.Close()
-
-	_, err = profileStmt.Exec(userID, avatar)
-	if err != nil {
+	if inviteToken != "" {
+		if err := redeemInvitation(tx, inviteToken, int(userID)); err != nil {
+			return 0, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
 		return 0, err
 	}
 	return int(userID), nil
@@ -92,15 +200,33 @@ func (r *SQLUserRepository) GetUserByEmailWithProfile(email string) (*User, erro
 }
 
 func (r *SQLUserRepository) GetUserByEmail(email string) (*User, error) {
-	stmt := `SELECT u.id, u.name, u.email, u.hashed_password, u.created_at, p.avatar FROM users u INNER JOIN profiles p ON u.id = p.user_id WHERE u.email = ?`
-	row := r.db.QueryRow(stmt, email)
-	var user User
-	err := row.Scan(&user.ID, &user.Name, &user.Email, &user.HashedPassword, &user.CreatedAt, &user.Profile.Avatar)
-	if err != nil {
-		return nil, err
-	}
-	user.Profile.UserID = user.ID
-	return &user, nil
+	return scanUser(r.db.QueryRow(userSelect+" WHERE u.email = ?", email))
+}
+
+func (r *SQLUserRepository) GetUserByID(id int) (*User, error) {
+	return scanUser(r.db.QueryRow(userSelect+" WHERE u.id = ?", id))
+}
+
+// GetUserByIdentity returns the local user linked to an external identity,
+// e.g. the issuer and subject of an OpenID Connect ID token.
+func (r *SQLUserRepository) GetUserByIdentity(provider, subject string) (*User, error) {
+	stmt := userSelect + " INNER JOIN user_identities i ON u.id = i.user_id WHERE i.provider = ? AND i.subject = ?"
+	return scanUser(r.db.QueryRow(stmt, provider, subject))
+}
+
+// LinkIdentity records that the external identity belongs to userID.
+func (r *SQLUserRepository) LinkIdentity(userID int, provider, subject string) error {
+	stmt := "INSERT INTO user_identities (provider, subject, user_id) VALUES (?, ?, ?)"
+	_, err := r.db.Exec(stmt, provider, subject, userID)
+	return err
+}
+
+// HasIdentity tells whether the user can log in through an external
+// identity provider.
+func (r *SQLUserRepository) HasIdentity(userID int) (bool, error) {
+	var exists bool
+	err := r.db.QueryRow("SELECT EXISTS (SELECT 1 FROM user_identities WHERE user_id = ?)", userID).Scan(&exists)
+	return exists, err
 }
 
 func (r *SQLUserRepository) Authenticate(email, password string) (int, error) {
@@ -108,13 +234,35 @@ func (r *SQLUserRepository) Authenticate(email, password string) (int, error) {
 	if err != nil {
 		return 0, err
 	}
-	err = bcrypt.CompareHashAndPassword([]byte(user.HashedPassword), []byte(password))
+	// Deleted accounts keep only a tombstone that no password matches.
+	if user.Status == userStatusDeleted {
+		return 0, ErrInvalidCredential
+	}
+	if user.HashedPassword == disabledPassword {
+		return 0, ErrPasswordReset
+	}
+	ok, needsRehash, err := r.hasher.Verify(password, user.HashedPassword)
 	if err != nil {
-		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
-			return 0, ErrInvalidCredential
-		}
 		return 0, err
 	}
+	if !ok {
+		return 0, ErrInvalidCredential
+	}
+	// Only tell someone who knows the password why they cannot log in.
+	if err := r.registration.CheckLogin(user); err != nil {
+		return 0, err
+	}
+	if needsRehash {
+		// Upgrading is best effort: the login already succeeded and the old
+		// hash stays valid, so a failure here is retried on the next login.
+		hp, err := r.hasher.Hash(password)
+		if err == nil {
+			_, err = r.db.Exec("UPDATE users SET hashed_password = ? WHERE id = ?", hp, user.ID)
+		}
+		if err != nil {
+			r.errorLog.Printf("rehashing the password of user %d: %v", user.ID, err)
+		}
+	}
 	return user.ID, nil
 }
 
@@ -142,3 +290,509 @@ func (r *SQLUserRepository) GetUsers() ([]*User, error) {
 	}
 	return users, nil
 }
+
+// UpdateFavoritesPublic sets whether other people may see the user's
+// favorites.
+func (r *SQLUserRepository) UpdateFavoritesPublic(userID int, public bool) error {
+	_, err := r.db.Exec("UPDATE users SET favorites_public = ? WHERE id = ?", public, userID)
+	return err
+}
+
+func (r *SQLUserRepository) UpdateName(userID int, name string) error {
+	_, err := r.db.Exec("UPDATE users SET name = ? WHERE id = ?", name, userID)
+	return err
+}
+
+func (r *SQLUserRepository) UpdatePassword(userID int, plainPassword string) error {
+	hp, err := r.hasher.Hash(plainPassword)
+	if err != nil {
+		return err
+	}
+	_, err = r.db.Exec("UPDATE users SET hashed_password = ? WHERE id = ?", hp, userID)
+	return err
+}
+
+func (r *SQLUserRepository) UpdateAvatar(userID int, avatar string) error {
+	_, err := r.db.Exec("UPDATE profiles SET avatar = ? WHERE user_id = ?", avatar, userID)
+	return err
+}
+
+// CreateEmailChange stores a pending change of userID's email address and
+// returns the token that confirms it. The address is only changed once the
+// token comes back from a link sent to the new address.
+func (r *SQLUserRepository) CreateEmailChange(userID int, newEmail string) (string, error) {
+	var taken bool
+	err := r.db.QueryRow("SELECT EXISTS(SELECT 1 FROM users WHERE email = ?)", newEmail).Scan(&taken)
+	if err != nil {
+		return "", err
+	}
+	if taken {
+		return "", ErrDuplicateEmail
+	}
+
+	token := randomToken(32)
+	stmt := "INSERT INTO email_changes (token_hash, user_id, new_email, expires_at) VALUES (?, ?, ?, ?)"
+	_, err = r.db.Exec(stmt, hashToken(token), userID, newEmail, time.Now().Add(emailChangeTTL).UTC())
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// ConfirmEmailChange applies the pending email change for token and returns
+// the updated user.
+func (r *SQLUserRepository) ConfirmEmailChange(token string) (*User, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var userID int
+	var newEmail string
+	var expiresAt time.Time
+	var isAdmin bool
+	stmt := `SELECT ec.user_id, ec.new_email, ec.expires_at, u.is_admin
+		FROM email_changes ec INNER JOIN users u ON u.id = ec.user_id WHERE ec.token_hash = ?`
+	err = tx.QueryRow(stmt, hashToken(token)).Scan(&userID, &newEmail, &expiresAt, &isAdmin)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrInvalidToken
+	} else if err != nil {
+		return nil, err
+	}
+	if time.Now().After(expiresAt) {
+		return nil, ErrInvalidToken
+	}
+	// The allowlist may have changed since the link was sent.
+	if !isAdmin && !r.registration.DomainAllowed(newEmail) {
+		return nil, ErrEmailDomainRejected
+	}
+
+	if _, err := tx.Exec("UPDATE users SET email = ? WHERE id = ?", newEmail, userID); err != nil {
+		if strings.Contains(err.Error(), "UNIQUE constraint failed: users.email") {
+			return nil, ErrDuplicateEmail
+		}
+		return nil, err
+	}
+	if _, err := tx.Exec("DELETE FROM email_changes WHERE user_id = ?", userID); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return r.GetUserByID(userID)
+}
+
+// RequirePasswordReset disables userID's password, logs them out everywhere
+// and returns the token of a link to choose a new password.
+func (r *SQLUserRepository) RequirePasswordReset(userID int) (string, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	stmt := "UPDATE users SET hashed_password = ?, session_epoch = session_epoch + 1 WHERE id = ?"
+	if _, err := tx.Exec(stmt, disabledPassword, userID); err != nil {
+		return "", err
+	}
+	token, err := createPasswordReset(tx, userID)
+	if err != nil {
+		return "", err
+	}
+	return token, tx.Commit()
+}
+
+// CreatePasswordReset returns the token of a new link for userID to choose
+// a new password. Earlier links stay valid until they expire or one of them
+// is used.
+func (r *SQLUserRepository) CreatePasswordReset(userID int) (string, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	token, err := createPasswordReset(tx, userID)
+	if err != nil {
+		return "", err
+	}
+	return token, tx.Commit()
+}
+
+func createPasswordReset(tx *sql.Tx, userID int) (string, error) {
+	token := randomToken(32)
+	stmt := "INSERT INTO password_resets (token_hash, user_id, expires_at) VALUES (?, ?, ?)"
+	if _, err := tx.Exec(stmt, hashToken(token), userID, time.Now().Add(passwordResetTTL).UTC()); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// ResetPassword sets a new password with a token from RequirePasswordReset
+// and returns the user it belongs to.
+func (r *SQLUserRepository) ResetPassword(token, plainPassword string) (*User, error) {
+	hp, err := r.hasher.Hash(plainPassword)
+	if err != nil {
+		return nil, err
+	}
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var userID int
+	var expiresAt time.Time
+	stmt := "SELECT user_id, expires_at FROM password_resets WHERE token_hash = ?"
+	err = tx.QueryRow(stmt, hashToken(token)).Scan(&userID, &expiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrInvalidToken
+	} else if err != nil {
+		return nil, err
+	}
+	if time.Now().After(expiresAt) {
+		return nil, ErrInvalidToken
+	}
+	// Deleting the token claims it, so that of two requests using the same
+	// link only one sets a password.
+	res, err := tx.Exec("DELETE FROM password_resets WHERE token_hash = ?", hashToken(token))
+	if err != nil {
+		return nil, err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return nil, err
+	} else if n != 1 {
+		return nil, ErrInvalidToken
+	}
+
+	// Whoever made the reset necessary may still be logged in.
+	stmt = "UPDATE users SET hashed_password = ?, session_epoch = session_epoch + 1 WHERE id = ?"
+	if _, err := tx.Exec(stmt, hp, userID); err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec("DELETE FROM password_resets WHERE user_id = ?", userID); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return r.GetUserByID(userID)
+}
+
+// RevokeSessions invalidates every login session of userID by bumping the
+// session epoch that authenticate compares against.
+func (r *SQLUserRepository) RevokeSessions(userID int) error {
+	_, err := r.db.Exec("UPDATE users SET session_epoch = session_epoch + 1 WHERE id = ?", userID)
+	return err
+}
+
+func (r *SQLUserRepository) ScheduleDeletion(userID int, at time.Time) error {
+	_, err := r.db.Exec("UPDATE users SET deletion_scheduled_at = ? WHERE id = ?", at.UTC(), userID)
+	return err
+}
+
+func (r *SQLUserRepository) CancelDeletion(userID int) error {
+	_, err := r.db.Exec("UPDATE users SET deletion_scheduled_at = NULL WHERE id = ?", userID)
+	return err
+}
+
+// PurgeScheduledDeletions deletes every account whose grace period ended
+// before now and returns how many were deleted.
+func (r *SQLUserRepository) PurgeScheduledDeletions(now time.Time) (int, error) {
+	stmt := "SELECT id FROM users WHERE deletion_scheduled_at IS NOT NULL AND deletion_scheduled_at <= ? AND status != ?"
+	rows, err := r.db.Query(stmt, now.UTC(), userStatusDeleted)
+	if err != nil {
+		return 0, err
+	}
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for i, id := range ids {
+		if err := r.deleteUser(id); err != nil {
+			return i, err
+		}
+	}
+	return len(ids), nil
+}
+
+// DeleteUser deletes an account straight away, without a grace period.
+func (r *SQLUserRepository) DeleteUser(userID int) error {
+	return r.deleteUser(userID)
+}
+
+// deleteUser turns the account into a tombstone. The row is kept so that
+// posts and comments survive with "[deleted]" as their author, while
+// everything personal is erased, the user's votes are withdrawn (and the
+// karma they gave recomputed) and every session and token is revoked.
+func (r *SQLUserRepository) deleteUser(userID int) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Remember whom this user voted for so that their karma can be
+	// recomputed once the votes are gone.
+	rows, err := tx.Query(`SELECT DISTINCT p.user_id FROM votes v INNER JOIN posts p ON p.id = v.post_id
+		WHERE v.user_id = ? AND p.user_id IS NOT NULL`, userID)
+	if err != nil {
+		return err
+	}
+	var authors []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		authors = append(authors, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec("DELETE FROM votes WHERE user_id = ?", userID); err != nil {
+		return err
+	}
+	for _, author := range authors {
+		if err := recomputeKarma(tx, author); err != nil {
+			return err
+		}
+	}
+
+	stmts := []string{
+		`UPDATE users SET name = '` + deletedUserName + `', email = 'deleted-' || id || '@deleted.invalid',
+			hashed_password = '` + disabledPassword + `', is_admin = 0, karma = 0, status = '` + userStatusDeleted + `',
+			session_epoch = session_epoch + 1, deletion_scheduled_at = NULL WHERE id = ?1`,
+		`UPDATE profiles SET avatar = '' WHERE user_id = ?1`,
+		`DELETE FROM user_identities WHERE user_id = ?1`,
+		`DELETE FROM email_changes WHERE user_id = ?1`,
+		`DELETE FROM password_resets WHERE user_id = ?1`,
+		`DELETE FROM login_events WHERE user_id = ?1`,
+		`DELETE FROM oauth_codes WHERE user_id = ?1`,
+		`DELETE FROM oauth_tokens WHERE user_id = ?1`,
+		`DELETE FROM favorite_posts WHERE user_id = ?1`,
+		`DELETE FROM favorite_comments WHERE user_id = ?1`,
+		`DELETE FROM hidden_posts WHERE user_id = ?1`,
+		`DELETE FROM search_alerts WHERE user_id = ?1`,
+		`DELETE FROM saved_searches WHERE user_id = ?1`,
+		`DELETE FROM invitations WHERE inviter_id = ?1 AND used_at IS NULL`,
+		`UPDATE invitations SET email = '', note = '' WHERE inviter_id = ?1 OR invitee_id = ?1`,
+		// Expiring exports lets the cleanup job remove the archives.
+		`UPDATE data_exports SET expires_at = CURRENT_TIMESTAMP WHERE user_id = ?1`,
+	}
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(stmt, userID); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// recomputeKarma sets a user's karma to the number of votes their posts
+// have received.
+func recomputeKarma(tx *sql.Tx, userID int) error {
+	stmt := `UPDATE users SET karma = (SELECT COUNT(*) FROM votes v INNER JOIN posts p ON p.id = v.post_id
+		WHERE p.user_id = users.id) WHERE id = ?`
+	_, err := tx.Exec(stmt, userID)
+	return err
+}
+
+func (r *SQLUserRepository) GetUsersByStatus(status string) ([]*User, error) {
+	rows, err := r.db.Query(userSelect+" WHERE u.status = ? ORDER BY u.created_at", status)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []*User
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
+
+// UserQuery selects a page of accounts for ListUsers. Deleted accounts are
+// never listed. Zero fields do not filter; Active selects active accounts
+// when true and every other status when false.
+type UserQuery struct {
+	Email  string
+	Active *bool
+	Offset int
+	Limit  int
+}
+
+// ListUsers returns the accounts matching q, oldest first, together with
+// how many match in total.
+func (r *SQLUserRepository) ListUsers(q UserQuery) ([]*User, int, error) {
+	where := " WHERE u.status != ?"
+	args := []any{userStatusDeleted}
+	if q.Email != "" {
+		where += " AND u.email = ? COLLATE NOCASE"
+		args = append(args, q.Email)
+	}
+	if q.Active != nil {
+		if *q.Active {
+			where += " AND u.status = ?"
+		} else {
+			where += " AND u.status != ?"
+		}
+		args = append(args, userStatusActive)
+	}
+
+	var total int
+	if err := r.db.QueryRow("SELECT COUNT(*) FROM users u"+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+	rows, err := r.db.Query(userSelect+where+" ORDER BY u.id LIMIT ? OFFSET ?", append(args, q.Limit, q.Offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var users []*User
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		users = append(users, u)
+	}
+	return users, total, rows.Err()
+}
+
+func (r *SQLUserRepository) SetStatus(userID int, status string) error {
+	_, err := r.db.Exec("UPDATE users SET status = ? WHERE id = ?", status, userID)
+	return err
+}
+
+// DeletePendingUser removes an account that was never approved. Pending
+// accounts cannot have posted anything, so nothing has to be anonymized.
+func (r *SQLUserRepository) DeletePendingUser(userID int) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec("DELETE FROM users WHERE id = ? AND status = ?", userID, userStatusPending)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return sql.ErrNoRows
+	}
+	for _, stmt := range []string{
+		"DELETE FROM profiles WHERE user_id = ?",
+		"DELETE FROM user_identities WHERE user_id = ?",
+		"DELETE FROM email_changes WHERE user_id = ?",
+		"DELETE FROM login_events WHERE user_id = ?",
+	} {
+		if _, err := tx.Exec(stmt, userID); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// InviteNode is a user and the users they invited.
+type InviteNode struct {
+	User     *User
+	Children []*InviteNode
+}
+
+// GetInviteTree returns every user arranged by who invited whom. Users who
+// registered without an invitation are the roots.
+func (r *SQLUserRepository) GetInviteTree() ([]*InviteNode, error) {
+	rows, err := r.db.Query(userSelect + " ORDER BY u.created_at, u.id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var nodes []*InviteNode
+	byID := make(map[int]*InviteNode)
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		node := &InviteNode{User: u}
+		nodes = append(nodes, node)
+		byID[u.ID] = node
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var roots []*InviteNode
+	for _, node := range nodes {
+		if parent, ok := byID[node.User.InvitedBy]; ok {
+			parent.Children = append(parent.Children, node)
+		} else {
+			roots = append(roots, node)
+		}
+	}
+	return roots, nil
+}
+
+// BanBranch bans userID and everyone they invited, directly or indirectly,
+// logs them out and returns their IDs. Admins and deleted accounts in the
+// branch are left alone.
+func (r *SQLUserRepository) BanBranch(userID int) ([]int, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`WITH RECURSIVE branch(id) AS (
+			SELECT ?
+			UNION SELECT u.id FROM users u INNER JOIN branch b ON u.invited_by = b.id
+		)
+		SELECT u.id FROM users u INNER JOIN branch b ON u.id = b.id
+		WHERE u.is_admin = 0 AND u.status NOT IN (?, ?)`, userID, userStatusDeleted, userStatusBanned)
+	if err != nil {
+		return nil, err
+	}
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, id := range ids {
+		stmt := "UPDATE users SET status = ?, session_epoch = session_epoch + 1 WHERE id = ?"
+		if _, err := tx.Exec(stmt, userStatusBanned, id); err != nil {
+			return nil, err
+		}
+	}
+	return ids, tx.Commit()
+}
@@ -1,9 +1,13 @@
 package main
 
 import (
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
 )
 
 func TestSQLUserRepository_CreateUser(t *testing.T) {
@@ -66,6 +70,31 @@ func TestSQLUserRepository_Authentication_WrongPassword(t *testing.T) {
 	assert.Equal(t, ErrInvalidCredential, err)
 }
 
+func TestSQLUserRepository_Authentication_UpgradesLegacyHash(t *testing.T) {
+	defer cleanupTestData(t)
+
+	repo := NewSQLUserRepository(testDB)
+
+	userID, err := repo.CreateUser("John Doe", "john@doe.com", "testpassword", "avatar")
+	assert.NoError(t, err)
+
+	legacy, err := bcrypt.GenerateFromPassword([]byte("testpassword"), bcrypt.MinCost)
+	assert.NoError(t, err)
+	_, err = testDB.Exec("UPDATE users SET hashed_password = ? WHERE id = ?", string(legacy), userID)
+	assert.NoError(t, err)
+
+	authUserID, err := repo.Authenticate("john@doe.com", "testpassword")
+	assert.NoError(t, err)
+	assert.Equal(t, userID, authUserID)
+
+	user, err := repo.GetUserByEmail("john@doe.com")
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(user.HashedPassword, "$argon2id$"))
+
+	_, err = repo.Authenticate("john@doe.com", "testpassword")
+	assert.NoError(t, err)
+}
+
 func TestSQLUserRepository_GetUserByEmailWithProfile(t *testing.T) {
 	defer cleanupTestData(t)
 
@@ -115,3 +144,47 @@ func generateString(n int) string {
 	}
 	return string(buf)
 }
+
+func TestSQLUserRepository_PurgeScheduledDeletions(t *testing.T) {
+	defer cleanupTestData(t)
+	author := createTestUser(t, "Author", "author@test.com")
+	leaving := createTestUser(t, "Leaving", "leaving@test.com")
+
+	postID, err := testApp.postRepo.CreatePost("A post", "https://example.com", leaving.ID)
+	require.NoError(t, err)
+	otherID, err := testApp.postRepo.CreatePost("Another post", "https://example.org", author.ID)
+	require.NoError(t, err)
+	require.NoError(t, testApp.postRepo.AddVote(leaving.ID, otherID))
+
+	voted, err := testApp.userRepo.GetUserByID(author.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 1, voted.Karma)
+
+	require.NoError(t, testApp.userRepo.ScheduleDeletion(leaving.ID, time.Now().Add(time.Hour)))
+	n, err := testApp.userRepo.PurgeScheduledDeletions(time.Now())
+	require.NoError(t, err)
+	assert.Equal(t, 0, n, "grace period has not passed yet")
+
+	n, err = testApp.userRepo.PurgeScheduledDeletions(time.Now().Add(2 * time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	deleted, err := testApp.userRepo.GetUserByID(leaving.ID)
+	require.NoError(t, err)
+	assert.Equal(t, userStatusDeleted, deleted.Status)
+	assert.Equal(t, deletedUserName, deleted.Name)
+	assert.NotEqual(t, "leaving@test.com", deleted.Email)
+
+	_, err = testApp.userRepo.Authenticate("leaving@test.com", "correct horse battery staple")
+	assert.Error(t, err)
+	_, err = testApp.userRepo.Authenticate(deleted.Email, "correct horse battery staple")
+	assert.ErrorIs(t, err, ErrInvalidCredential)
+
+	post, err := testApp.postRepo.GetByID(postID)
+	require.NoError(t, err)
+	assert.Equal(t, deletedUserName, post.UserName)
+
+	recomputed, err := testApp.userRepo.GetUserByID(author.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 0, recomputed.Karma)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+func (app *application) userProfile(w http.ResponseWriter, r *http.Request) {
+	member, err := app.userRepo.GetUserByID(app.readIntWithDefault(r, "id", 0))
+	if err != nil {
+		app.session.Put(r, "flash", "user not found")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	data := &templateData{Member: member}
+	data.User, _ = r.Context().Value(contextUserKey).(*User)
+	if member.InvitedBy != 0 {
+		data.Inviter, err = app.userRepo.GetUserByID(member.InvitedBy)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+	}
+	app.render(w, r, "user.html", data)
+}
+
+func (app *application) inviteTree(w http.ResponseWriter, r *http.Request) {
+	tree, err := app.userRepo.GetInviteTree()
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	app.render(w, r, "users-tree.html", &templateData{InviteTree: tree})
+}
+
+// adminBanBranch bans a user together with everyone they invited, to get
+// rid of a spam ring in one go.
+func (app *application) adminBanBranch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	userID, err := strconv.Atoi(r.PostForm.Get("user_id"))
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	banned, err := app.userRepo.BanBranch(userID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	for _, id := range banned {
+		if err := app.oauthRepo.RevokeUserTokens(id); err != nil {
+			app.serverError(w, err)
+			return
+		}
+	}
+	admin := app.getUserFromContext(r.Context())
+	app.infoLog.Printf("admin %d banned the invite branch of user %d: %v", admin.ID, userID, banned)
+	app.session.Put(r, "flash", fmt.Sprintf("Banned %d accounts", len(banned)))
+	http.Redirect(w, r, "/users/tree", http.StatusSeeOther)
+}